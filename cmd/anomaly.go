@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"gorm.io/gorm"
+)
+
+// anomalyDB is an optional secondary database which mirrors only the "interesting" records:
+// orphans, uncles, and competitions. It is configured with --anomaly.db, and is nil otherwise.
+var anomalyDB *gorm.DB
+
+// mirrorAnomaly copies the header into the anomaly database if it is an orphan or an uncle.
+// If the header's height hosts a competition, all of the competing headers are (re)mirrored,
+// which also keeps their orphan flags in sync with the primary database.
+// Canonical headers which do not compete with anything are not mirrored.
+func mirrorAnomaly(db, adb *gorm.DB, header *Header) error {
+	if header.Orphan || header.UncleBy != "" {
+		if err := header.CreateOrUpdate(adb, "orphan", "uncle_by"); err != nil {
+			return err
+		}
+	}
+
+	c, err := competitionAt(db, header.Number)
+	if err != nil || c == nil {
+		return err
+	}
+	for _, h := range c.Headers {
+		if err := h.CreateOrUpdate(adb, "orphan", "uncle_by", "win_reason"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestAnomalyDBMirrorsOnlyAnomalies(t *testing.T) {
+	db := newTestDB(t)
+	anomalyDB = newTestDB(t)
+	defer func() { anomalyDB = nil }()
+
+	client := newMockChainReader()
+	miner := common.HexToAddress(randomHex(20))
+
+	// A lonely canonical block is not an anomaly.
+	standalone := generateMockBlock(100, miner)
+	client.addBlock(standalone, true)
+	if _, err := handleHeader(client, db, standalone.Header(), false, "", SourceHead); err != nil {
+		t.Fatal(err)
+	}
+
+	// An orphan and its canonical competitor are.
+	canon := generateMockBlock(101, miner)
+	orphan := generateMockBlock(101, miner)
+	client.addBlock(canon, true)
+	client.addBlock(orphan, false)
+	if _, err := handleHeader(client, db, orphan.Header(), true, "", SourceSide); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleHeader(client, db, canon.Header(), false, "", SourceHead); err != nil {
+		t.Fatal(err)
+	}
+
+	var primaryCount, anomalyCount int64
+	db.Model(&Header{}).Count(&primaryCount)
+	anomalyDB.Model(&Header{}).Count(&anomalyCount)
+	if primaryCount != 3 {
+		t.Fatalf("want 3 headers in primary db, got %d", primaryCount)
+	}
+	if anomalyCount != 2 {
+		t.Fatalf("want 2 headers in anomaly db, got %d", anomalyCount)
+	}
+
+	mirrored := []*Header{}
+	anomalyDB.Model(&Header{}).Order("orphan DESC").Find(&mirrored)
+	if mirrored[0].Hash != orphan.Hash().Hex() || !mirrored[0].Orphan {
+		t.Errorf("want mirrored orphan %s, got %s", orphan.Hash().Hex(), headerStr(mirrored[0]))
+	}
+	if mirrored[1].Hash != canon.Hash().Hex() || mirrored[1].Orphan {
+		t.Errorf("want mirrored canonical %s, got %s", canon.Hash().Hex(), headerStr(mirrored[1]))
+	}
+}
@@ -0,0 +1,374 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// writeJSON writes v to the response as indented JSON.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	j, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		logError("JSON encoding failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(j)
+}
+
+// writeJSONError writes the error message to the response as a JSON object, {"error": msg}, with the status code.
+func writeJSONError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// These are the default limits of /api/headers and /api/txes,
+// and the maximum limit of all paginated endpoints (0 for no maximum).
+var (
+	apiDefaultLimitHeaders uint64
+	apiDefaultLimitTxes    uint64
+	apiMaxLimit            uint64
+)
+
+// httpAllowRawSQL enables the raw_sql query parameter of /api/headers and /api/txes,
+// whose queries are cancelled after httpRawSQLTimeout.
+var (
+	httpAllowRawSQL   bool
+	httpRawSQLTimeout time.Duration
+)
+
+// errRawSQLDisabled is returned for raw_sql queries without --http.allow-raw-sql.
+var errRawSQLDisabled = errors.New("raw_sql is disabled; see --http.allow-raw-sql")
+
+// beginRawSQL begins the transaction to run a raw_sql query in, to be rolled back afterwards in case anyone
+// feels frisky with mischievous queries. The query is cancelled after httpRawSQLTimeout, or with the request.
+// The cancel function must be called once done.
+func beginRawSQL(r *http.Request, db *gorm.DB) (*gorm.DB, context.CancelFunc, error) {
+	if !httpAllowRawSQL {
+		return nil, nil, errRawSQLDisabled
+	}
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if httpRawSQLTimeout > 0 {
+		ctx, cancel = context.WithTimeout(r.Context(), httpRawSQLTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(r.Context())
+	}
+	return db.WithContext(ctx).Begin(), cancel, nil
+}
+
+// paginate applies the limit and offset query parameters to the query.
+// The limit defaults to defaultLimit, and is clamped to apiMaxLimit.
+func paginate(r *http.Request, res *gorm.DB, defaultLimit uint64) *gorm.DB {
+	limit := defaultLimit
+	if q := r.URL.Query().Get("limit"); q != "" {
+		limit, _ = strconv.ParseUint(q, 10, 64)
+	}
+	if apiMaxLimit > 0 && (limit == 0 || limit > apiMaxLimit) {
+		limit = apiMaxLimit
+	}
+	offset := uint64(0)
+	if q := r.URL.Query().Get("offset"); q != "" {
+		offset, _ = strconv.ParseUint(q, 10, 64)
+	}
+	return res.Limit(int(limit)).Offset(int(offset))
+}
+
+// parseBlockNumber parses a block number query parameter value.
+// Accepted forms are decimal (eg. 15537020), hex (eg. 0xed117c),
+// and relative to the latest block (eg. latest, latest-100).
+// Relative forms are resolved against the latest head, and are floored at 0.
+func parseBlockNumber(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "latest") {
+		latest := status.LatestNumber()
+		rel := strings.TrimPrefix(s, "latest")
+		if rel == "" {
+			return latest, nil
+		}
+		if !strings.HasPrefix(rel, "-") {
+			return 0, fmt.Errorf("invalid block number: %q", s)
+		}
+		offset, err := strconv.ParseUint(rel[1:], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid block number: %q", s)
+		}
+		if offset > latest {
+			return 0, nil
+		}
+		return latest - offset, nil
+	}
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		n, err := strconv.ParseUint(s[2:], 16, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid block number: %q", s)
+		}
+		return n, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid block number: %q", s)
+	}
+	return n, nil
+}
+
+// numberRange parses the number_min and number_max query parameters (see parseBlockNumber).
+// Missing bounds are left open.
+func numberRange(r *http.Request) (min, max uint64, err error) {
+	min, max = 0, 1<<63-1
+	if q := r.URL.Query().Get("number_min"); q != "" {
+		if min, err = parseBlockNumber(q); err != nil {
+			return
+		}
+	}
+	if q := r.URL.Query().Get("number_max"); q != "" {
+		if max, err = parseBlockNumber(q); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// HeaderTx is a row of the header_txes join table, relating a header to a transaction it includes.
+type HeaderTx struct {
+	HeaderHash string `json:"header_hash"`
+	TxHash     string `json:"tx_hash"`
+}
+
+// headerTxesHandler serves the raw header_txes join rows,
+// optionally filtered by header_hash and/or tx_hash.
+func headerTxesHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows := []HeaderTx{}
+
+		res := db.Table("header_txes")
+		res = res.Order("header_hash ASC").Order("tx_hash ASC")
+		res = paginate(r, res, 1000)
+
+		if q := r.URL.Query().Get("header_hash"); q != "" {
+			res = res.Where("header_hash = ?", strings.ToLower(q))
+		}
+		if q := r.URL.Query().Get("tx_hash"); q != "" {
+			res = res.Where("tx_hash = ?", strings.ToLower(q))
+		}
+
+		if err := res.Find(&rows).Error; err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, rows)
+	}
+}
+
+// TxInclusions counts the distinct stored blocks, canonical or orphan, which included a transaction.
+// Inclusion by competing blocks at the same height is expected;
+// inclusion at many heights may indicate replacement or rebroadcast.
+type TxInclusions struct {
+	TxHash  string   `json:"tx_hash"`
+	Count   int      `json:"count"`
+	Heights int      `json:"heights"`
+	Blocks  []string `json:"blocks"`
+}
+
+// txInclusions returns the inclusions of the transaction, derived from the header_txes join.
+func txInclusions(db *gorm.DB, hash string) (*TxInclusions, error) {
+	headers := []Header{}
+	err := db.Model(&Header{}).
+		Select("headers.hash", "headers.number").
+		Joins("JOIN header_txes ON header_txes.header_hash = headers.hash").
+		Where("header_txes.tx_hash = ?", hash).
+		Order("headers.number ASC, headers.hash ASC").
+		Find(&headers).Error
+	if err != nil {
+		return nil, err
+	}
+
+	inc := &TxInclusions{TxHash: hash, Count: len(headers), Blocks: []string{}}
+	heights := map[uint64]bool{}
+	for _, h := range headers {
+		inc.Blocks = append(inc.Blocks, h.Hash)
+		heights[h.Number] = true
+	}
+	inc.Heights = len(heights)
+	return inc, nil
+}
+
+// txHandler serves /api/tx/{hash}: the stored transaction with the hash, with the stored blocks (canonical and orphan)
+// which included it; and /api/tx/{hash}/inclusions.
+func txHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/tx/"), "/")
+		if len(parts) == 2 && parts[0] != "" && parts[1] == "inclusions" {
+			txInclusionsHandler(db, strings.ToLower(parts[0]))(w, r)
+			return
+		}
+		if len(parts) != 1 || parts[0] == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		txes := []*Tx{}
+		err := db.Model(&Tx{}).
+			Preload("Headers", func(db *gorm.DB) *gorm.DB {
+				return db.Order("headers.number ASC, headers.hash ASC")
+			}).
+			Where("hash = ?", strings.ToLower(parts[0])).
+			Limit(1).
+			Find(&txes).Error
+		if err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(txes) == 0 {
+			writeJSONError(w, http.StatusNotFound, "tx not found")
+			return
+		}
+		writeJSON(w, txes[0])
+	}
+}
+
+// txInclusionsHandler serves /api/tx/{hash}/inclusions.
+func txInclusionsHandler(db *gorm.DB, hash string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		inc, err := txInclusions(db, hash)
+		if err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, inc)
+	}
+}
+
+// headerHandler serves /api/header/{hash}: the stored header with the hash, with its transactions.
+func headerHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hash := strings.TrimPrefix(r.URL.Path, "/api/header/")
+		if hash == "" || strings.Contains(hash, "/") {
+			writeJSONError(w, http.StatusNotFound, "header not found")
+			return
+		}
+
+		headers := []*Header{}
+		err := db.Model(&Header{}).
+			Preload("Txes").
+			Where("hash = ?", strings.ToLower(hash)).
+			Limit(1).
+			Find(&headers).Error
+		if err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(headers) == 0 {
+			writeJSONError(w, http.StatusNotFound, "header not found")
+			return
+		}
+		if err := fillUncledBy(db, headers); err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, headers[0])
+	}
+}
+
+// latestAnomalies is the default number of recent orphans and competitions served by /api/latest.
+var latestAnomalies int
+
+// latestMaxAnomalies is the maximum number of recent orphans and competitions served by /api/latest,
+// which is meant to stay lightweight; larger lists are paginated by /api/headers and /api/competitions.
+const latestMaxAnomalies = 100
+
+// Latest is a compact summary of the current tip and the most recent anomalies.
+type Latest struct {
+	LatestHeader *Header        `json:"latest_header"`
+	Orphans      []*Header      `json:"orphans"`
+	Competitions []*Competition `json:"competitions"`
+}
+
+// latestHandler serves the in-memory latest header along with the most recent orphans and competitions,
+// for clients (eg. a dashboard landing page) wanting a single, lightweight request.
+// The number of anomalies can be set with the count query parameter; 0 is the default, and it is clamped to latestMaxAnomalies.
+func latestHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		count := latestAnomalies
+		if q := r.URL.Query().Get("count"); q != "" {
+			n, err := strconv.ParseUint(q, 10, 64)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if n > 0 {
+				count = latestMaxAnomalies
+				if n < latestMaxAnomalies {
+					count = int(n)
+				}
+			}
+		}
+		if count > latestMaxAnomalies {
+			count = latestMaxAnomalies
+		}
+
+		latestHead, _ := status.LatestHead()
+		latest := Latest{LatestHeader: latestHead, Orphans: []*Header{}}
+		err := db.Model(&Header{}).
+			Where("orphan = ?", true).
+			Order("number DESC").
+			Limit(count).
+			Find(&latest.Orphans).Error
+		if err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		latest.Competitions, err = recentCompetitions(db, count)
+		if err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, latest)
+	}
+}
+
+// fillParentMiners sets the ParentMiner of the headers from their stored parents, in a single query.
+// Headers whose parent is not stored are left with an empty ParentMiner.
+func fillParentMiners(db *gorm.DB, headers []*Header) error {
+	if len(headers) == 0 {
+		return nil
+	}
+	parentHashes := make([]string, 0, len(headers))
+	for _, h := range headers {
+		parentHashes = append(parentHashes, h.ParentHash)
+	}
+	parents := []Header{}
+	err := db.Model(&Header{}).
+		Select("hash", "coinbase").
+		Where("hash IN ?", parentHashes).
+		Find(&parents).Error
+	if err != nil {
+		return err
+	}
+	miners := map[string]string{}
+	for _, p := range parents {
+		miners[p.Hash] = p.Coinbase
+	}
+	for _, h := range headers {
+		h.ParentMiner = miners[h.ParentHash]
+	}
+	return nil
+}
@@ -0,0 +1,627 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"gorm.io/gorm"
+)
+
+func TestHeaderTxesHandler(t *testing.T) {
+	db := newTestDB(t)
+
+	head1 := generateMockHead()
+	head2 := generateMockHead()
+	tx1 := generateMockTx()
+	tx2 := generateMockTx()
+	head1.Txes = []Tx{tx1, tx2}
+	head2.Txes = []Tx{tx1}
+	for _, h := range []*Header{head1, head2} {
+		if err := h.CreateOrUpdate(db, "orphan"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query := func(q string) []HeaderTx {
+		rec := httptest.NewRecorder()
+		headerTxesHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/header-txes?"+q, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d", q, rec.Code)
+		}
+		rows := []HeaderTx{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &rows); err != nil {
+			t.Fatal(err)
+		}
+		return rows
+	}
+
+	if rows := query(""); len(rows) != 3 {
+		t.Fatalf("want 3 join rows, got %d", len(rows))
+	}
+	if rows := query("tx_hash=" + strings.ToUpper(tx1.Hash)); len(rows) != 2 {
+		t.Fatalf("want 2 join rows for tx1, got %d", len(rows))
+	}
+	rows := query("header_hash=" + strings.ToUpper(head2.Hash))
+	if len(rows) != 1 || rows[0].TxHash != tx1.Hash {
+		t.Fatalf("want head2 to include only tx1, got %+v", rows)
+	}
+	if rows := query("limit=2&offset=2"); len(rows) != 1 {
+		t.Fatalf("want 1 paginated join row, got %d", len(rows))
+	}
+}
+
+func TestParseBlockNumber(t *testing.T) {
+	defer status.SetLatestHead(nil, time.Time{})
+	head := generateMockHead()
+	head.Number = 1000
+	status.SetLatestHead(head, time.Now())
+
+	cases := []struct {
+		in      string
+		want    uint64
+		wantErr bool
+	}{
+		{"15537020", 15537020, false},
+		{"0xed117c", 15536508, false},
+		{"0XED117C", 15536508, false},
+		{"latest", 1000, false},
+		{"latest-100", 900, false},
+		{"latest-2000", 0, false},
+		{"latest+1", 0, true},
+		{"0xzz", 0, true},
+		{"-1", 0, true},
+		{"abc", 0, true},
+	}
+	for _, c := range cases {
+		got, err := parseBlockNumber(c.in)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%q: unexpected error: %v", c.in, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%q: want %d, got %d", c.in, c.want, got)
+		}
+	}
+}
+
+func TestLatestHandler(t *testing.T) {
+	db := newTestDB(t)
+
+	defer func(n int) { latestAnomalies = n }(latestAnomalies)
+	defer status.SetLatestHead(nil, time.Time{})
+	latestAnomalies = 2
+	head := generateMockHead()
+	head.Number = 200
+	status.SetLatestHead(head, time.Now())
+
+	// Three competitions, each with a canonical block and an orphan, at 100, 110 and 120.
+	for _, n := range []uint64{100, 110, 120} {
+		canon, orphan := generateMockHead(), generateMockHead()
+		canon.Number, orphan.Number = n, n
+		orphan.Orphan = true
+		for _, h := range []*Header{canon, orphan} {
+			if err := h.CreateOrUpdate(db, "orphan"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	get := func(q string) map[string]json.RawMessage {
+		rec := httptest.NewRecorder()
+		latestHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/latest"+q, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d", rec.Code)
+		}
+		shape := map[string]json.RawMessage{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &shape); err != nil {
+			t.Fatal(err)
+		}
+		return shape
+	}
+
+	shape := get("")
+	for _, key := range []string{"latest_header", "orphans", "competitions"} {
+		if _, ok := shape[key]; !ok {
+			t.Fatalf("missing %q in response", key)
+		}
+	}
+
+	latest := Latest{}
+	rec := httptest.NewRecorder()
+	latestHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/latest", nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &latest); err != nil {
+		t.Fatal(err)
+	}
+	if latest.LatestHeader == nil || latest.LatestHeader.Number != 200 {
+		t.Fatalf("want latest header 200, got %+v", latest.LatestHeader)
+	}
+	if len(latest.Orphans) != 2 || latest.Orphans[0].Number != 120 || latest.Orphans[1].Number != 110 {
+		t.Fatalf("want the 2 most recent orphans, got %d", len(latest.Orphans))
+	}
+	if len(latest.Competitions) != 2 || latest.Competitions[0].Number != 120 || len(latest.Competitions[0].Headers) != 2 {
+		t.Fatalf("want the 2 most recent competitions, got %+v", latest.Competitions)
+	}
+
+	rec = httptest.NewRecorder()
+	latestHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/latest?count=3", nil))
+	latest = Latest{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &latest); err != nil {
+		t.Fatal(err)
+	}
+	if len(latest.Orphans) != 3 || len(latest.Competitions) != 3 {
+		t.Fatalf("want 3 anomalies of each kind with count=3, got %d and %d", len(latest.Orphans), len(latest.Competitions))
+	}
+
+	// A count of 0 is the default, and counts are clamped.
+	for i := 0; i < latestMaxAnomalies; i++ {
+		orphan := generateMockHead()
+		orphan.Number, orphan.Orphan = uint64(i), true
+		if err := orphan.CreateOrUpdate(db, "orphan"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for q, want := range map[string]int{"count=0": 2, "count=1000": latestMaxAnomalies} {
+		rec = httptest.NewRecorder()
+		latestHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/latest?"+q, nil))
+		latest = Latest{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &latest); err != nil {
+			t.Fatal(err)
+		}
+		if len(latest.Orphans) != want {
+			t.Errorf("%s: want %d orphans, got %d", q, want, len(latest.Orphans))
+		}
+	}
+}
+
+func TestTxInclusionsHandler(t *testing.T) {
+	db := newTestDB(t)
+
+	tx := generateMockTx()
+	canon, orphan, later := generateMockHead(), generateMockHead(), generateMockHead()
+	canon.Number, orphan.Number, later.Number = 100, 100, 105
+	orphan.Orphan = true
+	for _, h := range []*Header{canon, orphan, later} {
+		h.Txes = []Tx{tx}
+		if err := h.CreateOrUpdate(db, "orphan"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := generateMockHead()
+	other.Txes = []Tx{generateMockTx()}
+	if err := other.CreateOrUpdate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	txHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/tx/"+strings.ToUpper(tx.Hash)+"/inclusions", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+	inc := TxInclusions{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &inc); err != nil {
+		t.Fatal(err)
+	}
+	if inc.Count != 3 || inc.Heights != 2 || len(inc.Blocks) != 3 {
+		t.Fatalf("want 3 inclusions at 2 heights, got %+v", inc)
+	}
+
+	rec = httptest.NewRecorder()
+	txHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/tx/"+tx.Hash+"/other", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("want unknown tx routes not found, got %d", rec.Code)
+	}
+}
+
+func TestTxHandler(t *testing.T) {
+	db := newTestDB(t)
+
+	// The tx is included by both a canonical block and an orphan.
+	tx := generateMockTx()
+	canon, orphan := generateMockHead(), generateMockHead()
+	canon.Number, orphan.Number = 100, 101
+	orphan.Orphan = true
+	for _, h := range []*Header{canon, orphan} {
+		h.Txes = []Tx{tx}
+		if err := h.CreateOrUpdate(db, "orphan"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	txHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/tx/"+strings.ToUpper(tx.Hash), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+	got := Tx{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Hash != tx.Hash || len(got.Headers) != 2 {
+		t.Fatalf("want the tx with its 2 including blocks, got %+v", got)
+	}
+	if got.Headers[0].Hash != canon.Hash || got.Headers[0].Orphan || got.Headers[1].Hash != orphan.Hash || !got.Headers[1].Orphan {
+		t.Errorf("want the canonical and the orphan block, got %+v and %+v", got.Headers[0], got.Headers[1])
+	}
+
+	rec = httptest.NewRecorder()
+	txHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/tx/"+randomHex(32), nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("want untracked tx not found, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"error"`) {
+		t.Errorf("want a JSON error, got %s", rec.Body.String())
+	}
+}
+
+func TestDefaultLimits(t *testing.T) {
+	defer func() { apiDefaultLimitHeaders, apiDefaultLimitTxes, apiMaxLimit = 0, 0, 0 }()
+	apiDefaultLimitHeaders, apiDefaultLimitTxes = 2, 1
+
+	db := newTestDB(t)
+	for i := 0; i < 5; i++ {
+		h := generateMockHead()
+		h.Txes = []Tx{generateMockTx()}
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count := func(h http.HandlerFunc, target string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		rows := []json.RawMessage{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &rows); err != nil {
+			t.Fatal(err)
+		}
+		return len(rows)
+	}
+
+	if n := count(headersHandler(db), "/api/headers"); n != 2 {
+		t.Fatalf("want the configured default of 2 headers, got %d", n)
+	}
+	if n := count(txesHandler(db), "/api/txes"); n != 1 {
+		t.Fatalf("want the configured default of 1 tx, got %d", n)
+	}
+	if n := count(headersHandler(db), "/api/headers?limit=4"); n != 4 {
+		t.Fatalf("want the explicit limit of 4 headers, got %d", n)
+	}
+
+	apiMaxLimit = 3
+	if n := count(headersHandler(db), "/api/headers?limit=4"); n != 3 {
+		t.Fatalf("want the limit clamped to 3 headers, got %d", n)
+	}
+}
+
+func TestHeadersRootFilters(t *testing.T) {
+	db := newTestDB(t)
+
+	// Two competing blocks share a state root, but not their receipts roots.
+	a, b, other := generateMockHead(), generateMockHead(), generateMockHead()
+	b.Root = a.Root
+	for _, h := range []*Header{a, b, other} {
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query := func(q string) []*Header {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		headersHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/headers?"+q, nil))
+		headers := []*Header{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &headers); err != nil {
+			t.Fatal(err)
+		}
+		return headers
+	}
+
+	if headers := query("state_root=" + strings.ToUpper(a.Root)); len(headers) != 2 {
+		t.Fatalf("want 2 headers sharing the state root, got %d", len(headers))
+	}
+	headers := query("receipts_root=" + b.ReceiptHash)
+	if len(headers) != 1 || headers[0].Hash != b.Hash {
+		t.Fatalf("want header %s by receipts root, got %+v", b.Hash, headers)
+	}
+	if headers := query("state_root=" + a.Root + "&receipts_root=" + other.ReceiptHash); len(headers) != 0 {
+		t.Fatalf("want no header matching both roots, got %d", len(headers))
+	}
+}
+
+func TestHeadersCoinbaseFilter(t *testing.T) {
+	db := newTestDB(t)
+
+	miner := common.HexToAddress("0xdf7d7e053933b5cc24372f878c90e62dadad5d42")
+	mined, other := generateMockHead(), generateMockHead()
+	mined.Coinbase = miner.Hex() // Checksummed, ie. in mixed case.
+	for _, h := range []*Header{mined, other} {
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, q := range []string{miner.Hex(), strings.ToLower(miner.Hex()), "0x" + strings.ToUpper(miner.Hex()[2:])} {
+		rec := httptest.NewRecorder()
+		headersHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/headers?coinbase="+q, nil))
+		headers := []*Header{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &headers); err != nil {
+			t.Fatal(err)
+		}
+		if len(headers) != 1 || headers[0].Hash != mined.Hash {
+			t.Errorf("coinbase=%s: want header %s, got %+v", q, mined.Hash, headers)
+		}
+	}
+}
+
+func TestHeadersPaginationTieBreak(t *testing.T) {
+	db := newTestDB(t)
+
+	// Competing blocks share the number and orphan flag.
+	hashes := []string{}
+	for i := 0; i < 5; i++ {
+		h := generateMockHead()
+		h.Number, h.Orphan = 100, true
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, h.Hash)
+	}
+	sort.Strings(hashes)
+
+	for offset, want := range hashes {
+		rec := httptest.NewRecorder()
+		headersHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/headers?limit=1&offset=%d", offset), nil))
+		headers := []*Header{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &headers); err != nil {
+			t.Fatal(err)
+		}
+		if len(headers) != 1 || headers[0].Hash != want {
+			t.Errorf("offset %d: want header %s, got %+v", offset, want, headers)
+		}
+	}
+}
+
+func TestHeadersQueryUsesNumberOrphanIndex(t *testing.T) {
+	db := newTestDB(t)
+	for i := 0; i < 200; i++ {
+		h := generateMockHead()
+		h.Number = uint64(i / 2)
+		h.Orphan = i%2 == 0
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := analyzeHeaders(db); err != nil {
+		t.Fatal(err)
+	}
+
+	query, err := headersQuery(db, httptest.NewRequest(http.MethodGet, "/api/headers", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt := query.Session(&gorm.Session{DryRun: true}).Find(&[]*Header{}).Statement
+	rows, err := db.Raw("EXPLAIN QUERY PLAN "+stmt.SQL.String(), stmt.Vars...).Rows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	plan := []string{}
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			t.Fatal(err)
+		}
+		plan = append(plan, detail)
+	}
+	if !strings.Contains(strings.Join(plan, "\n"), "idx_headers_number_orphan") {
+		t.Errorf("want the query to use idx_headers_number_orphan, got plan %q", plan)
+	}
+}
+
+func TestHeadersUncledFilter(t *testing.T) {
+	db := newTestDB(t)
+
+	canonical, uncled, dropped := generateMockHead(), generateMockHead(), generateMockHead()
+	uncled.Orphan, dropped.Orphan = true, true
+	for _, h := range []*Header{canonical, uncled, dropped} {
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recordUncleCitation(db, uncled.Hash, randomHex(32)); err != nil {
+		t.Fatal(err)
+	}
+
+	for query, want := range map[string][]string{
+		"uncled=true":           {uncled.Hash},
+		"uncled=true&orphan=1":  {uncled.Hash},
+		"uncled=false&orphan=1": {dropped.Hash},
+	} {
+		rec := httptest.NewRecorder()
+		headersHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/headers?"+query, nil))
+		headers := []*Header{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &headers); err != nil {
+			t.Fatal(err)
+		}
+		if len(headers) != len(want) || headers[0].Hash != want[0] {
+			t.Errorf("%s: want %v, got %+v", query, want, headers)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	headersHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/headers?uncled=maybe", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("want 400 for an invalid uncled, got %d", rec.Code)
+	}
+}
+
+func TestHeadersCountFilters(t *testing.T) {
+	db := newTestDB(t)
+
+	empty, busy, uncling := generateMockHead(), generateMockHead(), generateMockHead()
+	busy.TxCount, busy.Size = 150, 20_000
+	uncling.TxCount, uncling.UncleCount = 20, 1
+	empty.Size, uncling.Size = 540, 3_000
+	for _, h := range []*Header{empty, busy, uncling} {
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for query, want := range map[string]int{
+		"tx_count_min=20":                 2,
+		"tx_count_min=100":                1,
+		"has_uncles=true":                 1,
+		"has_uncles=false":                2,
+		"has_uncles=false&tx_count_min=1": 1,
+		"tx_count_min=-1":                 -1,
+		"has_uncles=maybe":                -1,
+		"size_min=3000":                   2,
+		"size_max=3000":                   2,
+		"size_min=1000&size_max=10000":    1,
+		"size_min=1e3":                    -1,
+	} {
+		rec := httptest.NewRecorder()
+		headersHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/headers?"+query, nil))
+		if want < 0 {
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("%s: want 400, got %d", query, rec.Code)
+			}
+			continue
+		}
+		headers := []*Header{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &headers); err != nil {
+			t.Fatal(err)
+		}
+		if len(headers) != want {
+			t.Errorf("%s: want %d headers, got %d", query, want, len(headers))
+		}
+	}
+}
+
+func TestHeadersWithParentMiner(t *testing.T) {
+	db := newTestDB(t)
+
+	parent, child, stray := generateMockHead(), generateMockHead(), generateMockHead()
+	parent.Number, child.Number, stray.Number = 100, 101, 101
+	child.ParentHash = parent.Hash
+	for _, h := range []*Header{parent, child, stray} {
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query := func(q string) map[string]*Header {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		headersHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/headers?"+q, nil))
+		headers := []*Header{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &headers); err != nil {
+			t.Fatal(err)
+		}
+		byHash := map[string]*Header{}
+		for _, h := range headers {
+			byHash[h.Hash] = h
+		}
+		return byHash
+	}
+
+	headers := query("with_parent_miner=true")
+	if got := headers[child.Hash].ParentMiner; got != parent.Coinbase {
+		t.Errorf("want parent miner %s, got %q", parent.Coinbase, got)
+	}
+	if got := headers[stray.Hash].ParentMiner; got != "" {
+		t.Errorf("want no parent miner for an unstored parent, got %q", got)
+	}
+	if got := query("")[child.Hash].ParentMiner; got != "" {
+		t.Errorf("want no parent miner unless requested, got %q", got)
+	}
+}
+
+func TestHeaderHandler(t *testing.T) {
+	db := newTestDB(t)
+
+	h := generateMockHead()
+	h.Txes = []Tx{generateMockTx(), generateMockTx()}
+	if err := h.CreateOrUpdate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		headerHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	rec := get("/api/header/" + strings.ToUpper(h.Hash))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+	got := &Header{}
+	if err := json.Unmarshal(rec.Body.Bytes(), got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Hash != h.Hash || len(got.Txes) != 2 {
+		t.Fatalf("want header %s with 2 txes, got %s with %d", h.Hash, got.Hash, len(got.Txes))
+	}
+
+	rec = get("/api/header/" + randomHex(32))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("want an unknown hash not found, got %d", rec.Code)
+	}
+	body := map[string]string{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["error"] == "" {
+		t.Fatalf("want a JSON error body, got %q", rec.Body.String())
+	}
+}
+
+func TestRawSQL(t *testing.T) {
+	defer func() { httpAllowRawSQL, httpRawSQLTimeout = false, 0 }()
+	db := newTestDB(t)
+	h := generateMockHead()
+	if err := h.CreateOrUpdate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(sql string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/headers", nil)
+		req.URL.RawQuery = url.Values{"raw_sql": {sql}}.Encode()
+		headersHandler(db).ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Disabled by default.
+	if rec := query("SELECT * FROM headers"); rec.Code != http.StatusForbidden {
+		t.Fatalf("want 403 with raw SQL disabled, got %d", rec.Code)
+	}
+
+	httpAllowRawSQL, httpRawSQLTimeout = true, 100*time.Millisecond
+	rec := query("SELECT * FROM headers")
+	headers := []*Header{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &headers); err != nil {
+		t.Fatal(err, rec.Body.String())
+	}
+	if len(headers) != 1 || headers[0].Hash != h.Hash {
+		t.Fatalf("want the stored header, got %+v", headers)
+	}
+
+	// A pathological query is cancelled.
+	start := time.Now()
+	rec = query("WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n) SELECT COUNT(*) AS number FROM n")
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("want 500 for a cancelled query, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("want the query cancelled after the timeout, took %s", elapsed)
+	}
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"math/big"
+
+	"gorm.io/gorm"
+)
+
+var backfillFrom uint64
+
+// backfill stores the orphans which can be recovered from the canonical blocks from number to to (inclusive),
+// eg. those which took place while the tracker was down.
+// The node only serves canonical blocks by number, so only orphans cited as uncles are recoverable:
+// each canonical block citing uncles is stored along with its uncles, and the canonical blocks at the uncles' heights.
+// Orphans which were never cited leave no trace in the canonical chain, and are lost.
+// Blocks which fail to be handled are logged and skipped; it returns the number of uncles found.
+func backfill(client chainReader, db *gorm.DB, from, to uint64) (int, error) {
+	uncles := 0
+	for n := from; n <= to; n++ {
+		ctx, cancel := rpcContext()
+		bl, err := client.BlockByNumber(ctx, new(big.Int).SetUint64(n))
+		cancel()
+		if err != nil {
+			return uncles, err
+		}
+		if len(bl.Uncles()) == 0 {
+			continue
+		}
+		if _, err := handleHeader(client, db, bl.Header(), false, "", SourceBackfill); err != nil {
+			logWarn("Backfill of block failed", "number", n, "err", err)
+			continue
+		}
+		uncles += len(bl.Uncles())
+
+		// The uncles' competitors: the canonical blocks at their heights.
+		for _, uncle := range bl.Uncles() {
+			ctx, cancel := rpcContext()
+			canonBlock, err := client.BlockByNumber(ctx, uncle.Number)
+			cancel()
+			if err != nil {
+				return uncles, err
+			}
+			if _, err := handleHeader(client, db, canonBlock.Header(), false, "", SourceBackfill); err != nil {
+				logWarn("Backfill of block failed", "number", canonBlock.NumberU64(), "err", err)
+			}
+		}
+	}
+	return uncles, nil
+}
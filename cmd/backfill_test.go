@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestBackfillRecoversUncleCitedOrphans(t *testing.T) {
+	db := newTestDB(t)
+	client := newMockChainReader()
+
+	for n := uint64(100); n <= 105; n++ {
+		client.addBlock(generateMockBlock(n, common.HexToAddress(randomHex(20))), true)
+	}
+	// 102 cites an orphan at 101; 104 cites an orphan at 103 whose body the node doesn't have.
+	uncle := generateMockBlock(101, common.HexToAddress(randomHex(20)))
+	client.addBlock(uncle, false)
+	citer := client.canon[102].WithBody(nil, []*types.Header{uncle.Header()})
+	client.addBlock(citer, true)
+	unavailable := generateMockBlock(103, common.HexToAddress(randomHex(20)))
+	client.addBlock(client.canon[104].WithBody(nil, []*types.Header{unavailable.Header()}), true)
+
+	n, err := backfill(client, db, 100, 105)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("want 2 uncles found, got %d", n)
+	}
+
+	stored := []*Header{}
+	if err := db.Model(&Header{}).Order("number ASC, orphan ASC").Find(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 6 {
+		t.Fatalf("want the canonical blocks and uncles at 101 and 103 and their citers stored, got %d header(s)", len(stored))
+	}
+	if stored[0].Hash != client.canon[101].Hash().Hex() || stored[0].Orphan {
+		t.Errorf("want the canonical block at 101 stored, got %s orphan=%v", stored[0].Hash, stored[0].Orphan)
+	}
+	if stored[1].Hash != uncle.Hash().Hex() || !stored[1].Orphan || stored[1].UncleBy != citer.Hash().Hex() {
+		t.Errorf("want the uncle stored as an orphan cited by %s, got %+v", citer.Hash().Hex(), stored[1])
+	}
+	if stored[2].Hash != citer.Hash().Hex() {
+		t.Errorf("want the citer stored, got %s", stored[2].Hash)
+	}
+	if stored[4].Hash != unavailable.Hash().Hex() || stored[4].Error == "" {
+		t.Errorf("want the unavailable uncle stored with its fetch error, got %+v", stored[4])
+	}
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var httpBasePath string
+
+// httpServeUI is whether the embedded UI is served at the root; see headlessRootHandler.
+var httpServeUI bool
+
+// normalizeBasePath returns the base path with a leading slash and without a trailing one,
+// or "" for the root.
+func normalizeBasePath(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// withBasePath mounts the handler under the base path, stripping it from the requests' paths.
+// Requests outside of the base path are not found.
+func withBasePath(h http.Handler, base string) http.Handler {
+	base = normalizeBasePath(base)
+	if base == "" {
+		return h
+	}
+	mux := http.NewServeMux()
+	mux.Handle(base+"/", http.StripPrefix(base, h))
+	mux.Handle(base, http.RedirectHandler(base+"/", http.StatusMovedPermanently))
+	return mux
+}
+
+// absoluteAssetRe matches the root-relative asset references of the UI's HTML, eg. src='/build/bundle.js'.
+var absoluteAssetRe = regexp.MustCompile(`((?:src|href)=["'])/([^/])`)
+
+// rewriteBasePath prefixes the root-relative asset references of the HTML with the base path.
+func rewriteBasePath(html []byte, base string) []byte {
+	base = normalizeBasePath(base)
+	if base == "" {
+		return html
+	}
+	return absoluteAssetRe.ReplaceAll(html, []byte("${1}"+base+"/${2}"))
+}
+
+// uiHandler serves the UI's files, rewriting the asset references of index.html for the base path.
+func uiHandler(ui fs.FS, base string) http.Handler {
+	fileServer := http.FileServer(http.FS(ui))
+	if normalizeBasePath(base) == "" {
+		return fileServer
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" && r.URL.Path != "/index.html" {
+			fileServer.ServeHTTP(w, r)
+			return
+		}
+		index, err := fs.ReadFile(ui, "index.html")
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "index.html", time.Time{}, bytes.NewReader(rewriteBasePath(index, base)))
+	})
+}
+
+// headlessRootHandler stands in for the UI with --http.serve-ui=false: it answers the root with a pointer to the API,
+// and the other paths which aren't routed with 404 Not Found.
+func headlessRootHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "go-orphan-tracker: the UI is not served here; the API is under api/, and the server's status at status.")
+}
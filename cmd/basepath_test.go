@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestWithBasePath(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/ping", http.HandlerFunc(pingHandler))
+	mux.Handle("/", uiHandler(fstest.MapFS{
+		"index.html": {Data: []byte(`<link rel='stylesheet' href='/global.css'><script defer src="/build/bundle.js"></script><a href="//example.com">`)},
+	}, "/orphans/"))
+	h := withBasePath(mux, "/orphans/")
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	if w := get("/orphans/ping"); w.Code != http.StatusOK || w.Body.String() != "pong" {
+		t.Fatalf("want pong under the base path, got %d %q", w.Code, w.Body.String())
+	}
+	if w := get("/ping"); w.Code != http.StatusNotFound {
+		t.Fatalf("want routes outside the base path not found, got %d", w.Code)
+	}
+	if w := get("/orphans"); w.Code != http.StatusMovedPermanently {
+		t.Fatalf("want the bare base path redirected, got %d", w.Code)
+	}
+
+	want := `<link rel='stylesheet' href='/orphans/global.css'><script defer src="/orphans/build/bundle.js"></script><a href="//example.com">`
+	if w := get("/orphans/"); w.Body.String() != want {
+		t.Fatalf("want the UI's asset references rewritten, got %q", w.Body.String())
+	}
+}
+
+func TestHeadlessRootHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/ping", http.HandlerFunc(pingHandler))
+	mux.Handle("/", http.HandlerFunc(headlessRootHandler))
+	h := withBasePath(mux, "/orphans")
+
+	for _, c := range []struct {
+		path string
+		code int
+	}{{"/orphans/", http.StatusOK}, {"/orphans/ping", http.StatusOK}, {"/orphans/index.html", http.StatusNotFound}, {"/orphans/build/bundle.js", http.StatusNotFound}} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", c.path, nil))
+		if w.Code != c.code {
+			t.Errorf("%s: want %d, got %d", c.path, c.code, w.Code)
+		}
+		if c.path == "/orphans/" && !strings.Contains(w.Body.String(), "api/") {
+			t.Errorf("want a pointer to the API at the root, got %q", w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var blockCacheSize int
+
+var (
+	metricBlockCacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "orphan_tracker",
+		Name:      "block_cache_hits_total",
+		Help:      "Number of blocks fetched by hash served from the block cache.",
+	})
+	metricBlockCacheMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "orphan_tracker",
+		Name:      "block_cache_misses_total",
+		Help:      "Number of blocks fetched by hash from the node, missing from the block cache.",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(metricBlockCacheHits, metricBlockCacheMisses)
+}
+
+// blockCache is a chainReader which keeps the most recently fetched blocks by hash, up to its size,
+// so that blocks seen again (eg. uncles cited by several blocks) aren't fetched from the node again.
+// A block's content never changes for its hash, so it is never stale; the app-level flags
+// (orphan, uncleBy) are not part of it, and are decided afresh by each handleHeader.
+// Blocks by number aren't cached, since the canonical block at a number can change.
+type blockCache struct {
+	chainReader
+
+	mu     sync.Mutex
+	size   int
+	order  *list.List // of *blockCacheEntry, most recently used first
+	blocks map[common.Hash]*list.Element
+}
+
+type blockCacheEntry struct {
+	hash  common.Hash
+	block *types.Block
+}
+
+func newBlockCache(client chainReader, size int) *blockCache {
+	return &blockCache{
+		chainReader: client,
+		size:        size,
+		order:       list.New(),
+		blocks:      map[common.Hash]*list.Element{},
+	}
+}
+
+// BlockByHash returns the block from the cache, or fetches it from the node and caches it.
+// Failed fetches aren't cached.
+func (c *blockCache) BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	c.mu.Lock()
+	if el, ok := c.blocks[hash]; ok {
+		c.order.MoveToFront(el)
+		c.mu.Unlock()
+		metricBlockCacheHits.Inc()
+		return el.Value.(*blockCacheEntry).block, nil
+	}
+	c.mu.Unlock()
+	metricBlockCacheMisses.Inc()
+
+	bl, err := c.chainReader.BlockByHash(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	c.add(hash, bl)
+	return bl, nil
+}
+
+// add caches the block, evicting the least recently used blocks beyond the size.
+func (c *blockCache) add(hash common.Hash, bl *types.Block) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.blocks[hash]; ok {
+		c.order.MoveToFront(el)
+		return
+	}
+	c.blocks[hash] = c.order.PushFront(&blockCacheEntry{hash, bl})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.blocks, oldest.Value.(*blockCacheEntry).hash)
+	}
+}
+
+// TransactionReceipt fetches the receipt from the node, so that the cache doesn't hide the client's receipts
+// (eg. from --store.rewards and --fetch-receipts); receipts are not cached.
+func (c *blockCache) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	rr, err := receiptsOf(c.chainReader)
+	if err != nil {
+		return nil, err
+	}
+	return rr.TransactionReceipt(ctx, txHash)
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// hashRecordingChainReader records the block hashes requested from it.
+type hashRecordingChainReader struct {
+	*mockChainReader
+	hashes []common.Hash
+}
+
+func (m *hashRecordingChainReader) BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	m.hashes = append(m.hashes, hash)
+	return m.mockChainReader.BlockByHash(ctx, hash)
+}
+
+func TestBlockCache(t *testing.T) {
+	client := &hashRecordingChainReader{mockChainReader: newMockChainReader()}
+	a := generateMockBlock(1, common.HexToAddress(randomHex(20)))
+	b := generateMockBlock(2, common.HexToAddress(randomHex(20)))
+	c := generateMockBlock(3, common.HexToAddress(randomHex(20)))
+	for _, bl := range []*types.Block{a, b, c} {
+		client.addBlock(bl, true)
+	}
+	cache := newBlockCache(client, 2)
+
+	fetch := func(bl *types.Block) {
+		t.Helper()
+		got, err := cache.BlockByHash(context.Background(), bl.Hash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Hash() != bl.Hash() {
+			t.Fatalf("want block %s, got %s", bl.Hash().Hex(), got.Hash().Hex())
+		}
+	}
+	fetch(a)
+	fetch(b)
+	fetch(a) // Cached; a is now the most recently used.
+	fetch(c) // Evicts b.
+	fetch(a) // Cached.
+	fetch(b) // Refetched.
+	want := []common.Hash{a.Hash(), b.Hash(), c.Hash(), b.Hash()}
+	if len(client.hashes) != len(want) {
+		t.Fatalf("want fetches %v, got %v", want, client.hashes)
+	}
+	for i := range want {
+		if client.hashes[i] != want[i] {
+			t.Errorf("want fetch %d of %s, got %s", i, want[i].Hex(), client.hashes[i].Hex())
+		}
+	}
+
+	// Failed fetches aren't cached.
+	missing := common.HexToHash(randomHex(32))
+	for i := 0; i < 2; i++ {
+		if _, err := cache.BlockByHash(context.Background(), missing); err == nil {
+			t.Fatal("want an error for a missing block")
+		}
+	}
+	if n := len(client.hashes) - len(want); n != 2 {
+		t.Errorf("want the missing block fetched twice, got %d", n)
+	}
+}
+
+func TestHandleHeaderCachedBlockKeepsFlags(t *testing.T) {
+	db := newTestDB(t)
+	client := &hashRecordingChainReader{mockChainReader: newMockChainReader()}
+	uncle := generateMockBlock(100, common.HexToAddress(randomHex(20)))
+	client.addBlock(uncle, false)
+	cache := newBlockCache(client, 16)
+
+	// The side head is seen first, then cited as an uncle; the block is fetched once,
+	// and the citation is stored.
+	if _, err := handleHeader(cache, db, uncle.Header(), true, "", SourceSide); err != nil {
+		t.Fatal(err)
+	}
+	citer := randomHex(32)
+	if _, err := handleHeader(cache, db, uncle.Header(), true, citer, SourceUncle); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.hashes) != 1 {
+		t.Errorf("want the block fetched once, got %d fetches", len(client.hashes))
+	}
+	stored, err := storedHeader(db, uncle.Hash().Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored == nil || !stored.Orphan || stored.UncleBy != citer {
+		t.Errorf("want the orphan stored with uncleBy %s, got %+v", citer, stored)
+	}
+}
+
+func TestBlockCacheFetchBlockReward(t *testing.T) {
+	defer func(r rewardSchedule) { rewards = r }(rewards)
+	rewards = rewardSchedules[61]
+
+	to := common.HexToAddress(randomHex(20))
+	tx := types.NewTx(&types.LegacyTx{Nonce: 0, To: &to, Gas: 21000, GasPrice: big.NewInt(2e9)})
+	bl := generateMockBlock(100, to).WithBody([]*types.Transaction{tx}, nil)
+	client := &receiptChainReader{
+		mockChainReader: newMockChainReader(),
+		receipts:        map[common.Hash]*types.Receipt{tx.Hash(): {Status: types.ReceiptStatusSuccessful, GasUsed: 21000}},
+	}
+
+	// The cache forwards the receipts of the client it wraps.
+	got, err := fetchBlockReward(newBlockCache(client, 16), bl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := new(big.Int).Add(rewards.BaseReward(100), big.NewInt(21000*2e9))
+	if got.Cmp(want) != 0 {
+		t.Errorf("want reward %v, got %v", want, got)
+	}
+
+	if _, err := fetchBlockReward(newBlockCache(newMockChainReader(), 16), bl); err != errNoReceipts {
+		t.Errorf("want %v without receipts, got %v", errNoReceipts, err)
+	}
+}
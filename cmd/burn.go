@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"math/big"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// Burn is the EIP-1559 base fee burned (base fee × gas used, in wei) by canonical and orphaned blocks.
+// Orphaned blocks' burns never took effect; they are the burn "lost" to orphaning.
+type Burn struct {
+	Canonical       string `json:"canonical"`
+	CanonicalBlocks int    `json:"canonicalBlocks"`
+	Orphaned        string `json:"orphaned"`
+	OrphanedBlocks  int    `json:"orphanedBlocks"`
+}
+
+// burnTotals sums the burns of the stored blocks with a base fee between min and max (inclusive).
+// The sums are done here rather than in SQL because they overflow integer columns.
+func burnTotals(db *gorm.DB, min, max uint64) (*Burn, error) {
+	rows := []Header{}
+	err := db.Model(&Header{}).
+		Select("orphan", "base_fee", "gas_used").
+		Where("base_fee != ?", "").
+		Where("number >= ? AND number <= ?", min, max).
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Burn{}
+	canonical, orphaned := new(big.Int), new(big.Int)
+	for _, h := range rows {
+		burn := new(big.Int).Mul(parseBig(h.BaseFee), new(big.Int).SetUint64(h.GasUsed))
+		if h.Orphan {
+			orphaned.Add(orphaned, burn)
+			b.OrphanedBlocks++
+		} else {
+			canonical.Add(canonical, burn)
+			b.CanonicalBlocks++
+		}
+	}
+	b.Canonical, b.Orphaned = canonical.String(), orphaned.String()
+	return b, nil
+}
+
+func burnHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		min, max, err := numberRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		b, err := burnTotals(db, min, max)
+		if err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, b)
+	}
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestBurnTotals(t *testing.T) {
+	db := newTestDB(t)
+
+	seed := []struct {
+		number  uint64
+		orphan  bool
+		baseFee string
+		gasUsed uint64
+	}{
+		{100, false, "30000000000", 1_000_000}, // 3e16
+		{100, true, "30000000000", 2_000_000},  // 6e16
+		{101, false, "1000000000000", 500_000}, // 5e17
+		{102, true, "", 8_000_000},             // Pre-1559; no burn.
+		{500, false, "1", 1},                   // Out of range.
+	}
+	for _, s := range seed {
+		h := generateMockHead()
+		h.Number, h.Orphan, h.BaseFee, h.GasUsed = s.number, s.orphan, s.baseFee, s.gasUsed
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := burnTotals(db, 0, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Burn{Canonical: "530000000000000000", CanonicalBlocks: 2, Orphaned: "60000000000000000", OrphanedBlocks: 1}
+	if *b != want {
+		t.Fatalf("want %+v, got %+v", want, *b)
+	}
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CanonicalHead is the hash of the canonical header stored at a height.
+// It duplicates the orphan flags of the headers table so that the canonical winner at a height
+// is a primary key lookup, instead of a scan of the headers at that height.
+// A height has a row only if exactly one of its stored headers is canonical.
+type CanonicalHead struct {
+	Number uint64 `gorm:"primaryKey;autoIncrement:false" json:"number"`
+	Hash   string `gorm:"not null" json:"hash"`
+}
+
+// syncCanonicalHead updates the canonical head of the height from the orphan flags of its stored headers.
+// It must be called whenever the orphan flags at the height change.
+func syncCanonicalHead(db *gorm.DB, number uint64) error {
+	hashes := []string{}
+	err := db.Model(&Header{}).
+		Where("number = ?", number).
+		Where("orphan = ?", false).
+		Limit(2).
+		Pluck("hash", &hashes).Error
+	if err != nil {
+		return err
+	}
+	if len(hashes) != 1 {
+		return db.Delete(&CanonicalHead{}, "number = ?", number).Error
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "number"}},
+		DoUpdates: clause.AssignmentColumns([]string{"hash"}),
+	}).Create(&CanonicalHead{Number: number, Hash: hashes[0]}).Error
+}
+
+// canonicalHashAt returns the hash of the canonical header stored at the height,
+// or an empty string if there is none (or more than one).
+func canonicalHashAt(db *gorm.DB, number uint64) (string, error) {
+	hashes := []string{}
+	err := db.Model(&CanonicalHead{}).
+		Where("number = ?", number).
+		Pluck("hash", &hashes).Error
+	if err != nil || len(hashes) == 0 {
+		return "", err
+	}
+	return hashes[0], nil
+}
+
+// confirmCanonicalAt flags the header with the hash as the canonical one at the height, and the others as orphans,
+// whatever their arrival order or fork-choice strength: it settles the height on the node's canonical chain.
+func confirmCanonicalAt(db *gorm.DB, number uint64, hash string) error {
+	err := db.Model(&Header{}).
+		Where("number = ?", number).
+		Where("hash != ?", hash).
+		Update("orphan", true).Error
+	if err != nil {
+		return err
+	}
+	err = db.Model(&Header{}).
+		Where("hash = ?", hash).
+		Update("orphan", false).Error
+	if err != nil {
+		return err
+	}
+	if err := syncCanonicalHead(db, number); err != nil {
+		return err
+	}
+	return recordWinReason(db, number)
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestCanonicalHeadTracksFlips(t *testing.T) {
+	db := newTestDB(t)
+	client := newMockChainReader()
+
+	a := generateMockBlock(100, common.HexToAddress(randomHex(20)))
+	b := generateMockBlock(100, common.HexToAddress(randomHex(20)))
+	client.addBlock(a, true)
+	client.addBlock(b, false)
+
+	wantCanonical := func(want string) {
+		t.Helper()
+		got, err := canonicalHashAt(db, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("want canonical %q, got %q", want, got)
+		}
+	}
+
+	// The canonical block is stored first, then its competitor arrives as an orphan.
+	if _, err := handleHeader(client, db, a.Header(), false, "", SourceHead); err != nil {
+		t.Fatal(err)
+	}
+	wantCanonical(a.Hash().Hex())
+	if _, err := handleHeader(client, db, b.Header(), true, "", SourceSide); err != nil {
+		t.Fatal(err)
+	}
+	wantCanonical(a.Hash().Hex())
+
+	// The competitor becomes canonical, and back.
+	if _, err := handleHeader(client, db, b.Header(), false, "", SourceHead); err != nil {
+		t.Fatal(err)
+	}
+	wantCanonical(b.Hash().Hex())
+	if _, err := handleHeader(client, db, a.Header(), false, "", SourceHead); err != nil {
+		t.Fatal(err)
+	}
+	wantCanonical(a.Hash().Hex())
+
+	// Both stored headers flagged orphan leave the height without a canonical head.
+	if err := db.Model(&Header{}).Where("number = ?", 100).Update("orphan", true).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := syncCanonicalHead(db, 100); err != nil {
+		t.Fatal(err)
+	}
+	wantCanonical("")
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// These are the accepted values for the --chain.id-change flag.
+const (
+	chainIDChangeExit   = "exit"
+	chainIDChangeReinit = "reinit"
+)
+
+// chainIDCheckInterval is how often the node's chain ID is re-checked.
+const chainIDCheckInterval = time.Minute
+
+var chainIDChangePolicy string
+
+// expectedChainID is the chain ID the node must report (--chain.id); 0 accepts any.
+var expectedChainID uint64
+
+// checkExpectedChainID returns an error if the chain ID isn't the expected one, unless no chain ID is expected.
+func checkExpectedChainID(id *big.Int, expected uint64) error {
+	if expected == 0 || (id.IsUint64() && id.Uint64() == expected) {
+		return nil
+	}
+	return fmt.Errorf("the node's chain ID %v is not the expected chain ID %d (--chain.id); is the RPC target on the wrong network?", id, expected)
+}
+
+// chainIDReader is the subset of the ethclient.Client API used to query the chain ID.
+type chainIDReader interface {
+	ChainID(ctx context.Context) (*big.Int, error)
+}
+
+// checkChainID re-queries the node's chain ID and compares it to the one in use.
+// The chain ID is used for transaction sender recovery, so a stale value would silently corrupt tx data.
+// On a change, the --chain.id-change policy decides whether to adopt the new chain ID ("reinit"),
+// or to return an error so that the program can exit ("exit").
+// Failures to query the node are logged, but otherwise ignored.
+func checkChainID(client chainIDReader) error {
+	ctx, cancel := rpcContext()
+	defer cancel()
+	id, err := client.ChainID(ctx)
+	if err != nil {
+		logWarn("Chain ID check failed", "err", err)
+		return nil
+	}
+	if id.Cmp(chainID) == 0 {
+		return nil
+	}
+
+	logError("Chain ID changed", "was", chainID, "now", id)
+	// Even with the reinit policy, the chain ID can't change from the expected one.
+	if err := checkExpectedChainID(id, expectedChainID); err != nil {
+		return err
+	}
+	if chainIDChangePolicy == chainIDChangeReinit {
+		chainID = id
+		logWarn("Using new chain ID for transaction signers", "chainID", chainID)
+		return nil
+	}
+	return fmt.Errorf("chain ID changed from %v to %v", chainID, id)
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"context"
+	"math/big"
+	"testing"
+)
+
+// mockChainIDReader reports whatever chain ID it is set to.
+type mockChainIDReader struct {
+	id *big.Int
+}
+
+func (m *mockChainIDReader) ChainID(ctx context.Context) (*big.Int, error) {
+	return m.id, nil
+}
+
+func TestCheckChainID(t *testing.T) {
+	defer func(id *big.Int, policy string) { chainID, chainIDChangePolicy = id, policy }(chainID, chainIDChangePolicy)
+
+	chainID = big.NewInt(61)
+	client := &mockChainIDReader{id: big.NewInt(61)}
+
+	chainIDChangePolicy = chainIDChangeExit
+	if err := checkChainID(client); err != nil {
+		t.Fatalf("want no error for an unchanged chain ID, got %v", err)
+	}
+
+	client.id = big.NewInt(63)
+	if err := checkChainID(client); err == nil {
+		t.Fatal("want an error for a changed chain ID with the exit policy")
+	}
+	if chainID.Int64() != 61 {
+		t.Fatalf("want chain ID unchanged with the exit policy, got %v", chainID)
+	}
+
+	chainIDChangePolicy = chainIDChangeReinit
+	if err := checkChainID(client); err != nil {
+		t.Fatalf("want no error for a changed chain ID with the reinit policy, got %v", err)
+	}
+	if chainID.Int64() != 63 {
+		t.Fatalf("want chain ID reinitialized to 63, got %v", chainID)
+	}
+}
+
+func TestCheckExpectedChainID(t *testing.T) {
+	if err := checkExpectedChainID(big.NewInt(1), 0); err != nil {
+		t.Errorf("want any chain ID accepted without an expected one, got %v", err)
+	}
+	if err := checkExpectedChainID(big.NewInt(61), 61); err != nil {
+		t.Errorf("want the expected chain ID accepted, got %v", err)
+	}
+	if err := checkExpectedChainID(big.NewInt(1), 61); err == nil {
+		t.Error("want another chain ID refused")
+	}
+
+	// A change to another chain than the expected one is refused, even with the reinit policy.
+	defer func(id *big.Int, policy string, expected uint64) {
+		chainID, chainIDChangePolicy, expectedChainID = id, policy, expected
+	}(chainID, chainIDChangePolicy, expectedChainID)
+	chainID, chainIDChangePolicy, expectedChainID = big.NewInt(61), chainIDChangeReinit, 61
+	if err := checkChainID(&mockChainIDReader{id: big.NewInt(1)}); err == nil {
+		t.Error("want a change away from the expected chain ID refused")
+	}
+	if chainID.Int64() != 61 {
+		t.Errorf("want chain ID unchanged, got %v", chainID)
+	}
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// These are the default and maximum numbers of ancestors walked by /api/chain.
+const (
+	chainDefaultDepth = 10
+	chainMaxDepth     = 1000
+)
+
+// ChainBranch is a stored block and its stored ancestors, walked through their parent hashes, newest first.
+type ChainBranch struct {
+	Headers []*Header `json:"headers"`
+	// MissingParent is the hash of the first ancestor which isn't stored, where the walk stopped.
+	// It is empty if the walk went as deep as asked for.
+	MissingParent string `json:"missingParent,omitempty"`
+}
+
+// walkChain follows the parent hashes of the stored block with the given hash up to depth ancestors, through the
+// stored headers only. It returns nil if the block isn't stored.
+func walkChain(db *gorm.DB, hash string, depth uint64) (*ChainBranch, error) {
+	h, err := storedHeader(db, hash)
+	if err != nil || h == nil {
+		return nil, err
+	}
+	branch := &ChainBranch{Headers: []*Header{h}}
+	for i := uint64(0); i < depth; i++ {
+		parent, err := storedHeader(db, h.ParentHash)
+		if err != nil {
+			return nil, err
+		}
+		if parent == nil {
+			branch.MissingParent = h.ParentHash
+			break
+		}
+		branch.Headers = append(branch.Headers, parent)
+		h = parent
+	}
+	return branch, nil
+}
+
+// chainHandler serves the branch of stored blocks ending at /api/chain/{hash},
+// up to depth ancestors (default chainDefaultDepth).
+func chainHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hash := strings.TrimPrefix(r.URL.Path, "/api/chain/")
+		if hash == "" || strings.Contains(hash, "/") {
+			writeJSONError(w, http.StatusNotFound, "header not found")
+			return
+		}
+		depth := uint64(chainDefaultDepth)
+		if q := r.URL.Query().Get("depth"); q != "" {
+			n, err := strconv.ParseUint(q, 10, 64)
+			if err != nil || n > chainMaxDepth {
+				http.Error(w, fmt.Sprintf("invalid depth %q: must be at most %d", q, chainMaxDepth), http.StatusBadRequest)
+				return
+			}
+			depth = n
+		}
+
+		branch, err := walkChain(db, strings.ToLower(hash), depth)
+		if err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if branch == nil {
+			writeJSONError(w, http.StatusNotFound, "header not found")
+			return
+		}
+		writeJSON(w, branch)
+	}
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChainHandler(t *testing.T) {
+	db := newTestDB(t)
+
+	// An orphan branch of three blocks, whose fork point isn't stored.
+	missing := randomHex(32)
+	var branch []*Header
+	parent := missing
+	for i := uint64(0); i < 3; i++ {
+		h := generateMockHead()
+		h.Number, h.ParentHash, h.Orphan = 100+i, parent, true
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+		branch = append(branch, h)
+		parent = h.Hash
+	}
+	tip := branch[2]
+
+	query := func(path string) (int, *ChainBranch) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		chainHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			return rec.Code, nil
+		}
+		got := &ChainBranch{}
+		if err := json.Unmarshal(rec.Body.Bytes(), got); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, got
+	}
+
+	// Up to the missing fork point, case-insensitively.
+	_, got := query("/api/chain/0x" + strings.ToUpper(tip.Hash[2:]))
+	if got == nil || len(got.Headers) != 3 || got.MissingParent != missing {
+		t.Fatalf("want the 3 blocks and the missing parent %s, got %+v", missing, got)
+	}
+	for i, h := range got.Headers {
+		if want := branch[2-i]; h.Hash != want.Hash {
+			t.Errorf("header %d: want %s, got %s", i, want.Hash, h.Hash)
+		}
+	}
+
+	// Limited by depth, without missing links.
+	if _, got := query("/api/chain/" + tip.Hash + "?depth=1"); got == nil || len(got.Headers) != 2 || got.MissingParent != "" {
+		t.Errorf("want the tip and its parent, got %+v", got)
+	}
+
+	if code, _ := query("/api/chain/" + randomHex(32)); code != http.StatusNotFound {
+		t.Errorf("want 404 for an unknown block, got %d", code)
+	}
+	if code, _ := query("/api/chain/" + tip.Hash + "?depth=1001"); code != http.StatusBadRequest {
+		t.Errorf("want 400 for a depth above the maximum, got %d", code)
+	}
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var channelBuffer int
+
+// channelHighWater is the fraction of a channel's capacity above which a warning is logged.
+const channelHighWater = 0.8
+
+// channelCheckInterval is how often the channels' lengths are checked against the high-water mark.
+const channelCheckInterval = 10 * time.Second
+
+// ChannelStatus is the number of events waiting in a channel, out of its capacity.
+type ChannelStatus struct {
+	Len int `json:"len"`
+	Cap int `json:"cap"`
+}
+
+// channelStatuses returns the status of each channel, by name.
+func channelStatuses(queues map[string]chan *types.Header) map[string]ChannelStatus {
+	if queues == nil {
+		return nil
+	}
+	statuses := make(map[string]ChannelStatus, len(queues))
+	for name, ch := range queues {
+		statuses[name] = ChannelStatus{Len: len(ch), Cap: cap(ch)}
+	}
+	return statuses
+}
+
+// aboveHighWater tells whether the channel is filled beyond the high-water mark.
+func (s ChannelStatus) aboveHighWater() bool {
+	return s.Cap > 0 && float64(s.Len) > channelHighWater*float64(s.Cap)
+}
+
+// highWaterMonitor warns once when a channel exceeds the high-water mark,
+// and logs when it has drained back below it.
+type highWaterMonitor struct {
+	above map[string]bool
+}
+
+// check compares the channels' lengths against the high-water mark, logging the crossings since the last check.
+func (m *highWaterMonitor) check(queues map[string]chan *types.Header) {
+	if m.above == nil {
+		m.above = map[string]bool{}
+	}
+	statuses := channelStatuses(queues)
+	names := make([]string, 0, len(statuses))
+	for name := range statuses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := statuses[name]
+		above := s.aboveHighWater()
+		if above && !m.above[name] {
+			logWarn("Channel above high-water mark; ingestion is falling behind", "channel", name, "len", s.Len, "cap", s.Cap)
+		} else if !above && m.above[name] {
+			logInfo("Channel back below high-water mark", "channel", name, "len", s.Len, "cap", s.Cap)
+		}
+		m.above[name] = above
+	}
+}
+
+// runChannelMonitor checks the channels against the high-water mark at every interval. It never returns.
+func runChannelMonitor(queues map[string]chan *types.Header, interval time.Duration) {
+	m := &highWaterMonitor{}
+	for range time.Tick(interval) {
+		m.check(queues)
+	}
+}
+
+var (
+	metricChannelLengthDesc = prometheus.NewDesc("orphan_tracker_channel_length",
+		"Number of events waiting in the channel.", []string{"channel"}, nil)
+	metricChannelCapacityDesc = prometheus.NewDesc("orphan_tracker_channel_capacity",
+		"Capacity of the channel (--channel.buffer).", []string{"channel"}, nil)
+)
+
+// channelCollector exports the lengths and capacities of the lagQueues channels when collected.
+type channelCollector struct{}
+
+func (channelCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- metricChannelLengthDesc
+	ch <- metricChannelCapacityDesc
+}
+
+func (channelCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, s := range channelStatuses(lagQueues) {
+		ch <- prometheus.MustNewConstMetric(metricChannelLengthDesc, prometheus.GaugeValue, float64(s.Len), name)
+		ch <- prometheus.MustNewConstMetric(metricChannelCapacityDesc, prometheus.GaugeValue, float64(s.Cap), name)
+	}
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestHighWaterMonitor(t *testing.T) {
+	l, buf := newTestLogger(levelInfo, false)
+	defer func(prev *leveledLogger) { appLogger = prev }(appLogger)
+	appLogger = l
+
+	ch := make(chan *types.Header, 10)
+	queues := map[string]chan *types.Header{"head": ch}
+	m := &highWaterMonitor{}
+
+	for i := 0; i < 9; i++ {
+		ch <- &types.Header{}
+	}
+	// Above the mark, the warning is logged once.
+	m.check(queues)
+	m.check(queues)
+	if n := strings.Count(buf.String(), "WARN Channel above high-water mark"); n != 1 {
+		t.Errorf("want 1 warning, got %d:\n%s", n, buf.String())
+	}
+	if !strings.Contains(buf.String(), "channel=head len=9 cap=10") {
+		t.Errorf("want the channel's length and capacity logged, got %s", buf.String())
+	}
+
+	buf.Reset()
+	for i := 0; i < 5; i++ {
+		<-ch
+	}
+	m.check(queues)
+	if !strings.Contains(buf.String(), "INFO Channel back below high-water mark") {
+		t.Errorf("want the drain logged, got %q", buf.String())
+	}
+}
+
+func TestStatusChannels(t *testing.T) {
+	defer func(q map[string]chan *types.Header) { lagQueues = q }(lagQueues)
+	defer func(id *big.Int) { chainID = id }(chainID)
+	chainID = big.NewInt(61)
+	head := make(chan *types.Header, 5)
+	head <- &types.Header{}
+	lagQueues = map[string]chan *types.Header{"head": head, "side": make(chan *types.Header, 5)}
+
+	rec := httptest.NewRecorder()
+	statusHandler(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	s := ServerStatus{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Channels["head"] != (ChannelStatus{Len: 1, Cap: 5}) || s.Channels["side"] != (ChannelStatus{Len: 0, Cap: 5}) {
+		t.Errorf("want the channels' lengths and capacities, got %+v", s.Channels)
+	}
+}
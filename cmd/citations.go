@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UncleCitationLink records that a block (the citer) lists a header as one of its uncles.
+// An uncle can be cited by more than one block, eg. by competing blocks at the citing height,
+// so the citations are kept in this join table rather than in the single-valued Header.UncleBy.
+// The citing block need not be stored.
+type UncleCitationLink struct {
+	UncleHash string `gorm:"primaryKey" json:"uncleHash"`
+	CiterHash string `gorm:"primaryKey" json:"citerHash"`
+}
+
+// TableName names the join table after what it holds; UncleCitation is the who-uncles-whom count.
+func (UncleCitationLink) TableName() string {
+	return "uncle_citations"
+}
+
+// citedAsUncle is the SQL condition that a row of the headers table has been cited as an uncle,
+// ie. that the orphan was rewarded rather than simply dropped.
+const citedAsUncle = "EXISTS (SELECT 1 FROM uncle_citations WHERE uncle_citations.uncle_hash = headers.hash)"
+
+// recordUncleCitation adds the citer to the blocks citing the uncle. It is a no-op if already recorded.
+func recordUncleCitation(db *gorm.DB, uncle, citer string) error {
+	return db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&UncleCitationLink{UncleHash: uncle, CiterHash: citer}).Error
+}
+
+// migrateUncleCitations copies the uncleBy relations of the stored headers into the uncle_citations table,
+// to carry databases from before the table over.
+// It is run once: the citations stored since are recorded along with the relations (see recordUncleCitation).
+func migrateUncleCitations(db *gorm.DB) error {
+	if _, done, err := metaValue(db, metaKeyUncleCitationsMigrated); err != nil || done {
+		return err
+	}
+	links := []UncleCitationLink{}
+	err := db.Model(&Header{}).
+		Select("hash AS uncle_hash, uncle_by AS citer_hash").
+		Where("uncle_by != ?", "").
+		Scan(&links).Error
+	if err != nil {
+		return err
+	}
+	if len(links) > 0 {
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(links, 100).Error; err != nil {
+			return err
+		}
+	}
+	return setMeta(db, metaKeyUncleCitationsMigrated, "true")
+}
+
+// fillUncledBy sets the UncledBy of the headers from the uncle_citations table, in a single query.
+func fillUncledBy(db *gorm.DB, headers []*Header) error {
+	if len(headers) == 0 {
+		return nil
+	}
+	hashes := make([]string, 0, len(headers))
+	for _, h := range headers {
+		hashes = append(hashes, h.Hash)
+	}
+	links := []UncleCitationLink{}
+	err := db.Where("uncle_hash IN ?", hashes).
+		Order("citer_hash ASC").
+		Find(&links).Error
+	if err != nil {
+		return err
+	}
+	citers := map[string][]string{}
+	for _, l := range links {
+		citers[l.UncleHash] = append(citers[l.UncleHash], l.CiterHash)
+	}
+	for _, h := range headers {
+		h.UncledBy = citers[h.Hash]
+	}
+	return nil
+}
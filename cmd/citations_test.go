@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestHandleHeaderAppendsUncleCitations(t *testing.T) {
+	db := newTestDB(t)
+	client := newMockChainReader()
+
+	uncle := generateMockBlock(100, common.HexToAddress(randomHex(20)))
+	client.addBlock(uncle, false)
+	citers := []string{randomHex(32), randomHex(32)}
+	sort.Strings(citers)
+
+	// The uncle is cited by two competing blocks; the second citation must not replace the first.
+	for _, c := range citers {
+		if _, err := handleHeader(client, db, uncle.Header(), true, c, SourceUncle); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	headersHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/headers", nil))
+	headers := []*Header{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &headers); err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 1 {
+		t.Fatalf("want 1 header, got %d", len(headers))
+	}
+	got := headers[0]
+	if len(got.UncledBy) != 2 || got.UncledBy[0] != citers[0] || got.UncledBy[1] != citers[1] {
+		t.Errorf("want uncledBy %v, got %v", citers, got.UncledBy)
+	}
+	if got.UncleBy != citers[1] {
+		t.Errorf("want uncleBy the latest citer %s, got %s", citers[1], got.UncleBy)
+	}
+}
+
+func TestMigrateUncleCitations(t *testing.T) {
+	db := newTestDB(t)
+	// newTestDB migrated the empty database, which marks the citations as migrated.
+	if err := db.Delete(&Meta{}, "key = ?", metaKeyUncleCitationsMigrated).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	uncle, canonical := generateMockHead(), generateMockHead()
+	uncle.Orphan = true
+	uncle.UncleBy = randomHex(32)
+	for _, h := range []*Header{uncle, canonical} {
+		if err := h.CreateOrUpdate(db, "orphan", "uncle_by"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Migrating twice must not duplicate the citation.
+	for i := 0; i < 2; i++ {
+		if err := migrateUncleCitations(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := []UncleCitationLink{}
+	if err := db.Find(&links).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 || links[0].UncleHash != uncle.Hash || links[0].CiterHash != uncle.UncleBy {
+		t.Errorf("want the uncleBy relation migrated, got %+v", links)
+	}
+
+	// Once migrated, the uncleBy relations aren't scanned again.
+	late := generateMockHead()
+	late.Orphan = true
+	late.UncleBy = randomHex(32)
+	if err := late.CreateOrUpdate(db, "orphan", "uncle_by"); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateUncleCitations(db); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	if err := db.Model(&UncleCitationLink{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("want no more citations migrated, got %d", count)
+	}
+}
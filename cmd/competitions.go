@@ -0,0 +1,298 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"gorm.io/gorm"
+)
+
+// These are the values for Header.WinReason.
+const (
+	// WinReasonDifficulty means the canonical block had a higher (total) difficulty than its competitor(s); see compareWeight.
+	WinReasonDifficulty = "difficulty"
+	// WinReasonTimestamp means difficulties were equal, but the canonical block had an earlier timestamp.
+	WinReasonTimestamp = "timestamp"
+	// WinReasonRace means neither difficulty nor timestamp explain the outcome; it was a true race.
+	WinReasonRace = "race"
+)
+
+// Competition is a set of stored headers at the same height.
+type Competition struct {
+	Number    uint64    `json:"number"`
+	Canonical string    `json:"canonical"`
+	WinReason string    `json:"winReason"`
+	Headers   []*Header `json:"headers"`
+}
+
+// parseBig parses a big integer from either its decimal or 0x-prefixed hex string form.
+// The zero value is returned for empty or unparseable strings.
+func parseBig(s string) *big.Int {
+	i, ok := new(big.Int).SetString(s, 0)
+	if !ok {
+		return new(big.Int)
+	}
+	return i
+}
+
+// compareWeight compares the chain weights of two competing headers: their total difficulties,
+// or, if either has none stored (eg. the node didn't return it), their own difficulties.
+func compareWeight(a, b *Header) int {
+	if a.TotalDifficulty != "" && b.TotalDifficulty != "" {
+		return parseBig(a.TotalDifficulty).Cmp(parseBig(b.TotalDifficulty))
+	}
+	return parseBig(a.Difficulty).Cmp(parseBig(b.Difficulty))
+}
+
+// compareStrength compares the fork-choice strength of two competing headers,
+// returning a positive number if a is stronger than b, a negative number if b is stronger,
+// and 0 if they are indistinguishable.
+// The heavier chain (see compareWeight) is stronger; for equal weights, the earlier timestamp is stronger.
+func compareStrength(a, b *Header) int {
+	if cmp := compareWeight(a, b); cmp != 0 {
+		return cmp
+	}
+	if a.Time < b.Time {
+		return 1
+	}
+	if a.Time > b.Time {
+		return -1
+	}
+	return 0
+}
+
+// classifyWin classifies why the canonical block won over its competitors.
+// The classification is made against the strongest competitor.
+func classifyWin(canon *Header, competitors []*Header) string {
+	if len(competitors) == 0 {
+		return ""
+	}
+	strongest := competitors[0]
+	for _, c := range competitors[1:] {
+		if compareStrength(c, strongest) > 0 {
+			strongest = c
+		}
+	}
+
+	if compareWeight(canon, strongest) > 0 {
+		return WinReasonDifficulty
+	}
+	if compareStrength(canon, strongest) > 0 {
+		return WinReasonTimestamp
+	}
+	return WinReasonRace
+}
+
+// competitionAt returns the competition at the given height, or nil if there is
+// no more than one stored header there.
+func competitionAt(db *gorm.DB, number uint64) (*Competition, error) {
+	headers := []*Header{}
+	err := db.Model(&Header{}).
+		Where("number = ?", number).
+		Order("orphan ASC").
+		Find(&headers).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(headers) < 2 {
+		return nil, nil
+	}
+
+	c := &Competition{Number: number, Headers: headers}
+	if !headers[0].Orphan {
+		c.Canonical = headers[0].Hash
+		c.WinReason = classifyWin(headers[0], headers[1:])
+	}
+	return c, nil
+}
+
+// recordWinReason classifies the competition at the given height, if any,
+// and stores the reason on the canonical header.
+func recordWinReason(db *gorm.DB, number uint64) error {
+	c, err := competitionAt(db, number)
+	if err != nil || c == nil || c.Canonical == "" {
+		return err
+	}
+	return db.Model(&Header{}).
+		Where("hash = ?", c.Canonical).
+		Update("win_reason", c.WinReason).Error
+}
+
+// recordCanonicalTimeDelta stores the orphan's CanonicalTimeDelta to the canonical block at its height.
+// It does nothing if the orphan is the canonical block, ie. it was reorganized into the chain.
+func recordCanonicalTimeDelta(db *gorm.DB, orphan *Header, canon *types.Header) error {
+	if orphan.Hash == canon.Hash().Hex() {
+		return nil
+	}
+	delta := int64(orphan.Time) - int64(canon.Time)
+	orphan.CanonicalTimeDelta = &delta
+	return db.Model(&Header{}).
+		Where("hash = ?", orphan.Hash).
+		Update("canonical_time_delta", delta).Error
+}
+
+// reconcileHeight settles the orphan flags of the headers stored at the given height, if the node hasn't chosen
+// a block there yet, ie. none of them is flagged canonical (eg. only side heads are stored): the strongest
+// by compareStrength is then provisionally flagged canonical, until the node's canonical block is stored.
+// The node's choice is never overridden. Indistinguishable headers (a true race) fall back to the lowest hash,
+// so that the result is deterministic.
+func reconcileHeight(db *gorm.DB, number uint64) error {
+	headers := []*Header{}
+	err := db.Model(&Header{}).
+		Where("number = ?", number).
+		Order("hash ASC").
+		Find(&headers).Error
+	if err != nil || len(headers) < 2 {
+		return err
+	}
+
+	winner := headers[0]
+	for _, h := range headers {
+		if !h.Orphan {
+			return nil
+		}
+		if compareStrength(h, winner) > 0 {
+			winner = h
+		}
+	}
+
+	err = db.Model(&Header{}).
+		Where("number = ?", number).
+		Where("hash != ?", winner.Hash).
+		Update("orphan", true).Error
+	if err != nil {
+		return err
+	}
+	return db.Model(&Header{}).
+		Where("hash = ?", winner.Hash).
+		Update("orphan", false).Error
+}
+
+// findCompetitions returns all competitions between the min and max heights, inclusive,
+// in descending order by height.
+func findCompetitions(db *gorm.DB, min, max uint64) ([]*Competition, error) {
+	return queryCompetitions(db, db.Model(&Header{}).Where("number >= ? AND number <= ?", min, max))
+}
+
+// recentCompetitions returns the n highest competitions, in descending order by height.
+func recentCompetitions(db *gorm.DB, n int) ([]*Competition, error) {
+	return queryCompetitions(db, db.Model(&Header{}).Limit(n))
+}
+
+// queryCompetitions returns the competitions at the heights selected by the headers query res.
+func queryCompetitions(db, res *gorm.DB) ([]*Competition, error) {
+	numbers := []uint64{}
+	err := res.
+		Group("number").
+		Having("COUNT(*) > 1").
+		Order("number DESC").
+		Pluck("number", &numbers).Error
+	if err != nil {
+		return nil, err
+	}
+
+	competitions := []*Competition{}
+	for _, n := range numbers {
+		c, err := competitionAt(db, n)
+		if err != nil {
+			return nil, err
+		}
+		if c != nil {
+			competitions = append(competitions, c)
+		}
+	}
+	return competitions, nil
+}
+
+// competitionsCSVHeader is the header row of the competitions CSV export.
+var competitionsCSVHeader = []string{"number", "hash", "miner", "timestamp", "orphan", "winner"}
+
+// writeCompetitionsCSV writes one row per competing block.
+func writeCompetitionsCSV(w io.Writer, competitions []*Competition) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(competitionsCSVHeader); err != nil {
+		return err
+	}
+	for _, c := range competitions {
+		for _, h := range c.Headers {
+			err := cw.Write([]string{
+				strconv.FormatUint(h.Number, 10),
+				h.Hash,
+				h.Coinbase,
+				strconv.FormatUint(h.Time, 10),
+				strconv.FormatBool(h.Orphan),
+				c.Canonical,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func competitionsHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		min, max, err := numberRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		competitions, err := findCompetitions(db, min, max)
+		if err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if r.URL.Query().Get("format") == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", `attachment; filename="competitions.csv"`)
+			if err := writeCompetitionsCSV(w, competitions); err != nil {
+				logError("API error", "path", r.URL.Path, "err", err)
+			}
+			return
+		}
+		writeJSON(w, competitions)
+	}
+}
+
+// competitorsHandler serves /api/competitors/{number}: all the headers stored at the height, with their transactions,
+// the canonical one first, then the orphans by hash.
+func competitorsHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		param := strings.TrimPrefix(r.URL.Path, "/api/competitors/")
+		if param == "" || strings.Contains(param, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		number, err := parseBlockNumber(param)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		headers := []*Header{}
+		err = db.Model(&Header{}).
+			Preload("Txes").
+			Where("number = ?", number).
+			Order("orphan ASC, hash ASC").
+			Find(&headers).Error
+		if err == nil {
+			err = fillUncledBy(db, headers)
+		}
+		if err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, headers)
+	}
+}
@@ -0,0 +1,312 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestClassifyWin(t *testing.T) {
+	cases := []struct {
+		name                 string
+		canonDiff, canonTD   string
+		canonTime            uint64
+		orphanDiff, orphanTD string
+		orphanTime           uint64
+		want                 string
+	}{
+		{"difficulty", "0x200", "", 100, "0x100", "", 90, WinReasonDifficulty},
+		{"difficulty decimal", "512", "", 100, "256", "", 90, WinReasonDifficulty},
+		{"timestamp", "0x100", "", 90, "0x100", "", 100, WinReasonTimestamp},
+		{"race", "0x100", "", 100, "0x100", "", 100, WinReasonRace},
+		{"race lower difficulty", "0x100", "", 90, "0x200", "", 100, WinReasonRace},
+		// The chain weight decides, not the block's own difficulty.
+		{"total difficulty", "0x100", "0x10100", 100, "0x200", "0x10000", 90, WinReasonDifficulty},
+		{"race lower total difficulty", "0x200", "0x10000", 90, "0x100", "0x10100", 100, WinReasonRace},
+		{"timestamp equal total difficulty", "0x100", "0x10000", 90, "0x200", "0x10000", 100, WinReasonTimestamp},
+		{"total difficulty missing", "0x200", "0x10000", 100, "0x100", "", 90, WinReasonDifficulty},
+	}
+	for _, c := range cases {
+		canon := generateMockHead()
+		canon.Difficulty, canon.TotalDifficulty, canon.Time = c.canonDiff, c.canonTD, c.canonTime
+		orphan := generateMockHead()
+		orphan.Difficulty, orphan.TotalDifficulty, orphan.Time = c.orphanDiff, c.orphanTD, c.orphanTime
+
+		if got := classifyWin(canon, []*Header{orphan}); got != c.want {
+			t.Errorf("%s: want %q, got %q", c.name, c.want, got)
+		}
+	}
+}
+
+func TestRecordWinReason(t *testing.T) {
+	db := newTestDB(t)
+
+	canon := generateMockHead()
+	canon.Difficulty = "0x200"
+	orphan := generateMockHead()
+	orphan.Number = canon.Number
+	orphan.Difficulty = "0x100"
+	orphan.Orphan = true
+	loner := generateMockHead()
+	loner.Number = canon.Number + 1
+
+	for _, h := range []*Header{canon, orphan, loner} {
+		if err := h.CreateOrUpdate(db, "orphan"); err != nil {
+			t.Fatal(err)
+		}
+		if err := recordWinReason(db, h.Number); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	competitions, err := findCompetitions(db, 0, canon.Number+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(competitions) != 1 {
+		t.Fatalf("want 1 competition, got %d", len(competitions))
+	}
+	if c := competitions[0]; c.Canonical != canon.Hash || c.WinReason != WinReasonDifficulty || len(c.Headers) != 2 {
+		t.Fatalf("unexpected competition: %+v", c)
+	}
+
+	stored := Header{}
+	db.Model(&Header{}).Where("hash = ?", canon.Hash).First(&stored)
+	if stored.WinReason != WinReasonDifficulty {
+		t.Fatalf("want stored win reason %q, got %q", WinReasonDifficulty, stored.WinReason)
+	}
+}
+
+func TestCanonicalTimeDelta(t *testing.T) {
+	db := newTestDB(t)
+
+	canon := generateMockBlock(100, common.HexToAddress(randomHex(20)))
+	var orphans []*Header
+	for _, late := range []uint64{3, 0, 12} {
+		h := generateMockHead()
+		h.Number, h.Time, h.Orphan = 100, canon.Time()+late, true
+		if err := h.CreateOrUpdate(db, "orphan"); err != nil {
+			t.Fatal(err)
+		}
+		if err := recordCanonicalTimeDelta(db, h, canon.Header()); err != nil {
+			t.Fatal(err)
+		}
+		orphans = append(orphans, h)
+	}
+	// Not compared, eg. stored before the deltas were.
+	unknown := generateMockHead()
+	unknown.Orphan = true
+	if err := unknown.CreateOrUpdate(db, "orphan"); err != nil {
+		t.Fatal(err)
+	}
+	// A side head which became canonical has no delta to itself.
+	self := appHeader(canon.Header())
+	if err := recordCanonicalTimeDelta(db, self, canon.Header()); err != nil || self.CanonicalTimeDelta != nil {
+		t.Fatalf("want no delta for the canonical block, got %v (%v)", self.CanonicalTimeDelta, err)
+	}
+
+	rec := httptest.NewRecorder()
+	headersHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/headers?sort=canonical_time_delta&include_txes=false", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	got := []*Header{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []*Header{orphans[2], orphans[0], orphans[1], unknown}
+	if len(got) != len(want) {
+		t.Fatalf("want %d headers, got %d", len(want), len(got))
+	}
+	for i, w := range want {
+		if got[i].Hash != w.Hash {
+			t.Errorf("header %d: want %s, got %s", i, w.Hash, got[i].Hash)
+		}
+		if w.CanonicalTimeDelta == nil {
+			if got[i].CanonicalTimeDelta != nil {
+				t.Errorf("header %d: want no delta, got %d", i, *got[i].CanonicalTimeDelta)
+			}
+		} else if got[i].CanonicalTimeDelta == nil || *got[i].CanonicalTimeDelta != *w.CanonicalTimeDelta {
+			t.Errorf("header %d: want delta %d, got %v", i, *w.CanonicalTimeDelta, got[i].CanonicalTimeDelta)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	headersHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/headers?sort=bogus", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("want 400 for an invalid sort, got %d", rec.Code)
+	}
+}
+
+func TestCompareStrengthTotalDifficulty(t *testing.T) {
+	light, heavy := generateMockHead(), generateMockHead()
+	light.Difficulty, light.TotalDifficulty = "0x200", "0x10000"
+	heavy.Difficulty, heavy.TotalDifficulty = "0x100", "0x10100"
+	if compareStrength(heavy, light) <= 0 || compareStrength(light, heavy) >= 0 {
+		t.Errorf("want the block with the lower difficulty but the higher total difficulty stronger")
+	}
+	heavy.TotalDifficulty = ""
+	if compareStrength(light, heavy) <= 0 {
+		t.Errorf("want the difficulties compared without a total difficulty")
+	}
+}
+
+func TestReconcileHeight(t *testing.T) {
+	defer func(mode string) { reconcileMode = mode }(reconcileMode)
+	miner := common.HexToAddress(randomHex(20))
+
+	strongHeader := generateMockBlock(100, miner).Header()
+	strongHeader.Difficulty = big.NewInt(200)
+	weakHeader := generateMockBlock(100, miner).Header()
+	weakHeader.Difficulty = big.NewInt(100)
+	strong, weak := types.NewBlockWithHeader(strongHeader), types.NewBlockWithHeader(weakHeader)
+
+	client := newMockChainReader()
+	client.addBlock(strong, false)
+	client.addBlock(weak, false)
+
+	// finalState handles the blocks in the given order, as canonical or side heads,
+	// and returns the resulting orphan flags by hash.
+	type event struct {
+		bl     *types.Block
+		orphan bool
+	}
+	finalState := func(events ...event) map[string]bool {
+		db := newTestDB(t)
+		for _, e := range events {
+			if _, err := handleHeader(client, db, e.bl.Header(), e.orphan, "", SourceHead); err != nil {
+				t.Fatal(err)
+			}
+		}
+		headers := []*Header{}
+		db.Model(&Header{}).Find(&headers)
+		state := map[string]bool{}
+		for _, h := range headers {
+			state[h.Hash] = h.Orphan
+		}
+		return state
+	}
+
+	for _, mode := range []string{reconcileArrival, reconcileForkChoice} {
+		reconcileMode = mode
+
+		// The node's choice stands, even for a weaker block, whatever the order.
+		for _, state := range []map[string]bool{
+			finalState(event{weak, false}, event{strong, true}),
+			finalState(event{strong, true}, event{weak, false}),
+			finalState(event{strong, false}, event{weak, false}),
+		} {
+			if !state[strong.Hash().Hex()] || state[weak.Hash().Hex()] {
+				t.Errorf("%s: want the node's canonical block kept, got %v", mode, state)
+			}
+		}
+	}
+
+	// Before the node chose a block, fork-choice flags the strongest one canonical; arrival leaves both orphans.
+	reconcileMode = reconcileForkChoice
+	if state := finalState(event{weak, true}, event{strong, true}); state[strong.Hash().Hex()] || !state[weak.Hash().Hex()] {
+		t.Errorf("fork-choice: want the stronger block provisionally canonical, got %v", state)
+	}
+	reconcileMode = reconcileArrival
+	if state := finalState(event{weak, true}, event{strong, true}); !state[strong.Hash().Hex()] || !state[weak.Hash().Hex()] {
+		t.Errorf("arrival: want both side heads orphans, got %v", state)
+	}
+}
+
+func TestCompetitionsCSV(t *testing.T) {
+	db := newTestDB(t)
+
+	canon, orphan := generateMockHead(), generateMockHead()
+	orphan.Number = canon.Number
+	orphan.Orphan = true
+	for _, h := range []*Header{canon, orphan} {
+		if err := h.CreateOrUpdate(db, "orphan"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	competitionsHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/competitions?format=csv", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/csv" {
+		t.Fatalf("want text/csv content type, got %q", ct)
+	}
+
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("want a header and 2 rows, got %d rows", len(rows))
+	}
+	if !reflect.DeepEqual(rows[0], competitionsCSVHeader) {
+		t.Fatalf("unexpected CSV header: %v", rows[0])
+	}
+	want := []string{strconv.FormatUint(canon.Number, 10), canon.Hash, canon.Coinbase, strconv.FormatUint(canon.Time, 10), "false", canon.Hash}
+	if !reflect.DeepEqual(rows[1], want) {
+		t.Fatalf("want canonical row %v, got %v", want, rows[1])
+	}
+	if rows[2][1] != orphan.Hash || rows[2][4] != "true" || rows[2][5] != canon.Hash {
+		t.Fatalf("unexpected orphan row: %v", rows[2])
+	}
+}
+
+func TestCompetitorsHandler(t *testing.T) {
+	db := newTestDB(t)
+
+	canon, orphan, other := generateMockHead(), generateMockHead(), generateMockHead()
+	canon.Number, orphan.Number, other.Number = 100, 100, 101
+	orphan.Orphan = true
+	orphan.UncleBy = randomHex(32)
+	canon.Txes = []Tx{generateMockTx()}
+	// The orphan is stored first, so that the canonical one isn't first by accident.
+	for _, h := range []*Header{orphan, canon, other} {
+		if err := h.CreateOrUpdate(db, "orphan", "uncle_by"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recordUncleCitation(db, orphan.Hash, orphan.UncleBy); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	competitorsHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/competitors/100", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+	headers := []*Header{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &headers); err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 2 || headers[0].Hash != canon.Hash || headers[1].Hash != orphan.Hash {
+		t.Fatalf("want the canonical block then the orphan, got %+v", headers)
+	}
+	if len(headers[0].Txes) != 1 || headers[0].Orphan || !headers[1].Orphan {
+		t.Errorf("want the canonical block with its tx, got %+v", headers[0])
+	}
+	if len(headers[1].UncledBy) != 1 || headers[1].UncledBy[0] != orphan.UncleBy {
+		t.Errorf("want the orphan's citation, got %v", headers[1].UncledBy)
+	}
+
+	for path, want := range map[string]int{
+		"/api/competitors/nope":  http.StatusBadRequest,
+		"/api/competitors/":      http.StatusNotFound,
+		"/api/competitors/100/x": http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		competitorsHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("%s: want %d, got %d", path, want, rec.Code)
+		}
+	}
+}
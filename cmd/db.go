@@ -0,0 +1,259 @@
+package cmd
+
+import (
+	"fmt"
+	"reflect"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var dbDriver string
+var dbDSN string
+
+// defaultTxBatchSize is the default number of transactions written per statement by Header.CreateOrUpdate.
+const defaultTxBatchSize = 100
+
+// txBatchSize is set by --db.tx-batch-size; the subcommands use the default.
+var txBatchSize = defaultTxBatchSize
+
+// These are the accepted values for the --db.driver flag.
+const (
+	dbDriverSQLite   = "sqlite"
+	dbDriverPostgres = "postgres"
+)
+
+// dialector returns the gorm dialector of the database driver:
+// SQLite opens the database file at path, and Postgres connects with the DSN.
+func dialector(driver, path, dsn string) (gorm.Dialector, error) {
+	switch driver {
+	case dbDriverSQLite:
+		if path == "" {
+			return nil, fmt.Errorf("please specify a database path")
+		}
+		return sqlite.Open(path), nil
+	case dbDriverPostgres:
+		if dsn == "" {
+			return nil, fmt.Errorf("please specify a database DSN")
+		}
+		return postgres.Open(dsn), nil
+	}
+	return nil, fmt.Errorf("invalid --db.driver value: %s", driver)
+}
+
+// schemaModels are the models of the database tables, migrated in this order.
+var schemaModels = []interface{}{&Header{}, &Tx{}, &CanonicalHead{}, &UncleCitationLink{}, &Meta{}, &ReorgEvent{}, &Anomaly{}}
+
+// migrateSchema migrates the database to the current schema, including data from older versions.
+// Failures to migrate existing tables beyond adding their columns and indexes are logged as warnings; see migrateSchemaWarnings.
+func migrateSchema(db *gorm.DB) error {
+	warnings, err := migrateSchemaWarnings(db)
+	for _, w := range warnings {
+		logWarn("Schema migration incomplete", "err", w)
+	}
+	return err
+}
+
+// migrateSchemaWarnings migrates the database to the current schema, including data from older versions.
+// It fails if a table can't be created, or the data can't be migrated.
+// If an existing table can't be migrated (eg. some sqlite versions fail to alter constraints, which requires recreating the table),
+// its missing columns and indexes are added one by one instead, which is enough to run, and the failures are returned as warnings.
+func migrateSchemaWarnings(db *gorm.DB) (warnings []error, err error) {
+	// The block counts, the extra strings and the inclusion distances are filled once, when their columns are added to existing headers.
+	fillCounts := db.Migrator().HasTable(&Header{}) && !db.Migrator().HasColumn(&Header{}, "tx_count")
+	fillExtra := db.Migrator().HasTable(&Header{}) && !db.Migrator().HasColumn(&Header{}, "extra_string")
+	fillDistances := db.Migrator().HasTable(&Header{}) && !db.Migrator().HasColumn(&Header{}, "inclusion_distance")
+	warnings, err = migrateTables(db)
+	if err != nil {
+		return warnings, err
+	}
+	warnings = append(warnings, migrateJoinIndexes(db)...)
+	fillCounts = fillCounts && db.Migrator().HasColumn(&Header{}, "tx_count")
+	fillExtra = fillExtra && db.Migrator().HasColumn(&Header{}, "extra_string")
+	fillDistances = fillDistances && db.Migrator().HasColumn(&Header{}, "inclusion_distance")
+	return warnings, migrateData(db, fillCounts, fillExtra, fillDistances)
+}
+
+// migrateTables creates or migrates the tables of schemaModels, and their join tables.
+// If that fails, the missing tables are created, and the missing columns and indexes of the existing ones are added
+// one by one: the failure, and those to add columns and indexes, are returned as warnings.
+// Failing to create a table is an error.
+func migrateTables(db *gorm.DB) (warnings []error, err error) {
+	m := db.Migrator()
+	migrateErr := m.AutoMigrate(schemaModels...)
+	if migrateErr == nil {
+		return nil, nil
+	}
+	warnings = append(warnings, migrateErr)
+
+	for _, model := range schemaModels {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return warnings, err
+		}
+		table := stmt.Schema.Table
+		// Unlike AutoMigrate, CreateTable doesn't create the join tables of the model's many2many relations, eg. header_txes.
+		for _, rel := range stmt.Schema.Relationships.Relations {
+			if rel.JoinTable == nil || m.HasTable(rel.JoinTable.Table) {
+				continue
+			}
+			if err := m.CreateTable(reflect.New(rel.JoinTable.ModelType).Interface()); err != nil {
+				return warnings, fmt.Errorf("creating join table %s: %w", rel.JoinTable.Table, err)
+			}
+		}
+		if !m.HasTable(model) {
+			if err := m.CreateTable(model); err != nil {
+				return warnings, fmt.Errorf("creating table %s: %w", table, err)
+			}
+			continue
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" || m.HasColumn(model, field.DBName) {
+				continue
+			}
+			if err := m.AddColumn(model, field.Name); err != nil {
+				warnings = append(warnings, fmt.Errorf("table %s: adding column %s: %w", table, field.DBName, err))
+			}
+		}
+		for name := range stmt.Schema.ParseIndexes() {
+			if m.HasIndex(model, name) {
+				continue
+			}
+			if err := m.CreateIndex(model, name); err != nil {
+				warnings = append(warnings, fmt.Errorf("table %s: creating index %s: %w", table, name, err))
+			}
+		}
+	}
+	return warnings, nil
+}
+
+// checkSchema returns an error naming the first table, join table or column of schemaModels missing from the database,
+// without migrating anything: the commands which don't migrate refuse databases of older versions with it.
+func checkSchema(db *gorm.DB) error {
+	m := db.Migrator()
+	for _, model := range schemaModels {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+		table := stmt.Schema.Table
+		if !m.HasTable(model) {
+			return fmt.Errorf("missing table %s", table)
+		}
+		for _, rel := range stmt.Schema.Relationships.Relations {
+			if rel.JoinTable != nil && !m.HasTable(rel.JoinTable.Table) {
+				return fmt.Errorf("missing join table %s", rel.JoinTable.Table)
+			}
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !m.HasColumn(model, field.DBName) {
+				return fmt.Errorf("table %s: missing column %s", table, field.DBName)
+			}
+		}
+	}
+	return nil
+}
+
+// joinIndexes are the indexes of the header_txes join table, which gorm doesn't create: its primary key
+// (tx_hash, header_hash) only serves lookups by tx_hash, while the preloads also look it up by header_hash,
+// eg. the Txes of headers.
+var joinIndexes = []struct {
+	Name, Table, Column string
+}{
+	{"idx_header_txes_header_hash", "header_txes", "header_hash"},
+}
+
+// redundantJoinIndexes are the indexes of the header_txes join table created by older versions,
+// which duplicate the leading column of its primary key.
+var redundantJoinIndexes = []string{"idx_header_txes_tx_hash"}
+
+// migrateJoinIndexes creates the joinIndexes, if missing, and drops the redundantJoinIndexes.
+// The failures are returned as warnings.
+func migrateJoinIndexes(db *gorm.DB) (warnings []error) {
+	for _, idx := range joinIndexes {
+		err := db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", idx.Name, idx.Table, idx.Column)).Error
+		if err != nil {
+			warnings = append(warnings, fmt.Errorf("table %s: creating index %s: %w", idx.Table, idx.Name, err))
+		}
+	}
+	for _, name := range redundantJoinIndexes {
+		if err := db.Exec(fmt.Sprintf("DROP INDEX IF EXISTS %s", name)).Error; err != nil {
+			warnings = append(warnings, fmt.Errorf("table header_txes: dropping index %s: %w", name, err))
+		}
+	}
+	return warnings
+}
+
+// migrateData migrates the data stored by older versions, once the tables are migrated.
+func migrateData(db *gorm.DB, fillCounts, fillExtra, fillDistances bool) error {
+	if err := migrateUncleLists(db); err != nil {
+		return err
+	}
+	if err := migrateTxNumericKeys(db); err != nil {
+		return err
+	}
+	if err := migrateNonces(db); err != nil {
+		return err
+	}
+	if fillDistances {
+		if err := migrateInclusionDistances(db); err != nil {
+			return err
+		}
+	}
+	if fillCounts {
+		if err := migrateBlockCounts(db); err != nil {
+			return err
+		}
+	}
+	if fillExtra {
+		if err := migrateExtraStrings(db); err != nil {
+			return err
+		}
+	}
+	if err := migrateUncleCitations(db); err != nil {
+		return err
+	}
+	return analyzeHeaders(db)
+}
+
+// analyzeHeaders refreshes sqlite's statistics of the headers table.
+// Without them, its query planner takes the deleted_at index (of the soft-delete condition) for the most selective,
+// and sorts the headers rather than reading them in order from idx_headers_number_orphan.
+// The rows sampled per index are limited, so that it stays quick on large databases.
+// PostgreSQL keeps its statistics up to date by itself.
+func analyzeHeaders(db *gorm.DB) error {
+	if db.Dialector.Name() != dbDriverSQLite {
+		return nil
+	}
+	if err := db.Exec("PRAGMA analysis_limit = 1000").Error; err != nil {
+		return err
+	}
+	return db.Exec("ANALYZE headers").Error
+}
+
+// migrateBlockCounts fills the tx_count and uncle_count columns of the stored headers,
+// from their stored transactions and uncles.
+func migrateBlockCounts(db *gorm.DB) error {
+	err := db.Exec("UPDATE headers SET tx_count = (SELECT COUNT(*) FROM header_txes WHERE header_txes.header_hash = headers.hash)").Error
+	if err != nil {
+		return err
+	}
+	rows := []struct {
+		Hash   string
+		Uncles HashList
+	}{}
+	err = db.Model(&Header{}).Unscoped().
+		Select("hash", "uncles").
+		Where("COALESCE(uncles, '') != ''").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if err := db.Model(&Header{}).Unscoped().Where("hash = ?", r.Hash).UpdateColumn("uncle_count", len(r.Uncles)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,381 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+func TestDialector(t *testing.T) {
+	if d, err := dialector(dbDriverSQLite, "db.sqlite", ""); err != nil || d.Name() != "sqlite" {
+		t.Errorf("want the sqlite dialector, got %v, %v", d, err)
+	}
+	if d, err := dialector(dbDriverPostgres, "", "host=localhost"); err != nil || d.Name() != "postgres" {
+		t.Errorf("want the postgres dialector, got %v, %v", d, err)
+	}
+	if _, err := dialector(dbDriverSQLite, "", "host=localhost"); err == nil {
+		t.Error("want an error without a database path")
+	}
+	if _, err := dialector(dbDriverPostgres, "db.sqlite", ""); err == nil {
+		t.Error("want an error without a database DSN")
+	}
+	if _, err := dialector("mysql", "db.sqlite", ""); err == nil {
+		t.Error("want an error for an unknown driver")
+	}
+}
+
+// TestCreateOrUpdatePostgresUpsert checks that the upserts are valid Postgres, whose conflict targets must be bare columns.
+func TestCreateOrUpdatePostgresUpsert(t *testing.T) {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := generateMockHead()
+	h.Txes = []Tx{generateMockTx()}
+	stmts := []string{}
+	db.Callback().Create().After("gorm:create").Register("test:capture", func(tx *gorm.DB) {
+		stmts = append(stmts, tx.Statement.SQL.String())
+	})
+	if err := h.CreateOrUpdate(db, "orphan"); err != nil {
+		t.Fatal(err)
+	}
+
+	upserts := 0
+	for _, s := range stmts {
+		if !strings.Contains(s, "DO UPDATE") {
+			continue
+		}
+		upserts++
+		if !strings.Contains(s, `ON CONFLICT ("hash") DO UPDATE`) {
+			t.Errorf("want a bare conflict target, got %s", s)
+		}
+	}
+	if upserts != 2 {
+		t.Fatalf("want the header and tx upserts, got %q", stmts)
+	}
+}
+
+func TestMigrateBlockCounts(t *testing.T) {
+	db := newTestDB(t)
+
+	withTxes, withUncles := generateMockHead(), generateMockHead()
+	withTxes.Txes = []Tx{generateMockTx(), generateMockTx()}
+	withUncles.Uncles = HashList{randomHex(32), randomHex(32)}
+	for _, h := range []*Header{withTxes, withUncles} {
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// As stored before the count columns.
+	for _, col := range []string{"tx_count", "uncle_count"} {
+		if err := db.Migrator().DropIndex(&Header{}, "idx_headers_"+col); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, col := range []string{"tx_count", "uncle_count"} {
+		if err := db.Migrator().DropColumn(&Header{}, col); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := migrateSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []*Header{withTxes, withUncles} {
+		got := Header{}
+		if err := db.First(&got, "hash = ?", want.Hash).Error; err != nil {
+			t.Fatal(err)
+		}
+		if got.TxCount != len(want.Txes) || got.UncleCount != len(want.Uncles) {
+			t.Errorf("want %d txes and %d uncles counted, got %d and %d", len(want.Txes), len(want.Uncles), got.TxCount, got.UncleCount)
+		}
+	}
+}
+
+func TestMigrateSchemaAdditiveFallback(t *testing.T) {
+	db := newTestDB(t)
+	h := generateMockHead()
+	if err := h.CreateOrUpdate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// As stored by an older version: without the canonical_time_delta column and the size index,
+	// and with a size column of another type, which sqlite alters by recreating the table.
+	for _, col := range []string{"canonical_time_delta", "size"} {
+		if err := db.Migrator().DropIndex(&Header{}, "idx_headers_"+col); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, col := range []string{"canonical_time_delta", "size"} {
+		if err := db.Migrator().DropColumn(&Header{}, col); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Exec("ALTER TABLE headers ADD COLUMN size text").Error; err != nil {
+		t.Fatal(err)
+	}
+	// The join table is missing too, eg. if its creation failed.
+	if err := db.Migrator().DropTable("header_txes"); err != nil {
+		t.Fatal(err)
+	}
+	// Recreating the table fails, as on sqlite versions which can't (if looking up the column in its DDL doesn't already).
+	err := db.Callback().Raw().Before("gorm:raw").Register("test:fail_recreate", func(tx *gorm.DB) {
+		if strings.Contains(tx.Statement.SQL.String(), "__temp") {
+			tx.AddError(errors.New("unsupported"))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	warnings, err := migrateSchemaWarnings(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("want the headers migration failure as the only warning, got %v", warnings)
+	}
+	if !db.Migrator().HasColumn(&Header{}, "canonical_time_delta") || !db.Migrator().HasIndex(&Header{}, "idx_headers_canonical_time_delta") {
+		t.Error("want the missing column and its index added")
+	}
+	if !db.Migrator().HasIndex(&Header{}, "idx_headers_size") {
+		t.Error("want the missing index added")
+	}
+	if stored, err := storedHeader(db, h.Hash); err != nil || stored == nil {
+		t.Errorf("want the header kept, got %v (%v)", stored, err)
+	}
+	if !db.Migrator().HasTable("header_txes") {
+		t.Error("want the missing join table created")
+	}
+
+	// Tables which can't be created are fatal.
+	if err := db.Migrator().DropTable(&ReorgEvent{}); err != nil {
+		t.Fatal(err)
+	}
+	err = db.Callback().Raw().Before("gorm:raw").Register("test:fail_create", func(tx *gorm.DB) {
+		if strings.HasPrefix(tx.Statement.SQL.String(), "CREATE TABLE") {
+			tx.AddError(errors.New("read-only"))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrateSchemaWarnings(db); err == nil {
+		t.Error("want an error for a table which can't be created")
+	}
+}
+
+func TestCreateOrUpdateTxBatches(t *testing.T) {
+	db := newTestDB(t)
+
+	h := generateMockHead()
+	for i := 0; i < 250; i++ {
+		h.Txes = append(h.Txes, generateMockTx())
+	}
+	inserts := 0
+	err := db.Callback().Create().After("gorm:create").Register("test:count", func(tx *gorm.DB) {
+		if strings.HasPrefix(tx.Statement.SQL.String(), "INSERT INTO `txes`") {
+			inserts++
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.CreateOrUpdate(db, "orphan"); err != nil {
+		t.Fatal(err)
+	}
+	if want := (len(h.Txes) + txBatchSize - 1) / txBatchSize; inserts != want {
+		t.Errorf("want %d batches of txes, got %d", want, inserts)
+	}
+
+	var txes, links int64
+	db.Model(&Tx{}).Count(&txes)
+	db.Table("header_txes").Where("header_hash = ?", h.Hash).Count(&links)
+	if txes != 250 || links != 250 {
+		t.Errorf("want 250 txes linked to the header, got %d txes and %d links", txes, links)
+	}
+}
+
+// createOrUpdateUnbatched is Header.CreateOrUpdate before the transactions were batched, for comparison:
+// the transactions are written with the header (as its associations), then upserted again, each write in its own transaction.
+func createOrUpdateUnbatched(db *gorm.DB, h *Header, assignCols ...string) error {
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "hash"}},
+		DoUpdates: clause.AssignmentColumns(assignCols),
+	}).Create(h).Error
+	if err != nil || len(h.Txes) == 0 {
+		return err
+	}
+	for i := range h.Txes {
+		h.Txes[i].Headers = []*Header{h}
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "hash"}},
+		UpdateAll: true,
+	}).Create(&h.Txes).Error
+}
+
+// BenchmarkCreateOrUpdate writes blocks of 500 transactions.
+func BenchmarkCreateOrUpdate(b *testing.B) {
+	for _, bench := range []struct {
+		name  string
+		write func(*gorm.DB, *Header) error
+	}{
+		{"unbatched", func(db *gorm.DB, h *Header) error { return createOrUpdateUnbatched(db, h, "orphan") }},
+		{"batched", func(db *gorm.DB, h *Header) error { return h.CreateOrUpdate(db, "orphan") }},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			db, err := gorm.Open(sqlite.Open(filepath.Join(b.TempDir(), "bench.db")), &gorm.Config{Logger: logger.Discard})
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := migrateSchema(db); err != nil {
+				b.Fatal(err)
+			}
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				h := generateMockHead()
+				for j := 0; j < 500; j++ {
+					h.Txes = append(h.Txes, generateMockTx())
+				}
+				b.StartTimer()
+				if err := bench.write(db, h); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestJoinIndexesUsed(t *testing.T) {
+	db := newTestDB(t)
+
+	h := generateMockHead()
+	h.Txes = []Tx{generateMockTx(), generateMockTx()}
+	if err := h.CreateOrUpdate(db); err != nil {
+		t.Fatal(err)
+	}
+	// The queries preloading Txes (by header_hash) and Headers (by tx_hash, the leading column of the primary key).
+	for column, index := range map[string]string{"header_hash": "idx_header_txes_header_hash", "tx_hash": "sqlite_autoindex_header_txes_1"} {
+		plan := []struct {
+			Detail string
+		}{}
+		q := fmt.Sprintf("EXPLAIN QUERY PLAN SELECT * FROM header_txes WHERE %s IN (?, ?)", column)
+		if err := db.Raw(q, randomHex(32), randomHex(32)).Scan(&plan).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(plan) == 0 || !strings.HasPrefix(plan[0].Detail, "SEARCH") || !strings.Contains(plan[0].Detail, index) {
+			t.Errorf("want the lookup by %s to search %s, got %+v", column, index, plan)
+		}
+	}
+
+	// Databases from before the indexes get them, and lose the redundant ones.
+	for _, idx := range joinIndexes {
+		if err := db.Migrator().DropIndex(idx.Table, idx.Name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range redundantJoinIndexes {
+		if err := db.Exec(fmt.Sprintf("CREATE INDEX %s ON header_txes (tx_hash)", name)).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := migrateSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, idx := range joinIndexes {
+		if !db.Migrator().HasIndex(idx.Table, idx.Name) {
+			t.Errorf("want index %s created", idx.Name)
+		}
+	}
+	for _, name := range redundantJoinIndexes {
+		if db.Migrator().HasIndex("header_txes", name) {
+			t.Errorf("want index %s dropped", name)
+		}
+	}
+}
+
+// BenchmarkPreloadTxes preloads the transactions of 1000 headers, as /api/headers?include_txes=true,
+// with and without the header_txes indexes.
+func BenchmarkPreloadTxes(b *testing.B) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(b.TempDir(), "bench.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := migrateSchema(db); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 2000; i++ {
+		h := generateMockHead()
+		for j := 0; j < 20; j++ {
+			h.Txes = append(h.Txes, generateMockTx())
+		}
+		if err := h.CreateOrUpdate(db); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	preload := func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			headers := []Header{}
+			if err := db.Preload("Txes").Order("number DESC").Limit(1000).Find(&headers).Error; err != nil {
+				b.Fatal(err)
+			}
+			if len(headers) != 1000 || len(headers[0].Txes) != 20 {
+				b.Fatalf("want 1000 headers with 20 txes, got %d", len(headers))
+			}
+		}
+	}
+	b.Run("indexed", preload)
+	for _, idx := range joinIndexes {
+		if err := db.Migrator().DropIndex(idx.Table, idx.Name); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.Run("unindexed", preload)
+}
+
+func TestCheckSchema(t *testing.T) {
+	db := newTestDB(t)
+	if err := checkSchema(db); err != nil {
+		t.Fatalf("want a migrated database accepted, got %v", err)
+	}
+
+	// As stored before the inclusion distances.
+	if err := db.Migrator().DropIndex(&Header{}, "idx_headers_inclusion_distance"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrator().DropColumn(&Header{}, "inclusion_distance"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkSchema(db); err == nil || !strings.Contains(err.Error(), "inclusion_distance") {
+		t.Fatalf("want the missing column reported, got %v", err)
+	}
+	if db.Migrator().HasColumn(&Header{}, "inclusion_distance") {
+		t.Fatal("want nothing migrated")
+	}
+
+	if err := migrateSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrator().DropTable("header_txes"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkSchema(db); err == nil || !strings.Contains(err.Error(), "header_txes") {
+		t.Fatalf("want the missing join table reported, got %v", err)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package cmd
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// freeDiskSpace is not implemented on this platform.
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, fmt.Errorf("free disk space check not supported on %s", runtime.GOOS)
+}
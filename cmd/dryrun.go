@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"gorm.io/gorm"
+)
+
+var dryRun bool
+
+// dryRunMemoryDB is the SQLite database used by --dry-run without --db.path: an empty one, in memory,
+// shared by the connections of the process.
+const dryRunMemoryDB = "file::memory:?cache=shared"
+
+// dryRunWrites makes the database skip its writes, ie. creates, updates, deletes, and raw statements (eg. schema
+// migrations), logging the SQL of each instead. Reads are unaffected.
+func dryRunWrites(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().Replace("gorm:create", dryRunCallback(cb.Create().Get("gorm:create"))); err != nil {
+		return err
+	}
+	if err := cb.Update().Replace("gorm:update", dryRunCallback(cb.Update().Get("gorm:update"))); err != nil {
+		return err
+	}
+	if err := cb.Delete().Replace("gorm:delete", dryRunCallback(cb.Delete().Get("gorm:delete"))); err != nil {
+		return err
+	}
+	return cb.Raw().Replace("gorm:raw", dryRunCallback(cb.Raw().Get("gorm:raw")))
+}
+
+// dryRunCallback runs gorm's write callback in gorm's own dry run mode, which builds the statement without executing it,
+// and logs the statement.
+func dryRunCallback(write func(*gorm.DB)) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		// The config is shared by the sessions of the database, so this statement gets a copy.
+		config := tx.Config
+		dry := *config
+		dry.DryRun = true
+		tx.Config = &dry
+		write(tx)
+		tx.Config = config
+
+		if tx.Error == nil && tx.Statement.SQL.Len() > 0 {
+			logInfo("Dry run: skipped write", "sql", tx.Dialector.Explain(tx.Statement.SQL.String(), tx.Statement.Vars...))
+		}
+	}
+}
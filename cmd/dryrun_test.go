@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestDryRunWrites(t *testing.T) {
+	db := newTestDB(t)
+
+	stored := generateMockHead()
+	stored.Txes = []Tx{generateMockTx()}
+	if err := stored.CreateOrUpdate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dryRunWrites(db); err != nil {
+		t.Fatal(err)
+	}
+
+	h := generateMockHead()
+	h.Txes = []Tx{generateMockTx()}
+	if err := h.CreateOrUpdate(db, "orphan"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(&Header{}).Where("hash = ?", stored.Hash).Update("orphan", true).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Unscoped().Where("1 = 1").Delete(&Tx{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("DELETE FROM headers").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := setCursor(db, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads still work, and see the database as it was.
+	headers := []Header{}
+	if err := db.Find(&headers).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 1 || headers[0].Hash != stored.Hash || headers[0].Orphan {
+		t.Errorf("want only the header stored before the dry run, unchanged, got %+v", headers)
+	}
+	var txes int64
+	if err := db.Model(&Tx{}).Count(&txes).Error; err != nil {
+		t.Fatal(err)
+	}
+	if txes != 1 {
+		t.Errorf("want 1 tx, got %d", txes)
+	}
+	if _, ok, err := cursor(db); err != nil || ok {
+		t.Errorf("want no cursor, got %v %v", ok, err)
+	}
+}
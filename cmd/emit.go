@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+var emitStdout bool
+
+// emitOut receives the newly stored anomalies as NDJSON. It is set to stdout by --emit.stdout, and is nil otherwise.
+var emitOut io.Writer
+
+// emitMu serializes the writes to emitOut, so that lines are never interleaved.
+var emitMu sync.Mutex
+
+// These are the values for Emission.Kind.
+const (
+	EmitKindOrphan      = "orphan"
+	EmitKindUncle       = "uncle"
+	EmitKindCompetition = "competition"
+)
+
+// Emission is a newly stored anomaly, as emitted on a single line by --emit.stdout.
+// Header is set for orphans and uncles, Competition for competitions.
+type Emission struct {
+	Kind        string       `json:"kind"`
+	Header      *Header      `json:"header,omitempty"`
+	Competition *Competition `json:"competition,omitempty"`
+}
+
+// emit writes the emission as a line of JSON to emitOut, if set.
+// Failures are logged, not returned; like metrics, emissions are not worth interrupting the tracker for.
+func emit(e Emission) {
+	if emitOut == nil {
+		return
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		logError("Emit failed", "err", err)
+		return
+	}
+	emitMu.Lock()
+	defer emitMu.Unlock()
+	if _, err := emitOut.Write(append(b, '\n')); err != nil {
+		logError("Emit failed", "err", err)
+	}
+}
+
+// storedHeader returns the stored version of the header with the given hash, or nil if there is none.
+func storedHeader(db *gorm.DB, hash string) (*Header, error) {
+	headers := []*Header{}
+	if err := db.Model(&Header{}).Where("hash = ?", hash).Limit(1).Find(&headers).Error; err != nil {
+		return nil, err
+	}
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	return headers[0], nil
+}
+
+// emitAnomalies emits what storing the header has newly recorded, given the header's previously stored version (nil if none):
+// the header itself if it became an orphan or an uncle, and the competition at its height if the header joined one.
+func emitAnomalies(db *gorm.DB, header, prev *Header) error {
+	if header.UncleBy != "" && (prev == nil || prev.UncleBy == "") {
+		emit(Emission{Kind: EmitKindUncle, Header: header})
+	} else if header.Orphan && (prev == nil || !prev.Orphan) {
+		emit(Emission{Kind: EmitKindOrphan, Header: header})
+	}
+	if prev != nil {
+		return nil
+	}
+
+	c, err := competitionAt(db, header.Number)
+	if err != nil || c == nil {
+		return err
+	}
+	emit(Emission{Kind: EmitKindCompetition, Competition: c})
+	return nil
+}
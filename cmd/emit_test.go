@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestEmitAnomalies(t *testing.T) {
+	db := newTestDB(t)
+	client := newMockChainReader()
+
+	out := &bytes.Buffer{}
+	defer func() { emitOut = nil }()
+	emitOut = out
+
+	canon := generateMockBlock(100, common.HexToAddress(randomHex(20)))
+	orphan := generateMockBlock(100, common.HexToAddress(randomHex(20)))
+	client.addBlock(canon, true)
+	client.addBlock(orphan, false)
+	if _, err := handleHeader(client, db, orphan.Header(), true, "", SourceSide); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleHeader(client, db, canon.Header(), false, "", SourceHead); err != nil {
+		t.Fatal(err)
+	}
+	// Storing a header again emits nothing new.
+	if _, err := handleHeader(client, db, orphan.Header(), true, "", SourceSide); err != nil {
+		t.Fatal(err)
+	}
+
+	kinds := []string{}
+	sc := bufio.NewScanner(out)
+	for sc.Scan() {
+		e := Emission{}
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("invalid line %q: %v", sc.Text(), err)
+		}
+		kinds = append(kinds, e.Kind)
+		switch e.Kind {
+		case EmitKindOrphan:
+			if e.Header == nil || e.Header.Hash != orphan.Hash().Hex() {
+				t.Errorf("want orphan %s, got %+v", orphan.Hash().Hex(), e.Header)
+			}
+		case EmitKindCompetition:
+			if e.Competition == nil || e.Competition.Canonical != canon.Hash().Hex() || len(e.Competition.Headers) != 2 {
+				t.Errorf("want competition won by %s, got %+v", canon.Hash().Hex(), e.Competition)
+			}
+		}
+	}
+	if len(kinds) != 2 || kinds[0] != EmitKindOrphan || kinds[1] != EmitKindCompetition {
+		t.Fatalf("want an orphan then a competition, got %v", kinds)
+	}
+}
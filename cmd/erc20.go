@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var decodeERC20 bool
+
+// erc20TransferSelector is the 4-byte selector of the ERC-20 transfer(address,uint256) function.
+var erc20TransferSelector = []byte{0xa9, 0x05, 0x9c, 0xbb}
+
+// decodeERC20Transfer decodes the recipient and amount of an ERC-20 transfer(address,uint256) call from the transaction's input data.
+// It returns false if the data isn't such a call, including malformed calls (short arguments, or an address with high bits set).
+// Trailing bytes after the arguments are ignored, as by the ABI decoders of the token contracts.
+func decodeERC20Transfer(data []byte) (to common.Address, amount *big.Int, ok bool) {
+	if len(data) < 4+2*32 || !bytes.Equal(data[:4], erc20TransferSelector) {
+		return common.Address{}, nil, false
+	}
+	addr, value := data[4:4+32], data[4+32:4+2*32]
+	for _, b := range addr[:12] {
+		if b != 0 {
+			return common.Address{}, nil, false
+		}
+	}
+	return common.BytesToAddress(addr[12:]), new(big.Int).SetBytes(value), true
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestDecodeERC20Transfer(t *testing.T) {
+	to := common.HexToAddress("0x00000000000000000000000000000000000000ff")
+	call := common.FromHex("0xa9059cbb" +
+		"00000000000000000000000000000000000000000000000000000000000000ff" +
+		"0000000000000000000000000000000000000000000000000de0b6b3a7640000")
+
+	cases := []struct {
+		name string
+		data []byte
+		ok   bool
+	}{
+		{"transfer", call, true},
+		{"trailing bytes", append(append([]byte{}, call...), 0x01), true},
+		{"short arguments", call[:len(call)-1], false},
+		{"other selector", append([]byte{0x23, 0xb8, 0x72, 0xdd}, call[4:]...), false},
+		{"dirty address", append(append(append([]byte{}, call[:4]...), 0x01), call[5:]...), false},
+		{"empty", nil, false},
+	}
+	for _, c := range cases {
+		gotTo, amount, ok := decodeERC20Transfer(c.data)
+		if ok != c.ok {
+			t.Errorf("%s: want ok %v, got %v", c.name, c.ok, ok)
+			continue
+		}
+		if ok && (gotTo != to || amount.Cmp(big.NewInt(1e18)) != 0) {
+			t.Errorf("%s: want %s and 1e18, got %s and %v", c.name, to.Hex(), gotTo.Hex(), amount)
+		}
+	}
+}
+
+func TestTokenTransferTxes(t *testing.T) {
+	defer func(decode, skipFrom bool) { decodeERC20, txSkipFrom = decode, skipFrom }(decodeERC20, txSkipFrom)
+	decodeERC20, txSkipFrom = true, true
+	db := newTestDB(t)
+
+	token, recipient := common.HexToAddress(randomHex(20)), common.HexToAddress(randomHex(20))
+	transfer := append(common.FromHex("0xa9059cbb"), common.LeftPadBytes(recipient.Bytes(), 32)...)
+	transfer = append(transfer, common.LeftPadBytes(big.NewInt(42).Bytes(), 32)...)
+	txes, err := blockTxes2AppTxes([]*types.Transaction{
+		types.NewTx(&types.LegacyTx{Nonce: 0, To: &token, Gas: 60000, GasPrice: big.NewInt(1e9), Data: transfer}),
+		// Malformed: the block's transactions are still translated.
+		types.NewTx(&types.LegacyTx{Nonce: 1, To: &token, Gas: 60000, GasPrice: big.NewInt(1e9), Data: transfer[:40]}),
+		types.NewTx(&types.LegacyTx{Nonce: 2, To: &recipient, Gas: 21000, GasPrice: big.NewInt(1e9)}),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txes[0].TokenTo != recipient.Hex() || txes[0].TokenAmount != "42" {
+		t.Errorf("want a transfer of 42 to %s, got %q and %q", recipient.Hex(), txes[0].TokenTo, txes[0].TokenAmount)
+	}
+	for _, tx := range txes[1:] {
+		if tx.TokenTo != "" || tx.TokenAmount != "" || tx.Error != "" {
+			t.Errorf("want no token fields nor error, got %+v", tx)
+		}
+	}
+
+	h := generateMockHead()
+	h.Txes = txes
+	if err := h.CreateOrUpdate(db); err != nil {
+		t.Fatal(err)
+	}
+	for q, want := range map[string]int{"true": 1, "false": 2} {
+		w := httptest.NewRecorder()
+		txesHandler(db)(w, httptest.NewRequest("GET", "/api/txes?include_headers=false&token_transfer="+q, nil))
+		got := []Tx{}
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != want {
+			t.Errorf("token_transfer=%s: want %d transactions, got %d", q, want, len(got))
+		}
+	}
+	w := httptest.NewRecorder()
+	txesHandler(db)(w, httptest.NewRequest("GET", "/api/txes?token_transfer=maybe", nil))
+	if w.Code != 400 {
+		t.Errorf("want 400 for an invalid token_transfer, got %d", w.Code)
+	}
+}
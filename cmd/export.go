@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// exportBatchSize is the number of headers read from the database between writes of an export.
+const exportBatchSize = 100
+
+// headerCSVColumns are the columns of /api/headers.csv, named like the JSON fields of Header.
+// The uncles are space-separated.
+var headerCSVColumns = []string{
+	"hash", "number", "parentHash", "miner", "difficulty", "totalDifficulty", "timestamp",
+	"gasLimit", "gasUsed", "baseFeePerGas", "stateRoot", "receiptsRoot",
+	"orphan", "uncleBy", "uncles", "txCount", "uncleCount", "size", "winReason", "blockReward", "totalTips", "burntFees", "error",
+}
+
+// headerCSVRecord returns the header's values for headerCSVColumns.
+func headerCSVRecord(h *Header) []string {
+	return []string{
+		h.Hash, strconv.FormatUint(h.Number, 10), h.ParentHash, h.Coinbase, h.Difficulty, h.TotalDifficulty,
+		strconv.FormatUint(h.Time, 10), strconv.FormatUint(h.GasLimit, 10), strconv.FormatUint(h.GasUsed, 10),
+		h.BaseFee, h.Root, h.ReceiptHash,
+		strconv.FormatBool(h.Orphan), h.UncleBy, strings.Join(h.Uncles, " "),
+		strconv.Itoa(h.TxCount), strconv.Itoa(h.UncleCount), strconv.FormatUint(h.Size, 10), h.WinReason, h.BlockReward, h.TotalTips, h.BurntFees, h.Error,
+	}
+}
+
+// streamHeaders reads the headers selected by the /api/headers query parameters (including raw_sql) row by row,
+// and hands them to write in batches, so that large exports aren't held in memory.
+// Exports are not capped by the default limit of /api/headers nor by --api.max-limit: see exportRange.
+// Unless raw_sql is used, the batches are filled like /api/headers, including transactions if withTxes is set.
+// It returns before writing anything if the query parameters are invalid, with a *queryParamError.
+func streamHeaders(db *gorm.DB, r *http.Request, withTxes bool, write func([]*Header) error) error {
+	var rows *sql.Rows
+	raw := r.URL.Query().Get("raw_sql")
+	if raw != "" {
+		// As for /api/headers, the raw SQL runs in a transaction which is rolled back, with a timeout.
+		tx, cancel, err := beginRawSQL(r, db)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		defer tx.Rollback()
+		if rows, err = tx.Raw(raw).Rows(); err != nil {
+			return err
+		}
+	} else {
+		query, err := headersQuery(db, r)
+		if err != nil {
+			return &queryParamError{err}
+		}
+		if rows, err = exportRange(r, query).Rows(); err != nil {
+			return err
+		}
+	}
+	defer rows.Close()
+
+	flush := func(batch []*Header) error {
+		if raw == "" {
+			if err := fillBatch(db, r, batch, withTxes); err != nil {
+				return err
+			}
+		}
+		return write(batch)
+	}
+
+	batch := make([]*Header, 0, exportBatchSize)
+	for rows.Next() {
+		h := &Header{}
+		if err := db.ScanRows(rows, h); err != nil {
+			return err
+		}
+		batch = append(batch, h)
+		if len(batch) == exportBatchSize {
+			if err := flush(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		return flush(batch)
+	}
+	return nil
+}
+
+// exportRange applies the limit and offset query parameters to the query of an export, if given:
+// unlike paginate, all the selected headers are exported by default, since they are streamed.
+func exportRange(r *http.Request, res *gorm.DB) *gorm.DB {
+	if q := r.URL.Query().Get("limit"); q != "" {
+		if limit, _ := strconv.ParseUint(q, 10, 64); limit > 0 {
+			res = res.Limit(int(limit))
+		}
+	}
+	if q := r.URL.Query().Get("offset"); q != "" {
+		offset, _ := strconv.ParseUint(q, 10, 64)
+		res = res.Offset(int(offset))
+	}
+	return res
+}
+
+// fillBatch fills the headers' transactions (if withTxes is set), uncledBy, and parent miners (if requested with
+// with_parent_miner), as /api/headers does.
+func fillBatch(db *gorm.DB, r *http.Request, headers []*Header, withTxes bool) error {
+	if withTxes && r.URL.Query().Get("include_txes") != "false" {
+		hashes := make([]string, 0, len(headers))
+		for _, h := range headers {
+			hashes = append(hashes, h.Hash)
+		}
+		loaded := []*Header{}
+		if err := db.Model(&Header{}).Select("hash").Preload("Txes").Where("hash IN ?", hashes).Find(&loaded).Error; err != nil {
+			return err
+		}
+		txes := map[string][]Tx{}
+		for _, h := range loaded {
+			txes[h.Hash] = h.Txes
+		}
+		for _, h := range headers {
+			h.Txes = txes[h.Hash]
+		}
+	}
+	if err := fillUncledBy(db, headers); err != nil {
+		return err
+	}
+	if r.URL.Query().Get("with_parent_miner") == "true" {
+		return fillParentMiners(db, headers)
+	}
+	return nil
+}
+
+// queryParamError is an invalid query parameter.
+type queryParamError struct {
+	err error
+}
+
+func (e *queryParamError) Error() string { return e.err.Error() }
+
+// handleExportError responds with the error if nothing was written yet, and logs it otherwise.
+func handleExportError(w http.ResponseWriter, err error, written bool) {
+	if err == nil {
+		return
+	}
+	logError("Export failed", "err", err, "written", written)
+	if written {
+		return
+	}
+	if _, ok := err.(*queryParamError); ok {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err == errRawSQLDisabled {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// headersJSONLHandler serves the headers selected like /api/headers as JSON Lines, ie. one JSON object per line.
+func headersJSONLHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		written := false
+		enc := json.NewEncoder(w)
+		err := streamHeaders(db, r, true, func(headers []*Header) error {
+			if !written {
+				w.Header().Set("Content-Type", "application/x-ndjson")
+				written = true
+			}
+			for _, h := range headers {
+				if err := enc.Encode(h); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err == nil && !written {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		handleExportError(w, err, written)
+	}
+}
+
+// headersCSVHandler serves the headers selected like /api/headers as CSV, with the columns of headerCSVColumns.
+// Transactions are not included.
+func headersCSVHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		written := false
+		cw := csv.NewWriter(w)
+		writeHead := func() error {
+			w.Header().Set("Content-Type", "text/csv")
+			written = true
+			return cw.Write(headerCSVColumns)
+		}
+		err := streamHeaders(db, r, false, func(headers []*Header) error {
+			if !written {
+				if err := writeHead(); err != nil {
+					return err
+				}
+			}
+			for _, h := range headers {
+				if err := cw.Write(headerCSVRecord(h)); err != nil {
+					return err
+				}
+			}
+			cw.Flush()
+			return cw.Error()
+		})
+		if err == nil && !written {
+			err = writeHead()
+			cw.Flush()
+		}
+		handleExportError(w, err, written)
+	}
+}
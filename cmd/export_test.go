@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHeadersExports(t *testing.T) {
+	db := newTestDB(t)
+
+	// More headers than a batch, to exercise the batching.
+	for n := uint64(0); n < exportBatchSize+50; n++ {
+		h := generateMockHead()
+		h.Number, h.Orphan = n, n%2 == 1
+		h.Txes = []Tx{generateMockTx()}
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	serve := func(handler http.HandlerFunc, q string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/headers.x?"+q, nil))
+		return rec
+	}
+
+	rec := serve(headersJSONLHandler(db), "orphan=1&limit=1000")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	lines := 0
+	sc := bufio.NewScanner(rec.Body)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		h := &Header{}
+		if err := json.Unmarshal(sc.Bytes(), h); err != nil {
+			t.Fatal(err)
+		}
+		if !h.Orphan || len(h.Txes) != 1 {
+			t.Errorf("want orphans with their transaction, got orphan=%v with %d tx(es)", h.Orphan, len(h.Txes))
+		}
+		lines++
+	}
+	if lines != (exportBatchSize+50)/2 {
+		t.Errorf("want %d orphans, got %d lines", (exportBatchSize+50)/2, lines)
+	}
+
+	rec = serve(headersCSVHandler(db), "number_min=10&number_max=19")
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 11 || len(records[0]) != len(headerCSVColumns) || records[0][0] != "hash" {
+		t.Fatalf("want a header row and 10 records, got %d rows: %v", len(records), records[0])
+	}
+	if records[1][1] != "19" {
+		t.Errorf("want the records in descending order by number, got %s first", records[1][1])
+	}
+
+	// Exports aren't capped by the pagination of /api/headers, unless a limit is given.
+	defer func(d, m uint64) { apiDefaultLimitHeaders, apiMaxLimit = d, m }(apiDefaultLimitHeaders, apiMaxLimit)
+	apiDefaultLimitHeaders, apiMaxLimit = 10, 20
+	for q, want := range map[string]int{"": exportBatchSize + 50, "limit=5&offset=140": 5, "offset=140": 10} {
+		records, err := csv.NewReader(serve(headersCSVHandler(db), q).Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records)-1 != want {
+			t.Errorf("%q: want %d records, got %d", q, want, len(records)-1)
+		}
+	}
+
+	if rec := serve(headersCSVHandler(db), "number_min=nope"); rec.Code != http.StatusBadRequest {
+		t.Errorf("want 400 for an invalid parameter, got %d", rec.Code)
+	}
+	if rec := serve(headersJSONLHandler(db), "number_min=1000"); rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("want an empty 200 response, got %d: %q", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// extraString returns the header's extra data as text, if it is valid UTF-8 made of printable characters
+// (eg. a mining pool tag like "stratum-eu-2"), and an empty string otherwise. Trailing NUL padding is ignored.
+func extraString(extra []byte) string {
+	s := strings.TrimRight(string(extra), "\x00")
+	if !utf8.ValidString(s) {
+		return ""
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) {
+			return ""
+		}
+	}
+	return s
+}
+
+// migrateExtraStrings fills the extra_string column of the stored headers from their extra data.
+func migrateExtraStrings(db *gorm.DB) error {
+	rows := []struct {
+		Hash  string
+		Extra []byte
+	}{}
+	err := db.Model(&Header{}).Unscoped().
+		Select("hash", "extra").
+		Where("extra IS NOT NULL").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		s := extraString(r.Extra)
+		if s == "" {
+			continue
+		}
+		if err := db.Model(&Header{}).Unscoped().Where("hash = ?", r.Hash).UpdateColumn("extra_string", s).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// likeContains returns a LIKE pattern matching the strings containing s, with LIKE's wildcards in s escaped with a backslash.
+func likeContains(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+	return "%" + s + "%"
+}
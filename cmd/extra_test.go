@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestExtraString(t *testing.T) {
+	for extra, want := range map[string]string{
+		"stratum-eu-2":     "stratum-eu-2",
+		"Ethermine – eu1":  "Ethermine – eu1",
+		"2miners\x00\x00":  "2miners",
+		"":                 "",
+		"tag\nwith\tbreak": "",
+		string(common.FromHex("0xd883010a11846765746888676f312e31372e33856c696e7578")): "",
+		"\xff\xfe": "",
+	} {
+		if got := extraString([]byte(extra)); got != want {
+			t.Errorf("extraString(%q): want %q, got %q", extra, want, got)
+		}
+	}
+}
+
+func TestHeadersExtraContainsFilter(t *testing.T) {
+	db := newTestDB(t)
+
+	pool, other, binary := generateMockHead(), generateMockHead(), generateMockHead()
+	pool.Extra = []byte("Stratum-EU-2")
+	other.Extra = []byte("100%_pool")
+	binary.Extra = common.FromHex("0xd883010a11")
+	for _, h := range []*Header{pool, other, binary} {
+		h.ExtraString = extraString(h.Extra)
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for q, want := range map[string]string{
+		"stratum":  pool.Hash,
+		"EU-2":     pool.Hash,
+		"100%":     other.Hash,
+		"%_":       other.Hash,
+		"0_p":      "",
+		"\xd8\x83": "",
+	} {
+		rec := httptest.NewRecorder()
+		headersHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/headers?extra_contains="+url.QueryEscape(q), nil))
+		headers := []*Header{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &headers); err != nil {
+			t.Fatal(err)
+		}
+		if want == "" {
+			if len(headers) != 0 {
+				t.Errorf("extra_contains=%q: want no headers, got %d", q, len(headers))
+			}
+			continue
+		}
+		if len(headers) != 1 || headers[0].Hash != want {
+			t.Errorf("extra_contains=%q: want header %s, got %+v", q, want, headers)
+		}
+	}
+}
+
+func TestMigrateExtraStrings(t *testing.T) {
+	db := newTestDB(t)
+
+	h := generateMockHead()
+	h.Extra = []byte("stratum-eu-2")
+	if err := h.CreateOrUpdate(db); err != nil {
+		t.Fatal(err)
+	}
+	// As stored before the extra_string column.
+	if err := db.Migrator().DropColumn(&Header{}, "extra_string"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	got := Header{}
+	if err := db.First(&got, "hash = ?", h.Hash).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got.ExtraString != "stratum-eu-2" {
+		t.Errorf("want the extra string filled, got %q", got.ExtraString)
+	}
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// ForkPoint is a parent block with more than one stored child, ie. where the chain actually forked.
+type ForkPoint struct {
+	ParentHash string    `json:"parentHash"`
+	Children   []*Header `json:"children"`
+}
+
+// findForkPoints returns the fork points whose children have heights between min and max (inclusive),
+// in descending order by height.
+// Unlike competitions, which group headers by height, fork points group them by shared parent,
+// so competing branches which diverged further back are not lumped together.
+func findForkPoints(db *gorm.DB, min, max uint64) ([]*ForkPoint, error) {
+	parents := []string{}
+	err := db.Model(&Header{}).
+		Where("number >= ? AND number <= ?", min, max).
+		Group("parent_hash").
+		Having("COUNT(*) > 1").
+		Order("MAX(number) DESC").
+		Pluck("parent_hash", &parents).Error
+	if err != nil {
+		return nil, err
+	}
+
+	forks := []*ForkPoint{}
+	for _, p := range parents {
+		f := &ForkPoint{ParentHash: p}
+		err := db.Model(&Header{}).
+			Where("parent_hash = ?", p).
+			Where("number >= ? AND number <= ?", min, max).
+			Order("orphan ASC, hash ASC").
+			Find(&f.Children).Error
+		if err != nil {
+			return nil, err
+		}
+		forks = append(forks, f)
+	}
+	return forks, nil
+}
+
+func forksHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		min, max, err := numberRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		forks, err := findForkPoints(db, min, max)
+		if err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, forks)
+	}
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestFindForkPoints(t *testing.T) {
+	db := newTestDB(t)
+
+	store := func(number uint64, parent string, orphan bool) *Header {
+		h := generateMockHead()
+		h.Number, h.ParentHash, h.Orphan = number, parent, orphan
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	// Block 100 has two children, forking the chain.
+	fork := store(100, randomHex(32), false)
+	canon := store(101, fork.Hash, false)
+	orphan := store(101, fork.Hash, true)
+
+	// Competitors at the same height, but on different parents, are not a fork point.
+	store(200, randomHex(32), false)
+	store(200, randomHex(32), true)
+
+	forks, err := findForkPoints(db, 0, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(forks) != 1 {
+		t.Fatalf("want 1 fork point, got %d", len(forks))
+	}
+	f := forks[0]
+	if f.ParentHash != fork.Hash || len(f.Children) != 2 {
+		t.Fatalf("want fork at %s with 2 children, got %s with %d", fork.Hash, f.ParentHash, len(f.Children))
+	}
+	if f.Children[0].Hash != canon.Hash || f.Children[1].Hash != orphan.Hash {
+		t.Fatalf("want the canonical child listed first, got %s, %s", f.Children[0].Hash, f.Children[1].Hash)
+	}
+
+	if forks, _ := findForkPoints(db, 102, 1000); len(forks) != 0 {
+		t.Fatalf("want no fork points out of range, got %d", len(forks))
+	}
+}
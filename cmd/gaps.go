@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Gap is an inclusive range of heights missing canonical data.
+type Gap struct {
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"`
+}
+
+// findGaps returns the ranges of heights between min and max (inclusive) which hold stored headers,
+// but none of them canonical.
+// The tracker only stores canonical blocks which are related to orphans, so a missing canonical
+// header is only a gap where we know there was competition, ie. where an orphan is stored.
+func findGaps(db *gorm.DB, min, max uint64) ([]Gap, error) {
+	numbers := []uint64{}
+	err := db.Model(&Header{}).
+		Where("number >= ? AND number <= ?", min, max).
+		Group("number").
+		Having("SUM(CASE WHEN orphan THEN 0 ELSE 1 END) = 0").
+		Order("number ASC").
+		Pluck("number", &numbers).Error
+	if err != nil {
+		return nil, err
+	}
+
+	gaps := []Gap{}
+	for _, n := range numbers {
+		if len(gaps) > 0 && gaps[len(gaps)-1].To+1 == n {
+			gaps[len(gaps)-1].To = n
+			continue
+		}
+		gaps = append(gaps, Gap{From: n, To: n})
+	}
+	return gaps, nil
+}
+
+// runGapScanner periodically scans the database for gaps, logging them and recording them for /status.
+// If backfillCh is not nil, each height missing canonical data is sent to it.
+// It never returns.
+func runGapScanner(db *gorm.DB, interval time.Duration, backfillCh chan<- uint64) {
+	for range time.Tick(interval) {
+		tip := status.LatestNumber()
+		gaps, err := findGaps(db, 0, tip)
+		if err != nil {
+			logError("Gap scan failed", "err", err)
+			continue
+		}
+		status.SetGaps(gaps)
+		for _, g := range gaps {
+			logWarn("Gap: missing canonical block(s)", "from", g.From, "to", g.To)
+			if backfillCh == nil {
+				continue
+			}
+			for n := g.From; n <= g.To; n++ {
+				backfillCh <- n
+			}
+		}
+	}
+}
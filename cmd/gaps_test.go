@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindGaps(t *testing.T) {
+	db := newTestDB(t)
+
+	// Orphans without a canonical counterpart at 10, 11 and 14; a complete competition at 12.
+	seed := []struct {
+		number uint64
+		orphan bool
+	}{
+		{10, true}, {11, true}, {11, true}, {12, true}, {12, false}, {13, false}, {14, true},
+	}
+	for _, s := range seed {
+		h := generateMockHead()
+		h.Number = s.number
+		h.Orphan = s.orphan
+		if err := h.CreateOrUpdate(db, "orphan"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gaps, err := findGaps(db, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Gap{{From: 10, To: 11}, {From: 14, To: 14}}
+	if !reflect.DeepEqual(gaps, want) {
+		t.Fatalf("want gaps %v, got %v", want, gaps)
+	}
+
+	gaps, err = findGaps(db, 11, 13)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Gap{{From: 11, To: 11}}; !reflect.DeepEqual(gaps, want) {
+		t.Fatalf("want gaps %v, got %v", want, gaps)
+	}
+}
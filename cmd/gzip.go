@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gzipMinSize is the size of the responses below which they aren't compressed, since gzip wouldn't save a round trip.
+const gzipMinSize = 1400
+
+// acceptsGzip tells whether the request's Accept-Encoding allows gzip, ie. lists it without a zero quality value.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(enc, ";")
+		if strings.TrimSpace(params[0]) != "gzip" {
+			continue
+		}
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if q, err := strconv.ParseFloat(strings.TrimPrefix(p, "q="), 64); strings.HasPrefix(p, "q=") && err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter holds the response back until min bytes are written, then compresses it.
+// Smaller responses are written as they are when closed.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	min  int
+	code int
+	buf  []byte
+	gz   *gzip.Writer
+	// done is set once the headers are written, compressed (gz) or not.
+	done bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if w.done {
+		if w.gz != nil {
+			return w.gz.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.min {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// start writes the headers and the held back bytes, compressed or not.
+func (w *gzipResponseWriter) start(compress bool) error {
+	w.done = true
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" {
+		compress = false
+	}
+	if compress {
+		// The content type would otherwise be sniffed from the compressed bytes.
+		if h.Get("Content-Type") == "" {
+			h.Set("Content-Type", http.DetectContentType(w.buf))
+		}
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+	}
+	if w.code != 0 {
+		w.ResponseWriter.WriteHeader(w.code)
+	}
+	if compress {
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+		_, err := w.gz.Write(w.buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(w.buf)
+	return err
+}
+
+// Flush writes out what was written so far, compressing it if it is large enough.
+func (w *gzipResponseWriter) Flush() {
+	if !w.done {
+		w.start(len(w.buf) >= w.min)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close writes out the response, uncompressed if it was smaller than min.
+func (w *gzipResponseWriter) Close() error {
+	if !w.done {
+		return w.start(false)
+	}
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
+
+// gzipHandler compresses the responses of the /api/ endpoints of at least gzipMinSize bytes with gzip,
+// for the clients accepting it. The other endpoints (eg. the WebSocket feed) are served as they are.
+func gzipHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w, min: gzipMinSize}
+		defer gw.Close()
+		h.ServeHTTP(gw, r)
+	})
+}
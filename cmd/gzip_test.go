@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGzipHandler(t *testing.T) {
+	large := strings.Repeat(`{"hash": "0x00"}`, 1000)
+	h := gzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("size") == "large" {
+			// Written in pieces, as the exports do.
+			for i := 0; i < len(large); i += 100 {
+				w.Write([]byte(large[i : i+100]))
+			}
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "header not found"}`))
+	}))
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/api/headers?size=large", "deflate, gzip")
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("want gzipped JSON, got headers %v", rec.Header())
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != large {
+		t.Errorf("want the body decompressed intact, got %d bytes", len(body))
+	}
+
+	// Small responses are sent as they are, with their status.
+	rec = get("/api/header/0x00?size=small", "gzip")
+	if rec.Header().Get("Content-Encoding") != "" || rec.Code != http.StatusNotFound || rec.Body.String() != `{"error": "header not found"}` {
+		t.Errorf("want a small response uncompressed, got %d %v %q", rec.Code, rec.Header(), rec.Body.String())
+	}
+
+	for path, enc := range map[string]string{
+		"/api/headers?size=large": "gzip;q=0, identity",
+		"/status?size=large":      "gzip",
+	} {
+		if rec := get(path, enc); rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != large {
+			t.Errorf("%s with %q: want the response uncompressed, got %v", path, enc, rec.Header())
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// HashList is a list of hashes, stored as a JSON array in a text column.
+// An empty list is stored as an empty string.
+type HashList []string
+
+// GormDataType stores the list in a text column, whatever the database.
+func (HashList) GormDataType() string {
+	return "string"
+}
+
+func (l HashList) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal([]string(l))
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (l *HashList) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return fmt.Errorf("cannot scan %T into HashList", src)
+	}
+	if len(b) == 0 {
+		*l = nil
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(l))
+}
+
+// migrateUncleLists copies the uncles of the uncle1 and uncle2 columns, from before the uncles column,
+// into the uncles column of the headers which don't have it filled yet.
+// The old columns are left in place. It is a no-op for databases without them.
+func migrateUncleLists(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&Header{}, "uncle1") {
+		return nil
+	}
+	rows := []struct {
+		Hash, Uncle1, Uncle2 string
+	}{}
+	err := db.Table("headers").
+		Select("hash", "uncle1", "uncle2").
+		Where("COALESCE(uncle1, '') != '' OR COALESCE(uncle2, '') != ''").
+		Where("COALESCE(uncles, '') = ''").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		uncles := HashList{}
+		for _, u := range []string{r.Uncle1, r.Uncle2} {
+			if u != "" {
+				uncles = append(uncles, u)
+			}
+		}
+		if err := db.Model(&Header{}).Where("hash = ?", r.Hash).Update("uncles", uncles).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
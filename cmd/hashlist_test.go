@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestHandleHeaderRecordsAllUncles(t *testing.T) {
+	db := newTestDB(t)
+	client := newMockChainReader()
+
+	// More uncles than the Ethereum protocol allows, as another chain might.
+	uncles := []*types.Header{}
+	for n := uint64(97); n <= 99; n++ {
+		u := generateMockBlock(n, common.HexToAddress(randomHex(20)))
+		client.addBlock(u, false)
+		uncles = append(uncles, u.Header())
+	}
+	citer := generateMockBlock(100, common.HexToAddress(randomHex(20))).WithBody(nil, uncles)
+	client.addBlock(citer, true)
+
+	if _, err := handleHeader(client, db, citer.Header(), false, "", SourceHead); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := storedHeader(db, citer.Hash().Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Uncles) != len(uncles) {
+		t.Fatalf("want %d uncles, got %v", len(uncles), stored.Uncles)
+	}
+	for i, u := range uncles {
+		if stored.Uncles[i] != u.Hash().Hex() {
+			t.Errorf("want uncle %d %s, got %s", i, u.Hash().Hex(), stored.Uncles[i])
+		}
+		uncle, err := storedHeader(db, u.Hash().Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uncle == nil || !uncle.Orphan || uncle.UncleBy != citer.Hash().Hex() {
+			t.Errorf("want uncle %s stored as an orphan cited by %s, got %+v", u.Hash().Hex(), citer.Hash().Hex(), uncle)
+		}
+	}
+}
+
+func TestMigrateUncleLists(t *testing.T) {
+	db := newTestDB(t)
+
+	// A database from before the uncles column.
+	for _, col := range []string{"uncle1", "uncle2"} {
+		if err := db.Exec("ALTER TABLE headers ADD COLUMN " + col + " TEXT").Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	citer, other := generateMockHead(), generateMockHead()
+	for _, h := range []*Header{citer, other} {
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	u1, u2 := randomHex(32), randomHex(32)
+	if err := db.Exec("UPDATE headers SET uncle1 = ?, uncle2 = ? WHERE hash = ?", u1, u2, citer.Hash).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateUncleLists(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		hash string
+		want HashList
+	}{{citer.Hash, HashList{u1, u2}}, {other.Hash, nil}} {
+		stored, err := storedHeader(db, c.hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stored.Uncles) != len(c.want) || (len(c.want) > 0 && (stored.Uncles[0] != c.want[0] || stored.Uncles[1] != c.want[1])) {
+			t.Errorf("want uncles %v, got %v", c.want, stored.Uncles)
+		}
+	}
+}
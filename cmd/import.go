@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+var importFile string
+
+// importCmd bulk-imports headers into the database, without an RPC connection.
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import headers into the database from a JSON file",
+	Long: `Import headers (with their transactions) into the database from a JSON array of headers,
+as served by /api/headers.
+
+Once all headers are loaded, uncle citations are resolved from the citing blocks' uncle fields,
+so that the uncles' uncleBy relations are correct regardless of the order of the records.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dial, err := dialector(dbDriver, dbPath, dbDSN)
+		if err != nil {
+			logError("Invalid database configuration", "err", err)
+			os.Exit(1)
+		}
+		db, err := gorm.Open(dial, &gorm.Config{})
+		if err != nil {
+			logError("Database open failed", "err", err)
+			os.Exit(1)
+		}
+		if err := migrateSchema(db); err != nil {
+			logError("Schema migration failed", "err", err)
+			os.Exit(1)
+		}
+
+		var in io.Reader = os.Stdin
+		if importFile != "-" {
+			f, err := os.Open(importFile)
+			if err != nil {
+				logError("Import file open failed", "file", importFile, "err", err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			in = f
+		}
+		headers := []*Header{}
+		if err := json.NewDecoder(in).Decode(&headers); err != nil {
+			logError("Import decoding failed", "file", importFile, "err", err)
+			os.Exit(1)
+		}
+
+		if err := importHeaders(db, headers); err != nil {
+			logError("Import failed", "err", err)
+			os.Exit(1)
+		}
+		logInfo("Imported headers", "headers", len(headers))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+
+	importCmd.Flags().StringVar(&dbDriver, "db.driver", dbDriverSQLite, "Database driver: sqlite (at --db.path) or postgres (at --db.dsn)")
+	importCmd.Flags().StringVar(&dbPath, "db.path", "", "Path to database file, eg. /path/to/db.sqlite")
+	importCmd.Flags().StringVar(&dbDSN, "db.dsn", "", "Postgres connection string, eg. \"host=localhost user=tracker dbname=orphans sslmode=disable\"")
+	importCmd.Flags().StringVar(&importFile, "file", "-", "Path to the JSON file to import; - for stdin")
+}
+
+// importHeaders stores the headers, then resolves the uncle citations among all stored headers
+// and updates the canonical heads of the imported heights.
+// Nonces are stored in the format of nonceString, if they are given as decimal integers.
+func importHeaders(db *gorm.DB, headers []*Header) error {
+	for _, h := range headers {
+		if nonce, ok := canonicalNonce(h.Nonce); ok {
+			h.Nonce = nonce
+		}
+		if err := h.CreateOrUpdate(db, "orphan"); err != nil {
+			return err
+		}
+	}
+	if _, err := repairUncleRelations(db); err != nil {
+		return err
+	}
+	for _, h := range headers {
+		if err := syncCanonicalHead(db, h.Number); err != nil {
+			return err
+		}
+	}
+	return nil
+}
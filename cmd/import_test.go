@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestImportResolvesUncleCitations(t *testing.T) {
+	db := newTestDB(t)
+
+	uncle1, uncle2 := generateMockHead(), generateMockHead()
+	uncle1.Orphan, uncle2.Orphan = true, true
+	citer := generateMockHead()
+	citer.Uncles = HashList{uncle1.Hash, uncle2.Hash}
+	unrelated := generateMockHead()
+	unrelated.Orphan = true
+
+	// The citing block comes first, before its uncles are stored.
+	if err := importHeaders(db, []*Header{citer, uncle1, unrelated, uncle2}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, u := range []*Header{uncle1, uncle2} {
+		stored := &Header{}
+		if err := db.Where("hash = ?", u.Hash).First(stored).Error; err != nil {
+			t.Fatal(err)
+		}
+		if stored.UncleBy != citer.Hash {
+			t.Errorf("want uncle %s cited by %s, got %q", u.Hash, citer.Hash, stored.UncleBy)
+		}
+	}
+	stored := &Header{}
+	if err := db.Where("hash = ?", unrelated.Hash).First(stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.UncleBy != "" {
+		t.Errorf("want the unrelated orphan uncited, got %q", stored.UncleBy)
+	}
+}
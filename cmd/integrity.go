@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// These are the kinds of the anomalies table.
+const (
+	AnomalyHashHeight        = "hash-height"
+	AnomalyInclusionDistance = "inclusion-distance"
+)
+
+// Anomaly is a stored record of an inconsistency detected in the node's reports, served by /api/anomalies.
+// Hash and Number are those of the block concerned, as reported; Detail describes the anomaly.
+type Anomaly struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	Kind      string    `gorm:"index" json:"kind"`
+	Hash      string    `gorm:"index" json:"hash"`
+	Number    uint64    `gorm:"index" json:"number"`
+	Detail    string    `json:"detail"`
+}
+
+// recordAnomaly stores the anomaly.
+func recordAnomaly(db *gorm.DB, kind, hash string, number uint64, detail string) error {
+	return db.Create(&Anomaly{Kind: kind, Hash: hash, Number: number, Detail: detail}).Error
+}
+
+// HashHeightAnomaly is a block hash reported at a different number than the one stored for it.
+// Since hashes commit to numbers, this can only come from a node bug or a malformed feed.
+type HashHeightAnomaly struct {
+	Hash           string `json:"hash"`
+	StoredNumber   uint64 `json:"storedNumber"`
+	ReportedNumber uint64 `json:"reportedNumber"`
+}
+
+func (a *HashHeightAnomaly) Error() string {
+	return fmt.Sprintf("hash-height anomaly: %s stored at %d, reported at %d", a.Hash, a.StoredNumber, a.ReportedNumber)
+}
+
+// checkHashHeight compares the header's number against the number stored for its hash, if any.
+// It returns an anomaly if they differ; the upsert would otherwise hide the discrepancy,
+// since it never updates the number of an existing row.
+func checkHashHeight(db *gorm.DB, header *Header) (*HashHeightAnomaly, error) {
+	numbers := []uint64{}
+	err := db.Model(&Header{}).
+		Where("hash = ?", header.Hash).
+		Limit(1).
+		Pluck("number", &numbers).Error
+	if err != nil || len(numbers) == 0 || numbers[0] == header.Number {
+		return nil, err
+	}
+	return &HashHeightAnomaly{Hash: header.Hash, StoredNumber: numbers[0], ReportedNumber: header.Number}, nil
+}
+
+// anomaliesHandler serves the stored anomalies, newest first, optionally of a kind and in a number range.
+func anomaliesHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		min, max, err := numberRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		anomalies := []Anomaly{}
+		res := db.Model(&Anomaly{}).
+			Where("number >= ? AND number <= ?", min, max).
+			Order("id DESC")
+		if q := r.URL.Query().Get("kind"); q != "" {
+			res = res.Where("kind = ?", q)
+		}
+		if err := paginate(r, res, apiDefaultLimitHeaders).Find(&anomalies).Error; err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, anomalies)
+	}
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestCheckHashHeight(t *testing.T) {
+	db := newTestDB(t)
+
+	stored := generateMockHead()
+	stored.Number = 100
+	if err := stored.CreateOrUpdate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	same := *stored
+	if a, err := checkHashHeight(db, &same); err != nil || a != nil {
+		t.Fatalf("want no anomaly for the stored number, got %v, %v", a, err)
+	}
+	if a, err := checkHashHeight(db, generateMockHead()); err != nil || a != nil {
+		t.Fatalf("want no anomaly for an unknown hash, got %v, %v", a, err)
+	}
+
+	conflict := *stored
+	conflict.Number = 101
+	a, err := checkHashHeight(db, &conflict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := HashHeightAnomaly{Hash: stored.Hash, StoredNumber: 100, ReportedNumber: 101}
+	if a == nil || *a != want {
+		t.Fatalf("want %+v, got %+v", want, a)
+	}
+}
+
+func TestHandleHeaderRecordsHashHeightAnomaly(t *testing.T) {
+	db := newTestDB(t)
+	client := newMockChainReader()
+	bl := generateMockBlock(100, common.HexToAddress(randomHex(20)))
+	client.addBlock(bl, true)
+
+	stored := appHeader(bl.Header())
+	stored.Number = 99
+	if err := stored.CreateOrUpdate(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleHeader(client, db, bl.Header(), false, "", SourceHead); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	anomaliesHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/anomalies?kind=hash-height&number_min=100", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	anomalies := []Anomaly{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &anomalies); err != nil {
+		t.Fatal(err)
+	}
+	if len(anomalies) != 1 || anomalies[0].Hash != bl.Hash().Hex() || anomalies[0].Number != 100 || anomalies[0].Detail == "" {
+		t.Fatalf("want the anomaly of %s at 100 recorded, got %+v", bl.Hash().Hex(), anomalies)
+	}
+}
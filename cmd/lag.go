@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"gorm.io/gorm"
+)
+
+// lagTipTTL is how long the node's tip is cached for /api/lag.
+const lagTipTTL = 5 * time.Second
+
+// headerReader is the subset of the ethclient.Client API used to query the node's tip.
+type headerReader interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// tipCache caches the node's tip briefly, so that polling /api/lag doesn't hammer the node.
+type tipCache struct {
+	mu     sync.Mutex
+	client headerReader
+	ttl    time.Duration
+	at     time.Time
+	number uint64
+}
+
+// Tip returns the number of the node's latest header, queried at most once per ttl.
+func (c *tipCache) Tip(now time.Time) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.at.IsZero() && now.Sub(c.at) < c.ttl {
+		return c.number, nil
+	}
+	ctx, cancel := rpcContext()
+	defer cancel()
+	h, err := c.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	c.number, c.at = h.Number.Uint64(), now
+	return c.number, nil
+}
+
+// lagQueues are the subscription and trailer channels, by name, whose depths are reported by /api/lag.
+// lagTip is the node's tip. Both are set once the tracker is running.
+var (
+	lagQueues map[string]chan *types.Header
+	lagTip    *tipCache
+)
+
+// Lag quantifies how far behind real-time the tracker is.
+type Lag struct {
+	// Queues are the numbers of events waiting in each channel.
+	Queues map[string]int `json:"queues"`
+
+	NodeTip       uint64 `json:"nodeTip"`
+	LastPersisted uint64 `json:"lastPersisted"`
+	// Behind is the number of blocks between the node's tip and the highest stored block.
+	Behind uint64 `json:"behind"`
+}
+
+// computeLag computes the lag against the node's tip.
+func computeLag(db *gorm.DB, tip uint64, queues map[string]chan *types.Header) (*Lag, error) {
+	lag := &Lag{Queues: map[string]int{}, NodeTip: tip}
+	for name, ch := range queues {
+		lag.Queues[name] = len(ch)
+	}
+	err := db.Model(&Header{}).Select("COALESCE(MAX(number), 0)").Scan(&lag.LastPersisted).Error
+	if err != nil {
+		return nil, err
+	}
+	if tip > lag.LastPersisted {
+		lag.Behind = tip - lag.LastPersisted
+	}
+	return lag, nil
+}
+
+func lagHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if lagTip == nil {
+			http.Error(w, "not running", http.StatusServiceUnavailable)
+			return
+		}
+		tip, err := lagTip.Tip(time.Now())
+		if err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		lag, err := computeLag(db, tip, lagQueues)
+		if err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, lag)
+	}
+}
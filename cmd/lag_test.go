@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+type mockHeaderReader struct {
+	number uint64
+	calls  int
+}
+
+func (m *mockHeaderReader) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	m.calls++
+	return &types.Header{Number: new(big.Int).SetUint64(m.number)}, nil
+}
+
+func TestComputeLag(t *testing.T) {
+	db := newTestDB(t)
+	for _, n := range []uint64{90, 95} {
+		h := generateMockHead()
+		h.Number = n
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	node := &mockHeaderReader{number: 100}
+	tips := &tipCache{client: node, ttl: time.Minute}
+	now := time.Now()
+	tip, err := tips.Tip(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	side, head := make(chan *types.Header, 10), make(chan *types.Header, 10)
+	side <- &types.Header{}
+	side <- &types.Header{}
+	lag, err := computeLag(db, tip, map[string]chan *types.Header{"side": side, "head": head})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lag.NodeTip != 100 || lag.LastPersisted != 95 || lag.Behind != 5 {
+		t.Fatalf("want 5 blocks behind 100 at 95, got %+v", lag)
+	}
+	if lag.Queues["side"] != 2 || lag.Queues["head"] != 0 {
+		t.Fatalf("want queue depths side=2 head=0, got %v", lag.Queues)
+	}
+
+	// The tip is cached.
+	node.number = 101
+	if tip, _ := tips.Tip(now.Add(time.Second)); tip != 100 || node.calls != 1 {
+		t.Fatalf("want the cached tip, got %d after %d calls", tip, node.calls)
+	}
+	if tip, _ := tips.Tip(now.Add(2 * time.Minute)); tip != 101 {
+		t.Fatalf("want the tip refreshed after the ttl, got %d", tip)
+	}
+}
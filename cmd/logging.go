@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var logLevel string
+var logFormat string
+
+// logLvl is the severity of a log line; lines below the configured level are dropped.
+type logLvl int
+
+const (
+	levelDebug logLvl = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLvlNames = map[logLvl]string{
+	levelDebug: "debug",
+	levelInfo:  "info",
+	levelWarn:  "warn",
+	levelError: "error",
+}
+
+func (l logLvl) String() string {
+	return logLvlNames[l]
+}
+
+// parseLogLvl parses a --log.level value.
+func parseLogLvl(s string) (logLvl, error) {
+	for l, name := range logLvlNames {
+		if strings.EqualFold(s, name) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid --log.level value: %s", s)
+}
+
+// These are the accepted values for the --log.format flag.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// leveledLogger writes log lines of a minimum level, each with a message and key-value fields,
+// either as text (`2006/01/02 15:04:05 INFO New head number=1 hash=0x...`) or as a JSON object per line.
+// It is a stand-in for log/slog, which requires a newer Go version than this module targets.
+type leveledLogger struct {
+	mu   sync.Mutex
+	out  io.Writer
+	min  logLvl
+	json bool
+	now  func() time.Time
+}
+
+// appLogger is the program's logger; it logs at info level and above, as text, until configured by setupLogging.
+var appLogger = &leveledLogger{out: os.Stderr, min: levelInfo, now: time.Now}
+
+// setupLogging configures the logger from the --log.level and --log.format values.
+func setupLogging(level, format string) error {
+	min, err := parseLogLvl(level)
+	if err != nil {
+		return err
+	}
+	if format != logFormatText && format != logFormatJSON {
+		return fmt.Errorf("invalid --log.format value: %s", format)
+	}
+	appLogger.mu.Lock()
+	defer appLogger.mu.Unlock()
+	appLogger.min = min
+	appLogger.json = format == logFormatJSON
+	return nil
+}
+
+// logValue returns the value as written in the log: errors and Stringers as their strings.
+func logValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
+
+// log writes the message with the fields, given as alternating keys and values.
+func (l *leveledLogger) log(lvl logLvl, msg string, kv ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lvl < l.min {
+		return
+	}
+	if len(kv)%2 == 1 {
+		kv = append(kv, nil)
+	}
+
+	var b strings.Builder
+	now := l.now()
+	if l.json {
+		b.WriteString(`{"time":`)
+		writeJSONValue(&b, now.Format(time.RFC3339Nano))
+		b.WriteString(`,"level":`)
+		writeJSONValue(&b, lvl.String())
+		b.WriteString(`,"msg":`)
+		writeJSONValue(&b, msg)
+		for i := 0; i < len(kv); i += 2 {
+			b.WriteByte(',')
+			writeJSONValue(&b, fmt.Sprint(kv[i]))
+			b.WriteByte(':')
+			writeJSONValue(&b, logValue(kv[i+1]))
+		}
+		b.WriteString("}\n")
+	} else {
+		b.WriteString(now.Format("2006/01/02 15:04:05 "))
+		b.WriteString(strings.ToUpper(lvl.String()))
+		b.WriteByte(' ')
+		b.WriteString(msg)
+		for i := 0; i < len(kv); i += 2 {
+			s := fmt.Sprint(logValue(kv[i+1]))
+			if s == "" || strings.ContainsAny(s, " =\"\n") {
+				s = strconv.Quote(s)
+			}
+			fmt.Fprintf(&b, " %v=%s", kv[i], s)
+		}
+		b.WriteByte('\n')
+	}
+	io.WriteString(l.out, b.String())
+}
+
+// writeJSONValue writes the value JSON-encoded, or as a JSON string if it can't be encoded.
+func writeJSONValue(b *strings.Builder, v interface{}) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		j, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(j)
+}
+
+func logDebug(msg string, kv ...interface{}) { appLogger.log(levelDebug, msg, kv...) }
+func logInfo(msg string, kv ...interface{})  { appLogger.log(levelInfo, msg, kv...) }
+func logWarn(msg string, kv ...interface{})  { appLogger.log(levelWarn, msg, kv...) }
+func logError(msg string, kv ...interface{}) { appLogger.log(levelError, msg, kv...) }
+
+// headerFields are the fields identifying a header in the log.
+func headerFields(h *Header) []interface{} {
+	return []interface{}{"number", h.Number, "hash", h.Hash, "orphan", h.Orphan}
+}
+
+// withFields appends the fields to the header's fields.
+func withFields(h *Header, kv ...interface{}) []interface{} {
+	return append(headerFields(h), kv...)
+}
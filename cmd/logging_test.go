@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLogger(min logLvl, asJSON bool) (*leveledLogger, *bytes.Buffer) {
+	buf := &bytes.Buffer{}
+	now := time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+	return &leveledLogger{out: buf, min: min, json: asJSON, now: func() time.Time { return now }}, buf
+}
+
+func TestLeveledLoggerText(t *testing.T) {
+	l, buf := newTestLogger(levelInfo, false)
+	h := generateMockHead()
+	h.Number = 42
+
+	l.log(levelDebug, "Skipped")
+	l.log(levelInfo, "New head", headerFields(h)...)
+	l.log(levelError, "Failed", "err", errors.New("connection refused"))
+
+	want := "2022/07/01 12:00:00 INFO New head number=42 hash=" + h.Hash + " orphan=false\n" +
+		"2022/07/01 12:00:00 ERROR Failed err=\"connection refused\"\n"
+	if buf.String() != want {
+		t.Errorf("want\n%s\ngot\n%s", want, buf.String())
+	}
+}
+
+func TestLeveledLoggerJSON(t *testing.T) {
+	l, buf := newTestLogger(levelWarn, true)
+	h := generateMockHead()
+	h.Number, h.Orphan = 42, true
+
+	l.log(levelInfo, "New head", headerFields(h)...)
+	l.log(levelWarn, "New side head", withFields(h, "err", errors.New("block: not found"))...)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("want only the warning logged, got %q", buf.String())
+	}
+	got := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"time": "2022-07-01T12:00:00Z", "level": "warn", "msg": "New side head",
+		"number": float64(42), "hash": h.Hash, "orphan": true, "err": "block: not found",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("want %s %v, got %v", k, v, got[k])
+		}
+	}
+}
+
+func TestSetupLogging(t *testing.T) {
+	defer setupLogging("info", logFormatText)
+
+	if err := setupLogging("WARN", logFormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	if appLogger.min != levelWarn || !appLogger.json {
+		t.Errorf("want warn level as JSON, got %v %v", appLogger.min, appLogger.json)
+	}
+	if err := setupLogging("verbose", logFormatText); err == nil {
+		t.Error("want an invalid level rejected")
+	}
+	if err := setupLogging("info", "xml"); err == nil {
+		t.Error("want an invalid format rejected")
+	}
+}
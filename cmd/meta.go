@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"strconv"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var resumeWindow uint64
+
+// metaKeyCursor is the Meta key of the number of the last processed canonical head.
+const metaKeyCursor = "cursor"
+
+// metaKeyNoncesMigrated is the Meta key set once the stored nonces are migrated by migrateNonces.
+const metaKeyNoncesMigrated = "nonces_migrated"
+
+// metaKeyUncleCitationsMigrated is the Meta key set once the uncleBy relations are migrated by migrateUncleCitations.
+const metaKeyUncleCitationsMigrated = "uncle_citations_migrated"
+
+// Meta is a key-value store for the tracker's own state, eg. where it left off.
+type Meta struct {
+	Key   string `gorm:"primaryKey"`
+	Value string `gorm:"not null"`
+}
+
+// setMeta records the value of the key.
+func setMeta(db *gorm.DB, key, value string) error {
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value"}),
+	}).Create(&Meta{Key: key, Value: value}).Error
+}
+
+// metaValue returns the value of the key, and false if there is none.
+func metaValue(db *gorm.DB, key string) (string, bool, error) {
+	metas := []Meta{}
+	if err := db.Where("key = ?", key).Limit(1).Find(&metas).Error; err != nil {
+		return "", false, err
+	}
+	if len(metas) == 0 {
+		return "", false, nil
+	}
+	return metas[0].Value, true, nil
+}
+
+// setCursor records the number of the last processed canonical head.
+func setCursor(db *gorm.DB, number uint64) error {
+	return setMeta(db, metaKeyCursor, strconv.FormatUint(number, 10))
+}
+
+// cursor returns the number of the last processed canonical head, and false if there is none.
+func cursor(db *gorm.DB) (uint64, bool, error) {
+	v, ok, err := metaValue(db, metaKeyCursor)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return n, true, nil
+}
+
+// resumeFrom returns the first height missed since the last processed canonical head,
+// and false if there is nothing to resume: no cursor, no missed heights,
+// or more than window missed heights (which are logged, to be backfilled explicitly with --backfill.from).
+func resumeFrom(db *gorm.DB, head, window uint64) (uint64, bool, error) {
+	last, ok, err := cursor(db)
+	if err != nil || !ok || last >= head {
+		return 0, false, err
+	}
+	if head-last > window {
+		logWarn("Not resuming: more blocks missed than --resume.window", "missed", head-last, "since", last, "window", window)
+		return 0, false, nil
+	}
+	return last + 1, true, nil
+}
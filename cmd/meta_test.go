@@ -0,0 +1,30 @@
+package cmd
+
+import "testing"
+
+func TestResumeFrom(t *testing.T) {
+	db := newTestDB(t)
+
+	if _, ok, err := resumeFrom(db, 1000, 100); err != nil || ok {
+		t.Fatalf("want nothing to resume without a cursor, got ok=%v err=%v", ok, err)
+	}
+
+	for _, n := range []uint64{950, 960} {
+		if err := setCursor(db, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, ok, err := cursor(db); err != nil || !ok || n != 960 {
+		t.Fatalf("want cursor 960, got %d ok=%v err=%v", n, ok, err)
+	}
+
+	if from, ok, err := resumeFrom(db, 1000, 100); err != nil || !ok || from != 961 {
+		t.Errorf("want to resume from 961, got %d ok=%v err=%v", from, ok, err)
+	}
+	if _, ok, err := resumeFrom(db, 960, 100); err != nil || ok {
+		t.Errorf("want nothing to resume at the cursor, got ok=%v err=%v", ok, err)
+	}
+	if _, ok, err := resumeFrom(db, 1100, 100); err != nil || ok {
+		t.Errorf("want no resume beyond the window, got ok=%v err=%v", ok, err)
+	}
+}
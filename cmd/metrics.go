@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+var pushgatewayURL string
+var pushgatewayJob string
+var pushgatewayInterval time.Duration
+
+// metricsRegistry holds the program's metrics.
+// It is the single source of metric definitions, for every way metrics are exported.
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	metricHeadersStored = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "orphan_tracker",
+		Name:      "headers_stored_total",
+		Help:      "Number of headers stored (created or updated).",
+	})
+	metricOrphansStored = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "orphan_tracker",
+		Name:      "orphans_stored_total",
+		Help:      "Number of orphan headers stored (created or updated).",
+	})
+	metricHashHeightAnomalies = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "orphan_tracker",
+		Name:      "hash_height_anomalies_total",
+		Help:      "Number of block hashes reported at a different number than the one stored for them.",
+	})
+	metricUnclesStored = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "orphan_tracker",
+		Name:      "uncles_stored_total",
+		Help:      "Number of uncle headers stored (created or updated) with the block citing them.",
+	})
+	metricSideHeadsReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "orphan_tracker",
+		Name:      "side_heads_received_total",
+		Help:      "Number of side heads received from the node's subscription.",
+	})
+	metricCanonicalHeadsReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "orphan_tracker",
+		Name:      "canonical_heads_received_total",
+		Help:      "Number of canonical heads received from the node's subscription.",
+	})
+	metricDBWriteErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "orphan_tracker",
+		Name:      "db_write_errors_total",
+		Help:      "Number of failed writes of headers to the database.",
+	})
+	metricSubscriptionReconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "orphan_tracker",
+		Name:      "subscription_reconnects_total",
+		Help:      "Number of node subscriptions re-established after a connection error.",
+	})
+	metricLatestHead = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "orphan_tracker",
+		Name:      "latest_head_number",
+		Help:      "Number of the latest head reported by the node.",
+	})
+	// metricUptime is computed when collected, from the HTTP server's start time.
+	metricUptime = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "orphan_tracker",
+		Name:      "uptime_seconds",
+		Help:      "Number of seconds since the server started.",
+	}, func() float64 {
+		started := status.StartedAt()
+		if started.IsZero() {
+			return 0
+		}
+		return time.Since(started).Seconds()
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		metricHeadersStored, metricOrphansStored, metricUnclesStored, metricHashHeightAnomalies,
+		metricSideHeadsReceived, metricCanonicalHeadsReceived, metricDBWriteErrors, metricSubscriptionReconnects,
+		metricLatestHead, metricUptime, channelCollector{},
+	)
+}
+
+// metricsHandler serves the registry's metrics for Prometheus to scrape.
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// newPusher returns a Pushgateway pusher of the registry's metrics, grouped under the job.
+func newPusher(url, job string) *push.Pusher {
+	return push.New(url, job).Gatherer(metricsRegistry)
+}
+
+// pushMetrics pushes the metrics, replacing those previously pushed for the job.
+// Failures are logged, not returned; metrics are not worth interrupting the tracker for.
+func pushMetrics(p *push.Pusher) {
+	if err := p.Push(); err != nil {
+		logWarn("Pushgateway push failed", "err", err)
+	}
+}
+
+// runMetricsPusher pushes the metrics at every interval. It never returns.
+func runMetricsPusher(p *push.Pusher, interval time.Duration) {
+	for range time.Tick(interval) {
+		pushMetrics(p)
+	}
+}
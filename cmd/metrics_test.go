@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPushMetrics(t *testing.T) {
+	var path, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	metricLatestHead.Set(12345)
+	pushMetrics(newPusher(srv.URL, "audit"))
+
+	if path != "/metrics/job/audit" {
+		t.Fatalf("want metrics pushed under the job, got path %q", path)
+	}
+	// The body is protobuf-encoded, but the metric names are readable.
+	if !strings.Contains(body, "orphan_tracker_latest_head_number") {
+		t.Fatal("want the registry's metrics pushed")
+	}
+
+	// Failures are survived.
+	srv.Close()
+	pushMetrics(newPusher(srv.URL, "audit"))
+}
+
+func TestMetricsHandler(t *testing.T) {
+	metricLatestHead.Set(12345)
+	metricSideHeadsReceived.Inc()
+
+	rec := httptest.NewRecorder()
+	metricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", rec.Code)
+	}
+	for _, name := range []string{
+		"orphan_tracker_latest_head_number 12345",
+		"orphan_tracker_side_heads_received_total",
+		"orphan_tracker_canonical_heads_received_total",
+		"orphan_tracker_uncles_stored_total",
+		"orphan_tracker_db_write_errors_total",
+		"orphan_tracker_subscription_reconnects_total",
+		"orphan_tracker_uptime_seconds",
+	} {
+		if !strings.Contains(rec.Body.String(), name) {
+			t.Errorf("want %q in the metrics, got:\n%s", name, rec.Body.String())
+		}
+	}
+}
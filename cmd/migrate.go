@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+// migrateCmd migrates the schema of a database, without tracking.
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Migrate the database to the current schema",
+	Long: `Create the missing tables, migrate the existing ones to the current schema, and migrate the data of older versions,
+logging every statement run.
+
+If an existing table can't be fully migrated (eg. some SQLite versions fail to alter constraints), its missing columns
+and indexes are added one by one, and the failures are listed; the tracker can then run, but the schema is incomplete.
+In that case, the command exits with status 2. It exits with status 1 if the migration failed.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dial, err := dialector(dbDriver, dbPath, dbDSN)
+		if err != nil {
+			logError("Invalid database configuration", "err", err)
+			os.Exit(1)
+		}
+		db, err := gorm.Open(dial, &gorm.Config{})
+		if err != nil {
+			logError("Database open failed", "err", err)
+			os.Exit(1)
+		}
+
+		warnings, err := migrateSchemaWarnings(db.Debug())
+		for _, w := range warnings {
+			logWarn("Schema migration incomplete", "err", w)
+		}
+		if err != nil {
+			logError("Schema migration failed", "err", err)
+			os.Exit(1)
+		}
+		if len(warnings) > 0 {
+			logError("Schema migration incomplete", "warnings", len(warnings))
+			os.Exit(2)
+		}
+		logInfo("Migrated schema")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+
+	migrateCmd.Flags().StringVar(&dbDriver, "db.driver", dbDriverSQLite, "Database driver: sqlite (at --db.path) or postgres (at --db.dsn)")
+	migrateCmd.Flags().StringVar(&dbPath, "db.path", "", "Path to database file, eg. /path/to/db.sqlite")
+	migrateCmd.Flags().StringVar(&dbDSN, "db.dsn", "", "Postgres connection string, eg. \"host=localhost user=tracker dbname=orphans sslmode=disable\"")
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// OrphanRun is a run of orphans by the same miner at contiguous heights, From to To inclusive.
+// Sustained runs hint at propagation issues on the miner's end.
+type OrphanRun struct {
+	Miner  string `json:"miner"`
+	From   uint64 `json:"from"`
+	To     uint64 `json:"to"`
+	Length uint64 `json:"length"`
+}
+
+// findConsecutiveOrphans returns the runs of at least minRun orphans by the same miner
+// at contiguous heights between min and max (inclusive),
+// ordered by length descending, then by height.
+func findConsecutiveOrphans(db *gorm.DB, min, max, minRun uint64) ([]OrphanRun, error) {
+	rows := []Header{}
+	err := db.Model(&Header{}).
+		Distinct("coinbase", "number").
+		Where("orphan = ?", true).
+		Where("number >= ? AND number <= ?", min, max).
+		Order("coinbase ASC, number ASC").
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	runs := []OrphanRun{}
+	var cur *OrphanRun
+	flush := func() {
+		if cur != nil && cur.Length >= minRun {
+			runs = append(runs, *cur)
+		}
+	}
+	for _, h := range rows {
+		if cur != nil && cur.Miner == h.Coinbase && cur.To+1 == h.Number {
+			cur.To = h.Number
+			cur.Length++
+			continue
+		}
+		flush()
+		cur = &OrphanRun{Miner: h.Coinbase, From: h.Number, To: h.Number, Length: 1}
+	}
+	flush()
+
+	sort.SliceStable(runs, func(i, j int) bool {
+		if runs[i].Length != runs[j].Length {
+			return runs[i].Length > runs[j].Length
+		}
+		return runs[i].From < runs[j].From
+	})
+	return runs, nil
+}
+
+// consecutiveOrphansHandler serves the runs of consecutive orphans by the same miner.
+// The minimum run length can be set with min_run (default 2).
+func consecutiveOrphansHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		min, max, err := numberRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		minRun := uint64(2)
+		if q := r.URL.Query().Get("min_run"); q != "" {
+			if minRun, err = strconv.ParseUint(q, 10, 64); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		runs, err := findConsecutiveOrphans(db, min, max, minRun)
+		if err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, runs)
+	}
+}
+
+// MinerStats is the number of headers stored for a miner (coinbase), and how many of them are orphans.
+type MinerStats struct {
+	Miner       string `json:"miner"`
+	Blocks      int64  `json:"blocks"`
+	OrphanCount int64  `json:"orphan_count"`
+	// UncledCount is the number of the miner's orphans cited as uncles (rewarded).
+	UncledCount int64 `json:"uncled_count"`
+	// OrphanRate is OrphanCount / Blocks.
+	OrphanRate float64 `json:"orphan_rate"`
+}
+
+// minerStats counts the headers and orphans of each miner with heights between min and max (inclusive),
+// by descending number of orphans, then blocks.
+func minerStats(db *gorm.DB, min, max uint64) ([]MinerStats, error) {
+	stats := []MinerStats{}
+	err := db.Model(&Header{}).
+		Select("coinbase AS miner, "+
+			"COUNT(*) AS blocks, "+
+			"SUM(CASE WHEN orphan THEN 1 ELSE 0 END) AS orphan_count, "+
+			"SUM(CASE WHEN orphan AND "+citedAsUncle+" THEN 1 ELSE 0 END) AS uncled_count").
+		Where("number >= ? AND number <= ?", min, max).
+		Group("coinbase").
+		Order("orphan_count DESC").
+		Order("blocks DESC").
+		Order("miner ASC").
+		Scan(&stats).Error
+	for i := range stats {
+		stats[i].OrphanRate = float64(stats[i].OrphanCount) / float64(stats[i].Blocks)
+	}
+	return stats, err
+}
+
+// minersHandler serves the leaderboard of miners by orphans, optionally in a number_min/number_max range.
+func minersHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		min, max, err := numberRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		stats, err := minerStats(db, min, max)
+		if err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, stats)
+	}
+}
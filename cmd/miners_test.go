@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFindConsecutiveOrphans(t *testing.T) {
+	db := newTestDB(t)
+
+	a, b := randomHex(20), randomHex(20)
+	store := func(number uint64, miner string, orphan bool) {
+		h := generateMockHead()
+		h.Number, h.Coinbase, h.Orphan = number, miner, orphan
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Miner a orphans across three consecutive heights, then once more after a break.
+	store(100, a, true)
+	store(101, a, true)
+	store(101, a, true) // A second orphan at the same height doesn't lengthen the run.
+	store(102, a, true)
+	store(104, a, true)
+	// Miner b orphans twice, but not consecutively; its canonical block doesn't count.
+	store(100, b, true)
+	store(101, b, false)
+	store(102, b, true)
+
+	runs, err := findConsecutiveOrphans(db, 0, 1000, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := OrphanRun{Miner: a, From: 100, To: 102, Length: 3}
+	if len(runs) != 1 || runs[0] != want {
+		t.Fatalf("want %v, got %v", want, runs)
+	}
+
+	if runs, _ := findConsecutiveOrphans(db, 0, 1000, 1); len(runs) != 4 {
+		t.Fatalf("want 4 runs of at least 1, got %v", runs)
+	}
+}
+
+func TestMinersHandler(t *testing.T) {
+	db := newTestDB(t)
+
+	const steady, unlucky = "0x1111111111111111111111111111111111111111", "0x2222222222222222222222222222222222222222"
+	for i, c := range []struct {
+		miner  string
+		orphan bool
+		uncled bool
+	}{
+		{steady, false, false},
+		{steady, false, false},
+		{steady, true, false},
+		{unlucky, true, true},
+		{unlucky, true, false},
+		{unlucky, false, false},
+		{unlucky, false, false},
+	} {
+		h := generateMockHead()
+		h.Number, h.Coinbase, h.Orphan = uint64(100+i), c.miner, c.orphan
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+		if c.uncled {
+			if err := recordUncleCitation(db, h.Hash, randomHex(32)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	query := func(q string) (int, []MinerStats) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		minersHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/miners?"+q, nil))
+		stats := []MinerStats{}
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, stats
+	}
+
+	_, stats := query("")
+	want := []MinerStats{
+		{Miner: unlucky, Blocks: 4, OrphanCount: 2, UncledCount: 1, OrphanRate: 0.5},
+		{Miner: steady, Blocks: 3, OrphanCount: 1, OrphanRate: 1.0 / 3},
+	}
+	if len(stats) != len(want) {
+		t.Fatalf("want %d miners, got %+v", len(want), stats)
+	}
+	for i := range want {
+		if stats[i] != want[i] {
+			t.Errorf("miner %d: want %+v, got %+v", i, want[i], stats[i])
+		}
+	}
+
+	// Only the first three blocks, all of the steady miner.
+	if _, stats := query("number_max=102"); len(stats) != 1 || stats[0].Miner != steady || stats[0].Blocks != 3 {
+		t.Errorf("want the steady miner's 3 blocks, got %+v", stats)
+	}
+
+	if code, _ := query("number_min=foo"); code != http.StatusBadRequest {
+		t.Errorf("want 400 for an invalid bound, got %d", code)
+	}
+}
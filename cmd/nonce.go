@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"gorm.io/gorm"
+)
+
+// nonceString returns the nonce as stored in Header.Nonce: 0x-prefixed hex of its 8 bytes, as types.BlockNonce marshals to JSON.
+func nonceString(n types.BlockNonce) string {
+	return hexutil.Encode(n[:])
+}
+
+// parseNonce parses a nonce in the format of nonceString.
+func parseNonce(s string) (types.BlockNonce, error) {
+	var n types.BlockNonce
+	err := n.UnmarshalText([]byte(s))
+	return n, err
+}
+
+// canonicalNonce returns the nonce in the format of nonceString, from that format (in any case) or a decimal integer,
+// as stored by older versions' tools. It returns false for other values.
+func canonicalNonce(s string) (string, bool) {
+	if n, err := parseNonce(s); err == nil {
+		return nonceString(n), true
+	}
+	if v, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return nonceString(types.EncodeNonce(v)), true
+	}
+	return "", false
+}
+
+// migrateNonces rewrites the stored nonces which are not 0x-prefixed hex (eg. imported as decimal integers)
+// in the format of nonceString. Nonces which can't be parsed are left as they are.
+// It is run once: the headers stored since are in that format (see appHeader and importHeaders).
+func migrateNonces(db *gorm.DB) error {
+	if _, done, err := metaValue(db, metaKeyNoncesMigrated); err != nil || done {
+		return err
+	}
+	rows := []struct {
+		Hash  string
+		Nonce string
+	}{}
+	err := db.Model(&Header{}).Unscoped().
+		Select("hash", "nonce").
+		Where("nonce != '' AND nonce NOT LIKE '0x%'").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		nonce, ok := canonicalNonce(r.Nonce)
+		if !ok {
+			continue
+		}
+		if err := db.Model(&Header{}).Unscoped().Where("hash = ?", r.Hash).UpdateColumn("nonce", nonce).Error; err != nil {
+			return err
+		}
+	}
+	return setMeta(db, metaKeyNoncesMigrated, "true")
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestNonceRoundTrip(t *testing.T) {
+	bl := generateMockBlock(100, common.HexToAddress(randomHex(20)))
+	header := bl.Header()
+	header.Nonce = types.EncodeNonce(0xa1b2c3d4e5f60718) // Above the range of int64.
+
+	b, err := json.Marshal(appHeader(header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h Header
+	if err := json.Unmarshal(b, &h); err != nil {
+		t.Fatal(err)
+	}
+	if h.Nonce != "0xa1b2c3d4e5f60718" {
+		t.Errorf("want nonce 0xa1b2c3d4e5f60718, got %s", h.Nonce)
+	}
+	got, err := parseNonce(h.Nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != header.Nonce || got.Uint64() != header.Nonce.Uint64() {
+		t.Errorf("want nonce %d, got %d", header.Nonce.Uint64(), got.Uint64())
+	}
+}
+
+func TestMigrateNonces(t *testing.T) {
+	db := newTestDB(t)
+	// newTestDB migrated the empty database, which marks the nonces as migrated.
+	if err := db.Delete(&Meta{}, "key = ?", metaKeyNoncesMigrated).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	nonces := map[string]string{
+		"11651590505119483672": "0xa1b2c3d4e5f60718",
+		"0":                    "0x0000000000000000",
+		"0x0000000000000042":   "0x0000000000000042",
+		"not a nonce":          "not a nonce",
+	}
+	hashes := map[string]string{}
+	for nonce := range nonces {
+		h := generateMockHead()
+		h.Nonce = nonce
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+		hashes[h.Hash] = nonce
+	}
+	if err := migrateNonces(db); err != nil {
+		t.Fatal(err)
+	}
+	for hash, nonce := range hashes {
+		stored, err := storedHeader(db, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Nonce != nonces[nonce] {
+			t.Errorf("nonce %q: want %s stored, got %s", nonce, nonces[nonce], stored.Nonce)
+		}
+	}
+
+	// Once migrated, the nonces aren't scanned again.
+	h := generateMockHead()
+	h.Nonce = "42"
+	if err := h.CreateOrUpdate(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateNonces(db); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := storedHeader(db, h.Hash); err != nil || stored.Nonce != "42" {
+		t.Errorf("want the nonce left as stored after the migration, got %+v (%v)", stored, err)
+	}
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// numericKeyWidth is the number of decimal digits of the largest 256-bit integer,
+// which bounds transaction values and gas prices in wei.
+const numericKeyWidth = 78
+
+// numericKey returns the decimal string zero-padded to numericKeyWidth digits, so that keys compare as strings
+// in the same order as the numbers do, in any database and beyond the range of int64.
+// It returns an empty string for empty, negative, non-decimal, or too wide values.
+func numericKey(s string) string {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.Sign() < 0 {
+		return ""
+	}
+	d := n.String()
+	if len(d) > numericKeyWidth {
+		return ""
+	}
+	return strings.Repeat("0", numericKeyWidth-len(d)) + d
+}
+
+// BeforeSave fills the transaction's numeric keys from its decimal fields, whichever way it is saved.
+func (t *Tx) BeforeSave(*gorm.DB) error {
+	t.ValueKey = numericKey(t.Value)
+	t.GasPriceKey = numericKey(t.GasPrice)
+	return nil
+}
+
+// migrateTxNumericKeys fills the numeric keys of the stored transactions which don't have them yet,
+// ie. those stored before the columns. It is idempotent.
+func migrateTxNumericKeys(db *gorm.DB) error {
+	rows := []struct {
+		Hash, Value, GasPrice string
+	}{}
+	err := db.Model(&Tx{}).
+		Select("hash", "value", "gas_price").
+		Where("(COALESCE(value, '') != '' AND COALESCE(value_key, '') = '') OR (COALESCE(gas_price, '') != '' AND COALESCE(gas_price_key, '') = '')").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		err := db.Model(&Tx{}).Where("hash = ?", r.Hash).UpdateColumns(map[string]interface{}{
+			"value_key":     numericKey(r.Value),
+			"gas_price_key": numericKey(r.GasPrice),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// numericKeyRange applies the <name>_min and <name>_max query parameters, decimal integers (inclusive),
+// to the numeric key column. Rows without a key (eg. values not stored) are excluded if either is given.
+func numericKeyRange(r *http.Request, res *gorm.DB, name, column string) (*gorm.DB, error) {
+	for _, bound := range []struct{ param, op string }{{name + "_min", ">="}, {name + "_max", "<="}} {
+		v := r.URL.Query().Get(bound.param)
+		if v == "" {
+			continue
+		}
+		key := numericKey(v)
+		if key == "" {
+			return nil, fmt.Errorf("invalid %s: %q", bound.param, v)
+		}
+		res = res.Where(column+" != '' AND "+column+" "+bound.op+" ?", key)
+	}
+	return res, nil
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNumericKey(t *testing.T) {
+	ordered := []string{"0", "9", "10", "999999999999999999", "9223372036854775808", "1000000000000000000000",
+		"115792089237316195423570985008687907853269984665640564039457584007913129639935"}
+	prev := ""
+	for _, v := range ordered {
+		key := numericKey(v)
+		if len(key) != numericKeyWidth {
+			t.Fatalf("want %d digits for %s, got %q", numericKeyWidth, v, key)
+		}
+		if key <= prev {
+			t.Errorf("want the key of %s after %s", v, prev)
+		}
+		prev = key
+	}
+	for _, v := range []string{"", "-1", "0x10", "1.5", strings.Repeat("9", numericKeyWidth+1)} {
+		if key := numericKey(v); key != "" {
+			t.Errorf("want no key for %q, got %q", v, key)
+		}
+	}
+}
+
+func TestTxesHandlerValueRange(t *testing.T) {
+	db := newTestDB(t)
+
+	// Values in wei: 1 wei, 1 ether, 100 ether (overflowing int64), and one not stored.
+	values := []string{"1", "1000000000000000000", "100000000000000000000", ""}
+	h := generateMockHead()
+	for _, v := range values {
+		tx := generateMockTx()
+		tx.Value = v
+		h.Txes = append(h.Txes, tx)
+	}
+	if err := h.CreateOrUpdate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(query string) (int, []Tx) {
+		rec := httptest.NewRecorder()
+		txesHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/txes?include_headers=false&"+query, nil))
+		txes := []Tx{}
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &txes); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, txes
+	}
+
+	if _, txes := get("value_min=1000000000000000000"); len(txes) != 2 {
+		t.Errorf("want 2 txes of at least 1 ether, got %d", len(txes))
+	}
+	if _, txes := get("value_max=1000000000000000000"); len(txes) != 2 {
+		t.Errorf("want 2 txes of at most 1 ether, got %d", len(txes))
+	}
+	if _, txes := get("value_min=2&value_max=99000000000000000000"); len(txes) != 1 || txes[0].Value != values[1] {
+		t.Errorf("want the 1 ether tx, got %+v", txes)
+	}
+	if _, txes := get(""); len(txes) != 4 {
+		t.Errorf("want all 4 txes unfiltered, got %d", len(txes))
+	}
+	if code, _ := get("value_min=1e18"); code != http.StatusBadRequest {
+		t.Errorf("want an invalid value_min rejected, got %d", code)
+	}
+}
+
+func TestMigrateTxNumericKeys(t *testing.T) {
+	db := newTestDB(t)
+
+	h := generateMockHead()
+	h.Txes = []Tx{generateMockTx()}
+	h.Txes[0].Value = "100000000000000000000"
+	if err := h.CreateOrUpdate(db); err != nil {
+		t.Fatal(err)
+	}
+	// As stored before the key columns.
+	if err := db.Model(&Tx{}).Where("1 = 1").UpdateColumns(map[string]interface{}{"value_key": "", "gas_price_key": ""}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateTxNumericKeys(db); err != nil {
+		t.Fatal(err)
+	}
+	got := Tx{}
+	if err := db.First(&got, "hash = ?", h.Txes[0].Hash).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got.ValueKey != numericKey(got.Value) || got.GasPriceKey != numericKey(got.GasPrice) {
+		t.Errorf("want the keys filled, got %q and %q", got.ValueKey, got.GasPriceKey)
+	}
+}
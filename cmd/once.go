@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"gorm.io/gorm"
+)
+
+var (
+	once       bool
+	onceBlocks uint64
+)
+
+// scanOnce is the single pass of --once: it recovers the orphans cited as uncles by the last n canonical blocks
+// up to the head (see backfill), then settles the orphan flags of the heights scanned, and of those of their uncles
+// (see auditTrailerHeight). The head is recorded as processed, so that the tracker resumes from it,
+// unless heights between the recorded cursor and the scan were missed: the gap is logged and the cursor kept,
+// for the tracker to resume from it (or for --backfill.from). It returns the number of uncles found.
+func scanOnce(client chainReader, db *gorm.DB, head, n uint64) (int, error) {
+	from := uint64(0)
+	if head+1 > n {
+		from = head + 1 - n
+	}
+	uncles, err := backfill(client, db, from, head)
+	if err != nil {
+		return uncles, err
+	}
+
+	// Heights without stored blocks are skipped by the audit.
+	audit := uint64(0)
+	if from > uncleWindow {
+		audit = from - uncleWindow
+	}
+	for number := audit; number <= head; number++ {
+		if err := auditTrailerHeight(client, db, number); err != nil {
+			return uncles, err
+		}
+	}
+	last, ok, err := cursor(db)
+	if err != nil {
+		return uncles, err
+	}
+	if ok && last+1 < from {
+		logWarn("Not recording the scan as processed: heights missed since the last processed head", "since", last, "from", from, "missed", from-last-1)
+		return uncles, nil
+	}
+	return uncles, setCursor(db, head)
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestScanOnce(t *testing.T) {
+	db := newTestDB(t)
+	defer func(w uint64) { uncleWindow = w }(uncleWindow)
+	uncleWindow = 6
+
+	client := newMockChainReader()
+	miner := common.HexToAddress(randomHex(20))
+	inWindow, outOfWindow := generateMockBlock(13, miner), generateMockBlock(4, miner)
+	for n := uint64(1); n <= 20; n++ {
+		bl := generateMockBlock(n, miner)
+		switch n {
+		case 15:
+			bl = bl.WithBody(nil, []*types.Header{inWindow.Header()})
+		case 5:
+			bl = bl.WithBody(nil, []*types.Header{outOfWindow.Header()})
+		}
+		client.addBlock(bl, true)
+	}
+
+	uncles, err := scanOnce(client, db, 20, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uncles != 1 {
+		t.Errorf("want 1 uncle found in the last 10 blocks, got %d", uncles)
+	}
+	uncle, err := storedHeader(db, inWindow.Hash().Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uncle == nil || !uncle.Orphan || uncle.UncleBy != client.canon[15].Hash().Hex() {
+		t.Errorf("want the uncle stored as cited by block 15, got %+v", uncle)
+	}
+	if hash, err := canonicalHashAt(db, 13); err != nil || hash != client.canon[13].Hash().Hex() {
+		t.Errorf("want the canonical block stored at the uncle's height, got %q (%v)", hash, err)
+	}
+	if stored, err := storedHeader(db, outOfWindow.Hash().Hex()); err != nil || stored != nil {
+		t.Errorf("want the uncle out of the scan not stored, got %+v (%v)", stored, err)
+	}
+	if last, ok, err := cursor(db); err != nil || !ok || last != 20 {
+		t.Errorf("want the head recorded as processed, got %d, %v (%v)", last, ok, err)
+	}
+}
+
+func TestScanOnceGap(t *testing.T) {
+	db := newTestDB(t)
+	client := newMockChainReader()
+	miner := common.HexToAddress(randomHex(20))
+	for n := uint64(1); n <= 20; n++ {
+		client.addBlock(generateMockBlock(n, miner), true)
+	}
+
+	// Heights 6 to 10 are neither processed nor scanned: the cursor is kept for them.
+	if err := setCursor(db, 5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := scanOnce(client, db, 20, 10); err != nil {
+		t.Fatal(err)
+	}
+	if last, ok, err := cursor(db); err != nil || !ok || last != 5 {
+		t.Errorf("want the cursor kept before the gap, got %d, %v (%v)", last, ok, err)
+	}
+
+	// A scan adjoining the cursor advances it.
+	if err := setCursor(db, 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := scanOnce(client, db, 20, 10); err != nil {
+		t.Fatal(err)
+	}
+	if last, ok, err := cursor(db); err != nil || !ok || last != 20 {
+		t.Errorf("want the head recorded as processed, got %d, %v (%v)", last, ok, err)
+	}
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"net/http"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// defaultOrphanRateBucket is the default number of blocks per point of /api/orphan-rate.
+const defaultOrphanRateBucket = uint64(1000)
+
+// OrphanRatePoint is the orphan rate over a bucket of heights, From to To inclusive.
+type OrphanRatePoint struct {
+	From    uint64  `json:"from"`
+	To      uint64  `json:"to"`
+	Orphans int64   `json:"orphans"`
+	Rate    float64 `json:"rate"`
+}
+
+// OrphanRateSeries is the orphan rate time-series, along with the bucket size actually used.
+type OrphanRateSeries struct {
+	Bucket uint64            `json:"bucket"`
+	Points []OrphanRatePoint `json:"points"`
+}
+
+// orphanRateBucket returns the bucket size to use for the heights between min and max (inclusive):
+// the requested bucket size, widened if needed so that there are no more than maxPoints buckets.
+// A maxPoints of 0 means no limit.
+func orphanRateBucket(min, max, bucket, maxPoints uint64) uint64 {
+	if bucket == 0 {
+		bucket = 1
+	}
+	if maxPoints == 0 || max < min {
+		return bucket
+	}
+	span := max - min + 1
+	if (span+bucket-1)/bucket <= maxPoints {
+		return bucket
+	}
+	return (span + maxPoints - 1) / maxPoints
+}
+
+// orphanRateSeries returns the orphan rate of the heights between min and max (inclusive),
+// in buckets of the given size aligned on min; see orphanRateBucket for maxPoints.
+// Buckets without orphans are included, with a rate of 0.
+func orphanRateSeries(db *gorm.DB, min, max, bucket, maxPoints uint64) (*OrphanRateSeries, error) {
+	bucket = orphanRateBucket(min, max, bucket, maxPoints)
+	series := &OrphanRateSeries{Bucket: bucket, Points: []OrphanRatePoint{}}
+	if max < min {
+		return series, nil
+	}
+
+	counts := []struct {
+		Bucket  uint64
+		Orphans int64
+	}{}
+	err := db.Model(&Header{}).
+		Select("(number - ?) / ? AS bucket, COUNT(*) AS orphans", min, bucket).
+		Where("orphan = ?", true).
+		Where("number >= ? AND number <= ?", min, max).
+		Group("bucket").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	orphans := map[uint64]int64{}
+	for _, c := range counts {
+		orphans[c.Bucket] = c.Orphans
+	}
+
+	for i := uint64(0); i <= (max-min)/bucket; i++ {
+		p := OrphanRatePoint{From: min + i*bucket, To: min + (i+1)*bucket - 1, Orphans: orphans[i]}
+		if p.To > max {
+			p.To = max
+		}
+		p.Rate = float64(p.Orphans) / float64(p.To-p.From+1)
+		series.Points = append(series.Points, p)
+	}
+	return series, nil
+}
+
+// orphanRateHandler serves the orphan rate time-series.
+// The range defaults to the stored headers, and can be set with number_min and number_max.
+// The bucket size can be set with bucket, and is widened as needed to return no more than max_points points.
+func orphanRateHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		min, max, err := numberRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		bucket, maxPoints := defaultOrphanRateBucket, uint64(0)
+		if q := r.URL.Query().Get("bucket"); q != "" {
+			if bucket, err = strconv.ParseUint(q, 10, 64); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if q := r.URL.Query().Get("max_points"); q != "" {
+			if maxPoints, err = strconv.ParseUint(q, 10, 64); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Clamp the range to the stored headers, so that open ranges don't produce endless empty buckets.
+		var bounds struct{ Min, Max uint64 }
+		err = db.Model(&Header{}).
+			Select("COALESCE(MIN(number), 0) AS min, COALESCE(MAX(number), 0) AS max").
+			Scan(&bounds).Error
+		if err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if min < bounds.Min {
+			min = bounds.Min
+		}
+		if max > bounds.Max {
+			max = bounds.Max
+		}
+
+		series, err := orphanRateSeries(db, min, max, bucket, maxPoints)
+		if err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, series)
+	}
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOrphanRateMaxPoints(t *testing.T) {
+	db := newTestDB(t)
+
+	for n := uint64(0); n < 10_000; n += 100 {
+		h := generateMockHead()
+		h.Number, h.Orphan = n, true
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, maxPoints := range []uint64{1, 3, 7, 10, 100} {
+		series, err := orphanRateSeries(db, 0, 9_999, 10, maxPoints)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uint64(len(series.Points)) > maxPoints {
+			t.Errorf("max_points=%d: got %d points with bucket %d", maxPoints, len(series.Points), series.Bucket)
+		}
+		var orphans int64
+		for _, p := range series.Points {
+			orphans += p.Orphans
+		}
+		if orphans != 100 {
+			t.Errorf("max_points=%d: want all 100 orphans counted, got %d", maxPoints, orphans)
+		}
+	}
+
+	// The requested bucket is kept if it fits.
+	if b := orphanRateBucket(0, 9_999, 1000, 100); b != 1000 {
+		t.Errorf("want bucket 1000 kept, got %d", b)
+	}
+
+	w := httptest.NewRecorder()
+	orphanRateHandler(db)(w, httptest.NewRequest("GET", "/api/orphan-rate?bucket=10&max_points=50", nil))
+	series := OrphanRateSeries{}
+	if err := json.Unmarshal(w.Body.Bytes(), &series); err != nil {
+		t.Fatal(err)
+	}
+	if len(series.Points) > 50 || series.Bucket != 199 {
+		t.Fatalf("want at most 50 points with effective bucket 199 (stored heights 0-9900), got %d points with bucket %d", len(series.Points), series.Bucket)
+	}
+}
@@ -0,0 +1,283 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+var pruneMinFreeMB uint64
+var pruneKeepBlocks uint64
+var pruneVacuum bool
+var pruneInterval time.Duration
+
+var pruneBelow uint64
+var pruneOlderThan time.Duration
+var pruneCmdVacuum bool
+var pruneDryRun bool
+
+// pruneCmd deletes old records from an existing database.
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete old blocks and their transactions from the database",
+	Long: `Delete the stored blocks below a block number (--below) and/or with timestamps older than an age (--older-than),
+along with their relations to transactions, uncle citations, canonical heads, reorg events, and anomalies, and the transactions
+no longer included by any stored block. With both flags, only the blocks matching both are deleted.
+
+The database is vacuumed afterwards, unless --vacuum=false.
+With --dry-run, the numbers of records which would be deleted are reported, and nothing is deleted.
+Nothing is migrated: run the migrate subcommand first on databases of older versions.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		query, queryArgs, err := pruneCondition(pruneBelow, pruneOlderThan, time.Now())
+		if err != nil {
+			logError("Invalid prune range", "err", err)
+			os.Exit(1)
+		}
+		dial, err := dialector(dbDriver, dbPath, dbDSN)
+		if err != nil {
+			logError("Invalid database configuration", "err", err)
+			os.Exit(1)
+		}
+		db, err := gorm.Open(dial, &gorm.Config{})
+		if err != nil {
+			logError("Database open failed", "err", err)
+			os.Exit(1)
+		}
+		if err := checkSchema(db); err != nil {
+			logError("Database schema is out of date; run the migrate subcommand first", "err", err)
+			os.Exit(1)
+		}
+
+		counts, err := pruneHeadersWhere(db, pruneDryRun, query, queryArgs...)
+		if err != nil {
+			logError("Pruning failed", "err", err)
+			os.Exit(1)
+		}
+		msg := "Pruned"
+		if pruneDryRun {
+			msg = "Dry run: would prune"
+		}
+		logInfo(msg, "headers", counts.Headers, "txes", counts.Txes, "headerTxes", counts.HeaderTxes, "uncleCitations", counts.UncleCitations,
+			"canonicalHeads", counts.CanonicalHeads, "reorgEvents", counts.ReorgEvents, "anomalies", counts.Anomalies, "cursors", counts.Metas)
+
+		if pruneDryRun || !pruneCmdVacuum {
+			return
+		}
+		if err := vacuumDB(db); err != nil {
+			logError("Vacuum failed", "err", err)
+			os.Exit(1)
+		}
+		logInfo("Vacuumed database")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+
+	pruneCmd.Flags().StringVar(&dbDriver, "db.driver", dbDriverSQLite, "Database driver: sqlite (at --db.path) or postgres (at --db.dsn)")
+	pruneCmd.Flags().StringVar(&dbPath, "db.path", "", "Path to database file, eg. /path/to/db.sqlite")
+	pruneCmd.Flags().StringVar(&dbDSN, "db.dsn", "", "Postgres connection string, eg. \"host=localhost user=tracker dbname=orphans sslmode=disable\"")
+	pruneCmd.Flags().Uint64Var(&pruneBelow, "below", 0, "Delete the blocks below this block number")
+	pruneCmd.Flags().DurationVar(&pruneOlderThan, "older-than", 0, "Delete the blocks whose timestamps are older than this, eg. 720h")
+	pruneCmd.Flags().BoolVar(&pruneCmdVacuum, "vacuum", true, "Vacuum the database after deleting")
+	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "Report the numbers of records which would be deleted, without deleting them")
+}
+
+// pruneCondition returns the condition on the headers for the prune subcommand's --below and --older-than values,
+// at least one of which must be set. Ages are relative to now, and compared to the blocks' (miner-reported) timestamps.
+func pruneCondition(below uint64, olderThan time.Duration, now time.Time) (string, []interface{}, error) {
+	conds, args := []string{}, []interface{}{}
+	if below > 0 {
+		conds, args = append(conds, "number < ?"), append(args, below)
+	}
+	if olderThan > 0 {
+		conds, args = append(conds, "time < ?"), append(args, uint64(now.Add(-olderThan).Unix()))
+	}
+	if len(conds) == 0 {
+		return "", nil, errors.New("please specify --below and/or --older-than")
+	}
+	return strings.Join(conds, " AND "), args, nil
+}
+
+// diskFree returns the free disk space at a path, in bytes. It is a variable so that tests can simulate low space.
+var diskFree = freeDiskSpace
+
+// PruneCounts are the numbers of rows deleted by a pruning.
+type PruneCounts struct {
+	Headers        int64
+	HeaderTxes     int64
+	Txes           int64
+	UncleCitations int64
+	CanonicalHeads int64
+	ReorgEvents    int64
+	Anomalies      int64
+	Metas          int64
+}
+
+// Total returns the number of rows deleted, in all tables.
+func (c *PruneCounts) Total() int64 {
+	return c.Headers + c.HeaderTxes + c.Txes + c.UncleCitations + c.CanonicalHeads + c.ReorgEvents + c.Anomalies + c.Metas
+}
+
+// errPruneDryRun rolls back a dry run of pruneHeadersWhere.
+var errPruneDryRun = errors.New("prune dry run")
+
+// pruneHeadersWhere deletes the headers matching the condition (soft-deleted or not), along with the rows depending on them,
+// in a single transaction: their relations to transactions, the transactions no longer included by any stored header,
+// the uncle citations they are part of, the canonical heads, reorg events and anomalies pointing to them, and the cursor
+// if the processed head and all the headers above it are deleted (so that the tracker doesn't resume from it).
+// It is the pruning of both the prune subcommand and --prune.min-free-mb.
+// With dryRun, the transaction is rolled back, so that the counts are those which would be deleted.
+func pruneHeadersWhere(db *gorm.DB, dryRun bool, query interface{}, args ...interface{}) (*PruneCounts, error) {
+	counts := &PruneCounts{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		pruned := tx.Unscoped().Model(&Header{}).Select("hash").Where(query, args...)
+
+		res := tx.Exec("DELETE FROM header_txes WHERE header_hash IN (?)", pruned)
+		if res.Error != nil {
+			return res.Error
+		}
+		counts.HeaderTxes = res.RowsAffected
+		res = tx.Exec("DELETE FROM uncle_citations WHERE uncle_hash IN (?) OR citer_hash IN (?)", pruned, pruned)
+		if res.Error != nil {
+			return res.Error
+		}
+		counts.UncleCitations = res.RowsAffected
+		res = tx.Exec("DELETE FROM canonical_heads WHERE hash IN (?)", pruned)
+		if res.Error != nil {
+			return res.Error
+		}
+		counts.CanonicalHeads = res.RowsAffected
+		res = tx.Exec("DELETE FROM reorg_events WHERE old_head IN (?) OR new_head IN (?)", pruned, pruned)
+		if res.Error != nil {
+			return res.Error
+		}
+		counts.ReorgEvents = res.RowsAffected
+		res = tx.Exec("DELETE FROM anomalies WHERE hash IN (?)", pruned)
+		if res.Error != nil {
+			return res.Error
+		}
+		counts.Anomalies = res.RowsAffected
+		last, ok, err := cursor(tx)
+		if err != nil {
+			return err
+		}
+		var prunedAbove int64
+		if ok {
+			if err := tx.Unscoped().Model(&Header{}).Where(query, args...).Where("number >= ?", last).Count(&prunedAbove).Error; err != nil {
+				return err
+			}
+		}
+		// Unscoped, to delete the rows rather than soft-delete them.
+		res = tx.Unscoped().Where(query, args...).Delete(&Header{})
+		if res.Error != nil {
+			return res.Error
+		}
+		counts.Headers = res.RowsAffected
+		if prunedAbove > 0 {
+			var keptAbove int64
+			if err := tx.Unscoped().Model(&Header{}).Where("number >= ?", last).Count(&keptAbove).Error; err != nil {
+				return err
+			}
+			if keptAbove == 0 {
+				res = tx.Delete(&Meta{}, "key = ?", metaKeyCursor)
+				if res.Error != nil {
+					return res.Error
+				}
+				counts.Metas = res.RowsAffected
+			}
+		}
+		res = tx.Exec("DELETE FROM txes WHERE hash NOT IN (SELECT tx_hash FROM header_txes)")
+		if res.Error != nil {
+			return res.Error
+		}
+		counts.Txes = res.RowsAffected
+		if dryRun {
+			return errPruneDryRun
+		}
+		return nil
+	})
+	if err == errPruneDryRun {
+		err = nil
+	}
+	return counts, err
+}
+
+// vacuumDB returns the space freed in the database: SQLite's VACUUM rebuilds the file, returning the free pages to the filesystem,
+// while PostgreSQL's makes the space of the deleted rows reusable, without the exclusive locks of VACUUM FULL.
+func vacuumDB(db *gorm.DB) error {
+	return db.Exec("VACUUM").Error
+}
+
+// pruneIfLowOnDisk prunes the headers more than keepBlocks behind the tip if the free disk space at path
+// is below minFree bytes, and vacuums the database afterwards if vacuum is set and rows were deleted.
+// It returns the counts of the deleted rows, or nil if the pruner wasn't run.
+func pruneIfLowOnDisk(db *gorm.DB, path string, minFree, tip, keepBlocks uint64, vacuum bool) (*PruneCounts, error) {
+	free, err := diskFree(path)
+	if err != nil {
+		return nil, err
+	}
+	if free >= minFree {
+		return nil, nil
+	}
+
+	below := uint64(0)
+	if tip > keepBlocks {
+		below = tip - keepBlocks
+	}
+	counts, err := pruneHeadersWhere(db, false, "number < ?", below)
+	if err != nil {
+		return nil, err
+	}
+	if counts.Total() == 0 {
+		logWarn("Low disk space: nothing left to prune", "freeMB", free/1024/1024, "path", path, "below", below)
+		return counts, nil
+	}
+	logWarn("Low disk space: pruned headers", "freeMB", free/1024/1024, "path", path, "pruned", counts.Headers, "below", below,
+		"txes", counts.Txes, "reorgs", counts.ReorgEvents)
+
+	if vacuum {
+		if err := vacuumDB(db); err != nil {
+			return counts, err
+		}
+		logInfo("Vacuumed database")
+	}
+	return counts, nil
+}
+
+// maxDiskPrunerBackoff caps the wait of the disk pruner, as a multiple of its interval.
+const maxDiskPrunerBackoff = 32
+
+// nextDiskPrunerWait returns the wait before the next check of the disk pruner, after one which waited wait:
+// while the disk stays low on space with nothing left to prune, the wait doubles from interval, up to maxDiskPrunerBackoff intervals,
+// so that the same empty pruning isn't repeated at every interval; it is reset to interval otherwise.
+func nextDiskPrunerWait(wait, interval time.Duration, counts *PruneCounts) time.Duration {
+	if counts == nil || counts.Total() > 0 {
+		return interval
+	}
+	if wait *= 2; wait > maxDiskPrunerBackoff*interval {
+		wait = maxDiskPrunerBackoff * interval
+	}
+	return wait
+}
+
+// runDiskPruner checks the free disk space at path at every interval, pruning the database as needed,
+// and backs off while there is nothing left to prune (see nextDiskPrunerWait). It never returns.
+func runDiskPruner(db *gorm.DB, path string, interval time.Duration) {
+	wait := interval
+	for {
+		time.Sleep(wait)
+		counts, err := pruneIfLowOnDisk(db, path, pruneMinFreeMB*1024*1024, status.LatestNumber(), pruneKeepBlocks, pruneVacuum)
+		if err != nil {
+			logError("Disk space pruning failed", "err", err)
+		}
+		if wait = nextDiskPrunerWait(wait, interval, counts); wait > interval {
+			logWarn("Backing off the disk space pruner", "wait", wait)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestPruneIfLowOnDisk(t *testing.T) {
+	db := newTestDB(t)
+
+	oldTx, sharedTx := generateMockTx(), generateMockTx()
+	old, recent := generateMockHead(), generateMockHead()
+	old.Number, recent.Number = 100, 900
+	old.Txes = []Tx{oldTx, sharedTx}
+	recent.Txes = []Tx{sharedTx}
+	for _, h := range []*Header{old, recent} {
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer func() { diskFree = freeDiskSpace }()
+	free := uint64(10 << 20)
+	diskFree = func(string) (uint64, error) { return free, nil }
+
+	// Enough space: nothing happens.
+	counts, err := pruneIfLowOnDisk(db, "db.sqlite", 5<<20, 1000, 500, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts != nil {
+		t.Fatal("want no pruning with enough free space")
+	}
+
+	// Low space: the headers more than 500 blocks behind the tip are pruned.
+	free = 1 << 20
+	counts, err = pruneIfLowOnDisk(db, "db.sqlite", 5<<20, 1000, 500, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts == nil || counts.Headers != 1 {
+		t.Fatalf("want the old header pruned with low free space, got %+v", counts)
+	}
+
+	hashes := []string{}
+	if err := db.Unscoped().Model(&Header{}).Pluck("hash", &hashes).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 1 || hashes[0] != recent.Hash {
+		t.Fatalf("want only header %s kept, got %v", recent.Hash, hashes)
+	}
+	txHashes := []string{}
+	if err := db.Unscoped().Model(&Tx{}).Pluck("hash", &txHashes).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(txHashes) != 1 || txHashes[0] != sharedTx.Hash {
+		t.Fatalf("want only tx %s kept, got %v", sharedTx.Hash, txHashes)
+	}
+
+	// Still low: nothing is left to prune, and the database isn't vacuumed again.
+	vacuums := 0
+	err = db.Callback().Raw().Before("gorm:raw").Register("test:count_vacuums", func(tx *gorm.DB) {
+		if tx.Statement.SQL.String() == "VACUUM" {
+			vacuums++
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts, err = pruneIfLowOnDisk(db, "db.sqlite", 5<<20, 1000, 500, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts == nil || counts.Total() != 0 {
+		t.Fatalf("want an empty pruning, got %+v", counts)
+	}
+	if vacuums != 0 {
+		t.Fatalf("want no vacuum after an empty pruning, got %d", vacuums)
+	}
+}
+
+func TestNextDiskPrunerWait(t *testing.T) {
+	interval := time.Minute
+	empty, pruned := &PruneCounts{}, &PruneCounts{Headers: 1}
+
+	wait := interval
+	for i := 0; i < 10; i++ {
+		wait = nextDiskPrunerWait(wait, interval, empty)
+	}
+	if wait != maxDiskPrunerBackoff*interval {
+		t.Fatalf("want the wait capped at %v while there is nothing to prune, got %v", maxDiskPrunerBackoff*interval, wait)
+	}
+	if got := nextDiskPrunerWait(interval, interval, empty); got != 2*interval {
+		t.Fatalf("want the wait doubled after an empty pruning, got %v", got)
+	}
+	if got := nextDiskPrunerWait(wait, interval, pruned); got != interval {
+		t.Fatalf("want the wait reset after a pruning, got %v", got)
+	}
+	if got := nextDiskPrunerWait(wait, interval, nil); got != interval {
+		t.Fatalf("want the wait reset once there is enough space, got %v", got)
+	}
+}
+
+func TestFreeDiskSpace(t *testing.T) {
+	free, err := freeDiskSpace(t.TempDir())
+	if err != nil {
+		t.Skip(err)
+	}
+	if free == 0 {
+		t.Fatal("want some free disk space in the temp dir")
+	}
+}
+
+func TestPruneHeadersWhere(t *testing.T) {
+	db := newTestDB(t)
+
+	oldTx, sharedTx := generateMockTx(), generateMockTx()
+	old, uncle, recent := generateMockHead(), generateMockHead(), generateMockHead()
+	old.Number, old.Time, old.Txes = 100, 1_000, []Tx{oldTx, sharedTx}
+	uncle.Number, uncle.Time, uncle.Orphan = 100, 1_000, true
+	recent.Number, recent.Time, recent.Txes = 900, 9_000, []Tx{sharedTx}
+	for _, h := range []*Header{old, uncle, recent} {
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+		if err := syncCanonicalHead(db, h.Number); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recordUncleCitation(db, uncle.Hash, randomHex(32)); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordUncleCitation(db, randomHex(32), old.Hash); err != nil {
+		t.Fatal(err)
+	}
+	reorgs := []ReorgEvent{{OldHead: uncle.Hash, NewHead: old.Hash}, {OldHead: randomHex(32), NewHead: recent.Hash}}
+	if err := db.Create(&reorgs).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := setCursor(db, recent.Number); err != nil {
+		t.Fatal(err)
+	}
+
+	query, args, err := pruneCondition(0, time.Hour, time.Unix(5_000, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PruneCounts{Headers: 2, HeaderTxes: 2, Txes: 1, UncleCitations: 2, CanonicalHeads: 1, ReorgEvents: 1}
+
+	// A dry run counts the records, and deletes nothing.
+	counts, err := pruneHeadersWhere(db, true, query, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *counts != want {
+		t.Errorf("want dry run counts %+v, got %+v", want, *counts)
+	}
+	var n int64
+	if err := db.Model(&Header{}).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("want nothing deleted by the dry run, got %d headers left", n)
+	}
+
+	counts, err = pruneHeadersWhere(db, false, query, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *counts != want {
+		t.Errorf("want counts %+v, got %+v", want, *counts)
+	}
+	hashes := []string{}
+	if err := db.Unscoped().Model(&Header{}).Pluck("hash", &hashes).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 1 || hashes[0] != recent.Hash {
+		t.Fatalf("want only header %s kept, got %v", recent.Hash, hashes)
+	}
+	heads := []CanonicalHead{}
+	if err := db.Find(&heads).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(heads) != 1 || heads[0].Hash != recent.Hash {
+		t.Errorf("want only the canonical head of %s kept, got %+v", recent.Hash, heads)
+	}
+	kept := []ReorgEvent{}
+	if err := db.Find(&kept).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != 1 || kept[0].NewHead != recent.Hash {
+		t.Errorf("want only the reorg to %s kept, got %+v", recent.Hash, kept)
+	}
+	if last, ok, err := cursor(db); err != nil || !ok || last != recent.Number {
+		t.Errorf("want the cursor kept below a kept header, got %d, %v (%v)", last, ok, err)
+	}
+
+	// Pruning the processed head and all the headers above it forgets the cursor.
+	counts, err = pruneHeadersWhere(db, false, "number < ?", 1_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts.Headers != 1 || counts.Metas != 1 {
+		t.Errorf("want the last header and the cursor deleted, got %+v", *counts)
+	}
+	if _, ok, err := cursor(db); err != nil || ok {
+		t.Errorf("want no cursor left, got %v (%v)", ok, err)
+	}
+}
+
+func TestPruneCondition(t *testing.T) {
+	now := time.Unix(10_000, 0)
+	for _, c := range []struct {
+		below     uint64
+		olderThan time.Duration
+		query     string
+		args      []interface{}
+	}{
+		{100, 0, "number < ?", []interface{}{uint64(100)}},
+		{0, time.Hour, "time < ?", []interface{}{uint64(6_400)}},
+		{100, time.Hour, "number < ? AND time < ?", []interface{}{uint64(100), uint64(6_400)}},
+	} {
+		query, args, err := pruneCondition(c.below, c.olderThan, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if query != c.query || !reflect.DeepEqual(args, c.args) {
+			t.Errorf("below %d, older than %v: want %q %v, got %q %v", c.below, c.olderThan, c.query, c.args, query, args)
+		}
+	}
+	if _, _, err := pruneCondition(0, 0, now); err == nil {
+		t.Error("want an error without --below nor --older-than")
+	}
+}
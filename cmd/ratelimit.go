@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var httpRateLimit float64
+
+// rateLimitSweepInterval is how often the buckets of clients which have been idle long enough to refill are dropped.
+const rateLimitSweepInterval = time.Minute
+
+// tokenBucket is a client's allowance of requests: it holds up to the burst in tokens, refilled at the rate.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter limits the rate of requests per client with token buckets, allowing bursts of up to burst requests.
+// It is safe for concurrent use.
+type rateLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// newRateLimiter returns a limiter of rate requests per second per client.
+// Bursts are limited to the requests of a second, and at least one.
+func newRateLimiter(rate float64) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   math.Max(1, math.Ceil(rate)),
+		buckets: map[string]*tokenBucket{},
+		now:     time.Now,
+	}
+}
+
+// take takes a token from the client's bucket. If there is none, it returns false, with the wait until there is one.
+func (l *rateLimiter) take(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// sweep drops the buckets which have refilled, so that the limiter doesn't grow with every client ever seen.
+// A dropped bucket is the same as a new, full one.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	for client, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// clientIP returns the IP address of the request's client, from the connection's remote address.
+// Forwarding headers (eg. X-Forwarded-For) are ignored, since any client can set them.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimitHandler limits the rate of the requests to the /api/ endpoints per client IP, responding 429 Too Many Requests,
+// with a Retry-After header, to those above the limit. Other endpoints, eg. /status and /healthz for monitoring, are exempt.
+func rateLimitHandler(l *rateLimiter, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if ok, wait := l.take(clientIP(r)); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	l := newRateLimiter(2)
+	l.now = func() time.Time { return now }
+
+	// A burst of 2 is allowed, then the client has to wait for a token.
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.take("10.0.0.1"); !ok {
+			t.Fatalf("want request %d allowed", i)
+		}
+	}
+	ok, wait := l.take("10.0.0.1")
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("want the third request limited for 500ms, got %v %v", ok, wait)
+	}
+	// Other clients have their own buckets.
+	if ok, _ := l.take("10.0.0.2"); !ok {
+		t.Fatal("want another client allowed")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.take("10.0.0.1"); !ok {
+		t.Fatal("want a request allowed after the wait")
+	}
+
+	// Idle clients' buckets are swept.
+	now = now.Add(rateLimitSweepInterval)
+	l.take("10.0.0.3")
+	if len(l.buckets) != 1 {
+		t.Errorf("want the idle buckets swept, got %d buckets", len(l.buckets))
+	}
+}
+
+func TestRateLimitHandler(t *testing.T) {
+	h := rateLimitHandler(newRateLimiter(1), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("/api/headers"); rec.Code != http.StatusOK {
+		t.Fatalf("want the first request allowed, got %d", rec.Code)
+	}
+	rec := get("/api/headers?raw_sql=SELECT+1")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("want 429 with Retry-After 1, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	for _, path := range []string{"/status", "/healthz"} {
+		if rec := get(path); rec.Code != http.StatusOK {
+			t.Errorf("want %s exempt, got %d", path, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var fetchReceipts bool
+
+// errNoReceipts is returned when the client cannot fetch receipts, eg. a test double.
+var errNoReceipts = errors.New("client cannot fetch receipts")
+
+// receiptsOf returns the client as a receiptReader, if it can fetch receipts.
+func receiptsOf(client chainReader) (receiptReader, error) {
+	rr, ok := client.(receiptReader)
+	if !ok {
+		return nil, errNoReceipts
+	}
+	return rr, nil
+}
+
+// fillReceipts fills the status and gas used of the transactions from their receipts (eth_getTransactionReceipt).
+// A receipt describes the transaction's execution in the node's canonical chain: transactions only included
+// by orphans have none (the node doesn't execute side blocks), and are left without status.
+// The other transactions are filled even if some receipts fail to be fetched; it returns the first failure.
+func fillReceipts(client chainReader, txes []Tx) error {
+	rr, err := receiptsOf(client)
+	if err != nil {
+		return err
+	}
+	var failed int
+	var firstErr error
+	for i := range txes {
+		ctx, cancel := rpcContext()
+		receipt, err := rr.TransactionReceipt(ctx, common.HexToHash(txes[i].Hash))
+		cancel()
+		if err == ethereum.NotFound {
+			continue
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed++
+			continue
+		}
+		status, gasUsed := receipt.Status, receipt.GasUsed
+		txes[i].Status, txes[i].GasUsedActual = &status, &gasUsed
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d receipts failed: %v", failed, len(txes), firstErr)
+	}
+	return nil
+}
+
+// blockFees sums the priority fees paid to the miner by the block's transactions, ie. (effective gas price - base fee) × gas used,
+// and the base fee burnt by them, ie. base fee × gas used, in wei. The txes are the block's transactions, in order,
+// with the gas used filled from their receipts (see fillReceipts). Receipts describe the canonical execution, so an orphan's
+// transactions are counted as executed there. It returns nil sums if the block has no base fee (before EIP-1559),
+// or if any transaction has no receipt, eg. one only included by orphans, rather than partial sums.
+func blockFees(bl *types.Block, txes []Tx) (tips, burnt *big.Int, err error) {
+	baseFee := bl.BaseFee()
+	if baseFee == nil {
+		return nil, nil, nil
+	}
+	if len(txes) != len(bl.Transactions()) {
+		return nil, nil, fmt.Errorf("have %d transactions for %d in the block", len(txes), len(bl.Transactions()))
+	}
+	tips, burnt = new(big.Int), new(big.Int)
+	for i, tx := range bl.Transactions() {
+		if txes[i].GasUsedActual == nil {
+			return nil, nil, nil
+		}
+		gasUsed := new(big.Int).SetUint64(*txes[i].GasUsedActual)
+		tip, err := tx.EffectiveGasTip(baseFee)
+		if err != nil {
+			return nil, nil, fmt.Errorf("transaction %s: %v", tx.Hash().Hex(), err)
+		}
+		tips.Add(tips, tip.Mul(tip, gasUsed))
+		burnt.Add(burnt, gasUsed.Mul(gasUsed, baseFee))
+	}
+	return tips, burnt, nil
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// receiptChainReader serves receipts by transaction hash, and fails for the hashes in failing.
+type receiptChainReader struct {
+	*mockChainReader
+	receipts map[common.Hash]*types.Receipt
+	failing  map[common.Hash]bool
+}
+
+func (m *receiptChainReader) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	if m.failing[txHash] {
+		return nil, errors.New("connection reset")
+	}
+	if r, ok := m.receipts[txHash]; ok {
+		return r, nil
+	}
+	return nil, ethereum.NotFound
+}
+
+func TestFillReceipts(t *testing.T) {
+	succeeded, reverted, orphanOnly, unlucky := generateMockTx(), generateMockTx(), generateMockTx(), generateMockTx()
+	client := &receiptChainReader{
+		mockChainReader: newMockChainReader(),
+		receipts: map[common.Hash]*types.Receipt{
+			common.HexToHash(succeeded.Hash): {Status: types.ReceiptStatusSuccessful, GasUsed: 21_000},
+			common.HexToHash(reverted.Hash):  {Status: types.ReceiptStatusFailed, GasUsed: 45_000},
+		},
+		failing: map[common.Hash]bool{common.HexToHash(unlucky.Hash): true},
+	}
+
+	txes := []Tx{succeeded, reverted, orphanOnly, unlucky}
+	// Through the block cache, as when tracking.
+	err := fillReceipts(newBlockCache(client, 16), txes)
+	if err == nil {
+		t.Error("want the failed receipt reported")
+	}
+	for i, want := range []struct {
+		status, gasUsed uint64
+		filled          bool
+	}{{1, 21_000, true}, {0, 45_000, true}, {}, {}} {
+		got := txes[i]
+		if !want.filled {
+			if got.Status != nil || got.GasUsedActual != nil {
+				t.Errorf("tx %d: want no status, got %v and %v", i, got.Status, got.GasUsedActual)
+			}
+			continue
+		}
+		if got.Status == nil || *got.Status != want.status || got.GasUsedActual == nil || *got.GasUsedActual != want.gasUsed {
+			t.Errorf("tx %d: want status %d and gas used %d, got %v and %v", i, want.status, want.gasUsed, got.Status, got.GasUsedActual)
+		}
+	}
+
+	if err := fillReceipts(newMockChainReader(), txes); err != errNoReceipts {
+		t.Errorf("want %v, got %v", errNoReceipts, err)
+	}
+}
+
+func TestTxReceiptFieldsStored(t *testing.T) {
+	db := newTestDB(t)
+
+	status, gasUsed := uint64(0), uint64(45_000)
+	h := generateMockHead()
+	tx := generateMockTx()
+	tx.Status, tx.GasUsedActual = &status, &gasUsed
+	h.Txes = []Tx{tx, generateMockTx()}
+	if err := h.CreateOrUpdate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := []Tx{}
+	if err := db.Find(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range stored {
+		if s.Hash == tx.Hash {
+			if s.Status == nil || *s.Status != 0 || s.GasUsedActual == nil || *s.GasUsedActual != gasUsed {
+				t.Errorf("want status 0 and gas used %d stored, got %v and %v", gasUsed, s.Status, s.GasUsedActual)
+			}
+		} else if s.Status != nil {
+			t.Errorf("want no status stored without a receipt, got %d", *s.Status)
+		}
+	}
+}
+
+func TestBlockFees(t *testing.T) {
+	to := common.HexToAddress(randomHex(20))
+	header := generateMockBlock(100, to).Header()
+	header.BaseFee = big.NewInt(10e9)
+	bl := types.NewBlockWithHeader(header).WithBody([]*types.Transaction{
+		types.NewTx(&types.LegacyTx{Nonce: 0, To: &to, Gas: 21000, GasPrice: big.NewInt(12e9)}),
+		// Capped by the fee cap: 1 gwei of tip rather than 3.
+		types.NewTx(&types.DynamicFeeTx{Nonce: 1, To: &to, Gas: 50000, GasTipCap: big.NewInt(3e9), GasFeeCap: big.NewInt(11e9)}),
+	}, nil)
+	gasUsed := []uint64{21000, 30000}
+	txes := make([]Tx, len(gasUsed))
+	for i := range txes {
+		txes[i].GasUsedActual = &gasUsed[i]
+	}
+
+	tips, burnt, err := blockFees(bl, txes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := big.NewInt(21000*2e9 + 30000*1e9); tips == nil || tips.Cmp(want) != 0 {
+		t.Errorf("want tips %v, got %v", want, tips)
+	}
+	if want := big.NewInt((21000 + 30000) * 10e9); burnt == nil || burnt.Cmp(want) != 0 {
+		t.Errorf("want burnt fees %v, got %v", want, burnt)
+	}
+
+	// Without a receipt, or a base fee, there are no sums.
+	txes[1].GasUsedActual = nil
+	if tips, burnt, err := blockFees(bl, txes); err != nil || tips != nil || burnt != nil {
+		t.Errorf("want no sums without a receipt, got %v, %v (%v)", tips, burnt, err)
+	}
+	legacy := types.NewBlockWithHeader(generateMockBlock(100, to).Header()).WithBody(bl.Transactions(), nil)
+	if tips, burnt, err := blockFees(legacy, txes); err != nil || tips != nil || burnt != nil {
+		t.Errorf("want no sums without a base fee, got %v, %v (%v)", tips, burnt, err)
+	}
+}
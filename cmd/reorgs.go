@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"gorm.io/gorm"
+)
+
+// maxReorgWalk is the maximum number of parents followed to find the common ancestor of a reorg.
+const maxReorgWalk = 1000
+
+// ReorgEvent is a change of the node's head to a block which doesn't descend from the previous head.
+// Depth is the number of blocks of the old chain replaced, ie. from the common ancestor (excluded) to the old head.
+type ReorgEvent struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt      time.Time `json:"createdAt"`
+	OldHead        string    `gorm:"index" json:"oldHead"`
+	OldHeadNumber  uint64    `json:"oldHeadNumber"`
+	NewHead        string    `gorm:"index" json:"newHead"`
+	NewHeadNumber  uint64    `json:"newHeadNumber"`
+	CommonAncestor uint64    `gorm:"index" json:"commonAncestor"`
+	Depth          uint64    `json:"depth"`
+}
+
+// parentHeader returns the parent of the header, from the database if stored, and from the node otherwise.
+func parentHeader(client chainReader, db *gorm.DB, h *Header) (*Header, error) {
+	parent, err := storedHeader(db, h.ParentHash)
+	if err != nil || parent != nil {
+		return parent, err
+	}
+	ctx, cancel := rpcContext()
+	defer cancel()
+	bl, err := client.BlockByHash(ctx, common.HexToHash(h.ParentHash))
+	if err != nil {
+		return nil, fmt.Errorf("parent %s of block %d: %w", h.ParentHash, h.Number, err)
+	}
+	return appHeader(bl.Header()), nil
+}
+
+// commonAncestor returns the latest block which both heads descend from (or are),
+// by following their parents until they converge.
+func commonAncestor(client chainReader, db *gorm.DB, a, b *Header) (*Header, error) {
+	for i := 0; a.Hash != b.Hash; i++ {
+		if i == maxReorgWalk {
+			return nil, fmt.Errorf("no common ancestor of %s and %s within %d blocks", a.Hash, b.Hash, maxReorgWalk)
+		}
+		var err error
+		if a.Number >= b.Number {
+			a, err = parentHeader(client, db, a)
+		} else {
+			b, err = parentHeader(client, db, b)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// recordReorg records the reorg from the old head to the new head, if it is one.
+// It returns the event, or nil if the new head descends from the old head (eg. after missed head events).
+func recordReorg(client chainReader, db *gorm.DB, oldHead, newHead *Header) (*ReorgEvent, error) {
+	ancestor, err := commonAncestor(client, db, oldHead, newHead)
+	if err != nil {
+		return nil, err
+	}
+	if ancestor.Hash == oldHead.Hash {
+		return nil, nil
+	}
+	ev := &ReorgEvent{
+		OldHead:        oldHead.Hash,
+		OldHeadNumber:  oldHead.Number,
+		NewHead:        newHead.Hash,
+		NewHeadNumber:  newHead.Number,
+		CommonAncestor: ancestor.Number,
+		Depth:          oldHead.Number - ancestor.Number,
+	}
+	return ev, db.Create(ev).Error
+}
+
+// reorgsHandler serves the recorded reorgs, newest first, optionally in a number_min/number_max range
+// of their common ancestors.
+func reorgsHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		min, max, err := numberRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		reorgs := []ReorgEvent{}
+		res := db.Model(&ReorgEvent{}).
+			Where("common_ancestor >= ? AND common_ancestor <= ?", min, max).
+			Order("id DESC")
+		if err := paginate(r, res, apiDefaultLimitHeaders).Find(&reorgs).Error; err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, reorgs)
+	}
+}
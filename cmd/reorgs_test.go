@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// mockChain adds a chain of blocks on top of the parent to the client, and returns them.
+func mockChain(client *mockChainReader, parent *types.Block, length int, canonical bool) []*types.Block {
+	chain := []*types.Block{}
+	for i := 0; i < length; i++ {
+		h := generateMockBlock(parent.NumberU64()+1, common.HexToAddress(randomHex(20))).Header()
+		h.ParentHash = parent.Hash()
+		bl := types.NewBlockWithHeader(h)
+		client.addBlock(bl, canonical)
+		chain = append(chain, bl)
+		parent = bl
+	}
+	return chain
+}
+
+func TestRecordReorg(t *testing.T) {
+	db := newTestDB(t)
+	client := newMockChainReader()
+
+	ancestor := generateMockBlock(100, common.HexToAddress(randomHex(20)))
+	client.addBlock(ancestor, true)
+	oldChain := mockChain(client, ancestor, 2, false)
+	newChain := mockChain(client, ancestor, 3, true)
+
+	// The old chain's first block is stored; the rest is fetched from the node.
+	if err := appHeader(oldChain[0].Header()).CreateOrUpdate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	oldHead, newHead := appHeader(oldChain[1].Header()), appHeader(newChain[2].Header())
+	ev, err := recordReorg(client, db, oldHead, newHead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev == nil || ev.CommonAncestor != 100 || ev.Depth != 2 || ev.OldHead != oldHead.Hash || ev.NewHead != newHead.Hash {
+		t.Fatalf("want a reorg of depth 2 from block 100, got %+v", ev)
+	}
+
+	// A new head descending from the old head isn't a reorg.
+	if ev, err := recordReorg(client, db, appHeader(newChain[0].Header()), newHead); err != nil || ev != nil {
+		t.Fatalf("want no reorg for a descendant, got %+v, %v", ev, err)
+	}
+
+	rec := httptest.NewRecorder()
+	reorgsHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/reorgs", nil))
+	reorgs := []ReorgEvent{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &reorgs); err != nil {
+		t.Fatal(err)
+	}
+	if len(reorgs) != 1 || reorgs[0].Depth != 2 {
+		t.Errorf("want the recorded reorg served, got %+v", reorgs)
+	}
+}
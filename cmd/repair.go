@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"os"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+// repairUnclesCmd repairs the uncle relations of an existing database.
+var repairUnclesCmd = &cobra.Command{
+	Use:   "repair-uncles",
+	Short: "Verify and repair the uncle relations in the database",
+	Long: `Scan the stored blocks citing uncles, and make sure that each cited uncle is stored as an orphan
+with its uncleBy relation set to the citing block, correcting the uncles which are not.
+
+Cited uncles which are not stored at all are listed. If --rpc.target is set, they are fetched
+(from the citing blocks) and stored.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dial, err := dialector(dbDriver, dbPath, dbDSN)
+		if err != nil {
+			logError("Invalid database configuration", "err", err)
+			os.Exit(1)
+		}
+		db, err := gorm.Open(dial, &gorm.Config{})
+		if err != nil {
+			logError("Database open failed", "err", err)
+			os.Exit(1)
+		}
+		if err := migrateSchema(db); err != nil {
+			logError("Schema migration failed", "err", err)
+			os.Exit(1)
+		}
+
+		repair, err := repairUncleRelations(db)
+		if err != nil {
+			logError("Uncle repair failed", "err", err)
+			os.Exit(1)
+		}
+		logInfo("Corrected uncles", "uncles", repair.Corrected)
+
+		if len(repair.Missing) == 0 || rpcTarget == "" {
+			for _, m := range repair.Missing {
+				logWarn("Missing uncle", "hash", m.Hash, "citedBy", m.CitedBy)
+			}
+			return
+		}
+		rpcClient, err := rpc.Dial(rpcTarget)
+		if err != nil {
+			logError("RPC dial failed", "target", rpcTarget, "err", err)
+			os.Exit(1)
+		}
+		n, err := storeMissingUncles(ethclient.NewClient(rpcClient), db, repair.Missing)
+		if err != nil {
+			logError("Storing missing uncles failed", "err", err)
+			os.Exit(1)
+		}
+		logInfo("Stored missing uncles", "stored", n, "missing", len(repair.Missing))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(repairUnclesCmd)
+
+	repairUnclesCmd.Flags().StringVar(&dbDriver, "db.driver", dbDriverSQLite, "Database driver: sqlite (at --db.path) or postgres (at --db.dsn)")
+	repairUnclesCmd.Flags().StringVar(&dbPath, "db.path", "", "Path to database file, eg. /path/to/db.sqlite")
+	repairUnclesCmd.Flags().StringVar(&dbDSN, "db.dsn", "", "Postgres connection string, eg. \"host=localhost user=tracker dbname=orphans sslmode=disable\"")
+	repairUnclesCmd.Flags().StringVar(&rpcTarget, "rpc.target", "", "Optional RPC target endpoint to fetch missing uncles from, eg. /path/to/geth.ipc")
+}
+
+// MissingUncle is an uncle cited by a stored block, but not stored itself.
+type MissingUncle struct {
+	Hash    string
+	CitedBy string
+}
+
+// UncleRepair is the outcome of repairUncleRelations.
+type UncleRepair struct {
+	// Corrected is the number of stored uncles whose uncleBy relation or orphan flag were corrected.
+	Corrected int64
+	Missing   []MissingUncle
+}
+
+// repairUncleRelations makes sure that every uncle cited by a stored block's Uncles field
+// is flagged orphan and has its uncleBy relation set to the citing block.
+// If more than one stored block cites the same uncle, a canonical citing block wins;
+// all the citing blocks are recorded in the uncle_citations table, and the canonical heads of the corrected heights updated.
+// The cited uncles which are not stored are returned as missing, in ascending order by hash.
+func repairUncleRelations(db *gorm.DB) (*UncleRepair, error) {
+	citers := []Header{}
+	err := db.Model(&Header{}).
+		Select("hash", "number", "orphan", "uncles").
+		Where("COALESCE(uncles, '') != ''").
+		Order("orphan DESC").
+		Order("hash ASC").
+		Find(&citers).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Canonical citers come last, so they override orphaned ones.
+	citedBy := map[string]string{}
+	citedAt := map[string]uint64{}
+	allCiters := map[string][]string{}
+	for _, c := range citers {
+		for _, u := range c.Uncles {
+			citedBy[u] = c.Hash
+			citedAt[u] = c.Number
+			allCiters[u] = append(allCiters[u], c.Hash)
+		}
+	}
+	uncles := make([]string, 0, len(citedBy))
+	for u := range citedBy {
+		uncles = append(uncles, u)
+	}
+	sort.Strings(uncles)
+
+	// The canonical heads of the heights whose orphan flags change are updated in the same transaction.
+	repair := &UncleRepair{Missing: []MissingUncle{}}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		touched := map[uint64]bool{}
+		for _, u := range uncles {
+			numbers := []uint64{}
+			if err := tx.Model(&Header{}).Where("hash = ?", u).Limit(1).Pluck("number", &numbers).Error; err != nil {
+				return err
+			}
+			if len(numbers) == 0 {
+				repair.Missing = append(repair.Missing, MissingUncle{Hash: u, CitedBy: citedBy[u]})
+				continue
+			}
+			res := tx.Model(&Header{}).
+				Where("hash = ?", u).
+				Where("uncle_by != ? OR orphan = ?", citedBy[u], false).
+				Updates(map[string]interface{}{"uncle_by": citedBy[u], "orphan": true, "inclusion_distance": gorm.Expr("? - number", citedAt[u])})
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected > 0 {
+				touched[numbers[0]] = true
+			}
+			repair.Corrected += res.RowsAffected
+			for _, c := range allCiters[u] {
+				if err := recordUncleCitation(tx, u, c); err != nil {
+					return err
+				}
+			}
+		}
+		for number := range touched {
+			if err := syncCanonicalHead(tx, number); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return repair, nil
+}
+
+// storeMissingUncles fetches the citing blocks of the missing uncles, and stores the uncles' headers from them.
+// It returns the number of uncles stored.
+func storeMissingUncles(client chainReader, db *gorm.DB, missing []MissingUncle) (int, error) {
+	stored := 0
+	for _, m := range missing {
+		ctx, cancel := rpcContext()
+		bl, err := client.BlockByHash(ctx, common.HexToHash(m.CitedBy))
+		cancel()
+		if err != nil {
+			return stored, err
+		}
+		for _, uncle := range bl.Uncles() {
+			if uncle.Hash().Hex() != m.Hash {
+				continue
+			}
+			header := appHeader(uncle)
+			header.Orphan = true
+			header.UncleBy = m.CitedBy
+			if err := header.CreateOrUpdate(db, "orphan", "uncle_by"); err != nil {
+				return stored, err
+			}
+			if err := recordInclusionDistance(db, header, appHeader(bl.Header())); err != nil {
+				return stored, err
+			}
+			if err := recordUncleCitation(db, header.Hash, m.CitedBy); err != nil {
+				return stored, err
+			}
+			if err := syncCanonicalHead(db, header.Number); err != nil {
+				return stored, err
+			}
+			stored++
+		}
+	}
+	return stored, nil
+}
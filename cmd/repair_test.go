@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestRepairUncleRelations(t *testing.T) {
+	db := newTestDB(t)
+	client := newMockChainReader()
+
+	// The citing block's first uncle is stored without its relation, and the second isn't stored at all.
+	stored := generateMockBlock(99, common.HexToAddress(randomHex(20)))
+	missing := generateMockBlock(99, common.HexToAddress(randomHex(20)))
+	citer := generateMockBlock(100, common.HexToAddress(randomHex(20))).
+		WithBody(nil, []*types.Header{stored.Header(), missing.Header()})
+	client.addBlock(citer, true)
+
+	// An orphaned block also cites the first uncle; the canonical citer wins.
+	orphanCiter := generateMockBlock(100, common.HexToAddress(randomHex(20))).
+		WithBody(nil, []*types.Header{stored.Header()})
+
+	for _, h := range []*Header{appHeader(stored.Header()), appHeader(citer.Header()), appHeader(orphanCiter.Header())} {
+		if h.Hash == citer.Hash().Hex() {
+			h.Uncles = HashList{stored.Hash().Hex(), missing.Hash().Hex()}
+		}
+		if h.Hash == orphanCiter.Hash().Hex() {
+			h.Uncles = HashList{stored.Hash().Hex()}
+			h.Orphan = true
+		}
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+		if err := syncCanonicalHead(db, h.Number); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hash, err := canonicalHashAt(db, 99); err != nil || hash != stored.Hash().Hex() {
+		t.Fatalf("want the uncle stored as canonical before the repair, got %q (%v)", hash, err)
+	}
+
+	repair, err := repairUncleRelations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repair.Corrected != 1 {
+		t.Errorf("want 1 corrected uncle, got %d", repair.Corrected)
+	}
+	if len(repair.Missing) != 1 || repair.Missing[0] != (MissingUncle{Hash: missing.Hash().Hex(), CitedBy: citer.Hash().Hex()}) {
+		t.Fatalf("want uncle %s missing, got %+v", missing.Hash().Hex(), repair.Missing)
+	}
+	if hash, err := canonicalHashAt(db, 99); err != nil || hash != "" {
+		t.Errorf("want no canonical head left at the uncle's height, got %q (%v)", hash, err)
+	}
+
+	n, err := storeMissingUncles(client, db, repair.Missing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("want 1 stored uncle, got %d", n)
+	}
+
+	for _, u := range []*types.Block{stored, missing} {
+		h := &Header{}
+		if err := db.Where("hash = ?", u.Hash().Hex()).First(h).Error; err != nil {
+			t.Fatal(err)
+		}
+		if !h.Orphan || h.UncleBy != citer.Hash().Hex() {
+			t.Errorf("want uncle %s orphaned and cited by %s, got orphan=%v uncleBy=%q", h.Hash, citer.Hash().Hex(), h.Orphan, h.UncleBy)
+		}
+	}
+
+	// The repair is idempotent.
+	repair, err = repairUncleRelations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repair.Corrected != 0 || len(repair.Missing) != 0 {
+		t.Fatalf("want nothing left to repair, got %+v", repair)
+	}
+}
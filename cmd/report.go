@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+var reportFrom uint64
+var reportTo uint64
+
+// reportTopMiners is the number of miners listed in the report's top orphaning miners.
+const reportTopMiners = 5
+
+// reportCmd prints reorg statistics from an existing database, without an RPC connection.
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Print reorg statistics for a range of blocks from the database",
+	Long: `Print a human-readable report of the competitions, reorg depths, orphan rate,
+top orphaning miners, and uncles stored in the database for a range of blocks.
+
+The database is only read; no RPC connection is needed.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dial, err := dialector(dbDriver, dbPath, dbDSN)
+		if err != nil {
+			logError("Invalid database configuration", "err", err)
+			os.Exit(1)
+		}
+		db, err := gorm.Open(dial, &gorm.Config{})
+		if err != nil {
+			logError("Database open failed", "err", err)
+			os.Exit(1)
+		}
+
+		to := reportTo
+		if to == 0 {
+			if err := db.Model(&Header{}).Select("COALESCE(MAX(number), 0)").Scan(&to).Error; err != nil {
+				logError("Highest stored block query failed", "err", err)
+				os.Exit(1)
+			}
+		}
+		if reportFrom > to {
+			logError("Invalid range (--from is greater than --to)", "from", reportFrom, "to", to)
+			os.Exit(1)
+		}
+
+		report, err := buildReport(db, reportFrom, to)
+		if err != nil {
+			logError("Report failed", "err", err)
+			os.Exit(1)
+		}
+		report.Write(os.Stdout)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+
+	reportCmd.Flags().StringVar(&dbDriver, "db.driver", dbDriverSQLite, "Database driver: sqlite (at --db.path) or postgres (at --db.dsn)")
+	reportCmd.Flags().StringVar(&dbPath, "db.path", "", "Path to database file, eg. /path/to/db.sqlite")
+	reportCmd.Flags().StringVar(&dbDSN, "db.dsn", "", "Postgres connection string, eg. \"host=localhost user=tracker dbname=orphans sslmode=disable\"")
+	reportCmd.Flags().Uint64Var(&reportFrom, "from", 0, "First block number of the range (inclusive)")
+	reportCmd.Flags().Uint64Var(&reportTo, "to", 0, "Last block number of the range (inclusive); 0 for the highest stored block")
+}
+
+// MinerCount is a number of blocks attributed to a miner.
+type MinerCount struct {
+	Miner string
+	Count int
+}
+
+// Report is a summary of the reorg statistics for a range of blocks.
+type Report struct {
+	From, To     uint64
+	Competitions int
+	Orphans      int64
+	Uncles       int64
+
+	// OrphanRate is the number of orphans per block in the range.
+	OrphanRate float64
+
+	// MaxReorgDepth and AvgReorgDepth measure the lengths of the orphaned branches,
+	// ie. chains of orphans linked by their parent hashes.
+	MaxReorgDepth int
+	AvgReorgDepth float64
+
+	// TopOrphaningMiners are the miners of the canonical blocks which won the most competitions.
+	TopOrphaningMiners []MinerCount
+}
+
+// buildReport computes the report for the blocks between from and to, inclusive.
+func buildReport(db *gorm.DB, from, to uint64) (*Report, error) {
+	r := &Report{From: from, To: to}
+
+	competitions, err := findCompetitions(db, from, to)
+	if err != nil {
+		return nil, err
+	}
+	r.Competitions = len(competitions)
+
+	wins := map[string]int{}
+	for _, c := range competitions {
+		for _, h := range c.Headers {
+			if h.Hash == c.Canonical {
+				wins[h.Coinbase]++
+			}
+		}
+	}
+	for miner, n := range wins {
+		r.TopOrphaningMiners = append(r.TopOrphaningMiners, MinerCount{Miner: miner, Count: n})
+	}
+	sort.Slice(r.TopOrphaningMiners, func(i, j int) bool {
+		a, b := r.TopOrphaningMiners[i], r.TopOrphaningMiners[j]
+		return a.Count > b.Count || (a.Count == b.Count && a.Miner < b.Miner)
+	})
+	if len(r.TopOrphaningMiners) > reportTopMiners {
+		r.TopOrphaningMiners = r.TopOrphaningMiners[:reportTopMiners]
+	}
+
+	orphans := []*Header{}
+	err = db.Model(&Header{}).
+		Select("hash", "parent_hash").
+		Where("orphan = ?", true).
+		Where("number >= ? AND number <= ?", from, to).
+		Find(&orphans).Error
+	if err != nil {
+		return nil, err
+	}
+	r.Orphans = int64(len(orphans))
+	r.OrphanRate = float64(r.Orphans) / float64(to-from+1)
+	r.MaxReorgDepth, r.AvgReorgDepth = reorgDepths(orphans)
+
+	err = db.Model(&Header{}).
+		Where("uncle_by != ?", "").
+		Where("number >= ? AND number <= ?", from, to).
+		Count(&r.Uncles).Error
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reorgDepths returns the maximum and average lengths of the orphaned branches formed by the orphans.
+// A branch ends at an orphan which is not the parent of another orphan.
+func reorgDepths(orphans []*Header) (max int, avg float64) {
+	parents := map[string]string{}
+	isParent := map[string]bool{}
+	for _, o := range orphans {
+		parents[o.Hash] = o.ParentHash
+	}
+	for _, o := range orphans {
+		if _, ok := parents[o.ParentHash]; ok {
+			isParent[o.ParentHash] = true
+		}
+	}
+
+	branches, sum := 0, 0
+	for _, o := range orphans {
+		if isParent[o.Hash] {
+			continue
+		}
+		depth := 1
+		for h, ok := parents[o.ParentHash]; ok; h, ok = parents[h] {
+			depth++
+		}
+		branches++
+		sum += depth
+		if depth > max {
+			max = depth
+		}
+	}
+	if branches > 0 {
+		avg = float64(sum) / float64(branches)
+	}
+	return max, avg
+}
+
+// Write prints the report in a human-readable form.
+func (r *Report) Write(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Blocks:\t%d - %d\n", r.From, r.To)
+	fmt.Fprintf(tw, "Competitions:\t%d\n", r.Competitions)
+	fmt.Fprintf(tw, "Orphans:\t%d\n", r.Orphans)
+	fmt.Fprintf(tw, "Orphan rate:\t%.4f\n", r.OrphanRate)
+	fmt.Fprintf(tw, "Max reorg depth:\t%d\n", r.MaxReorgDepth)
+	fmt.Fprintf(tw, "Avg reorg depth:\t%.2f\n", r.AvgReorgDepth)
+	fmt.Fprintf(tw, "Uncles:\t%d\n", r.Uncles)
+	tw.Flush()
+
+	fmt.Fprintln(w, "Top orphaning miners:")
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, m := range r.TopOrphaningMiners {
+		fmt.Fprintf(tw, "  %s\t%d\n", m.Miner, m.Count)
+	}
+	tw.Flush()
+}
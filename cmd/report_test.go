@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestBuildReport(t *testing.T) {
+	db := newTestDB(t)
+
+	winner, loser := randomHex(20), randomHex(20)
+	store := func(number uint64, miner, parent string, orphan bool, uncleBy string) *Header {
+		h := generateMockHead()
+		h.Number, h.Coinbase, h.Orphan, h.UncleBy = number, miner, orphan, uncleBy
+		if parent != "" {
+			h.ParentHash = parent
+		}
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	// A 2-block reorg at 100-101, won by the same miner both times.
+	store(100, winner, "", false, "")
+	o100 := store(100, loser, "", true, "")
+	store(101, winner, "", false, "")
+	store(101, loser, o100.Hash, true, "")
+
+	// A 1-block reorg at 110, whose orphan was cited as an uncle.
+	store(110, loser, "", false, "")
+	store(110, winner, "", true, randomHex(32))
+
+	// Out of range.
+	store(300, winner, "", false, "")
+	store(300, loser, "", true, "")
+
+	r, err := buildReport(db, 100, 199)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Competitions != 3 {
+		t.Errorf("want 3 competitions, got %d", r.Competitions)
+	}
+	if r.Orphans != 3 || r.OrphanRate != 0.03 {
+		t.Errorf("want 3 orphans at rate 0.03, got %d at %f", r.Orphans, r.OrphanRate)
+	}
+	if r.MaxReorgDepth != 2 || r.AvgReorgDepth != 1.5 {
+		t.Errorf("want max/avg reorg depth 2/1.5, got %d/%f", r.MaxReorgDepth, r.AvgReorgDepth)
+	}
+	if r.Uncles != 1 {
+		t.Errorf("want 1 uncle, got %d", r.Uncles)
+	}
+	if len(r.TopOrphaningMiners) != 2 || r.TopOrphaningMiners[0] != (MinerCount{winner, 2}) {
+		t.Errorf("want %s on top with 2 wins, got %v", winner, r.TopOrphaningMiners)
+	}
+
+	buf := new(bytes.Buffer)
+	r.Write(buf)
+	if !strings.Contains(buf.String(), "Max reorg depth:  2") {
+		t.Errorf("want the max reorg depth printed, got:\n%s", buf)
+	}
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var rpcRetryMax int
+var rpcRetryInterval time.Duration
+var rpcTimeout time.Duration
+
+// rpcContext returns the context of an RPC call, which times out after --rpc.timeout (if set),
+// so that a hung node fails the call rather than blocking the tracker forever.
+func rpcContext() (context.Context, context.CancelFunc) {
+	if rpcTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), rpcTimeout)
+}
+
+// isRPCTimeout tells whether the error is an RPC call timing out (see rpcContext).
+func isRPCTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// maxRetryInterval caps the exponential backoff of retries.
+const maxRetryInterval = time.Minute
+
+// retrySleep is time.Sleep, replaced in tests.
+var retrySleep = time.Sleep
+
+// withRetry calls f until it succeeds, retrying up to max times after failures.
+// The wait between attempts starts at interval and doubles after each retry, up to maxRetryInterval.
+// Failed attempts are logged; the last error is returned once the retries are exhausted.
+func withRetry(what string, max int, interval time.Duration, f func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt > max {
+			return err
+		}
+		logWarn(what+" failed, retrying", "attempt", attempt, "of", max+1, "wait", interval, "err", err)
+		retrySleep(interval)
+		if interval *= 2; interval > maxRetryInterval {
+			interval = maxRetryInterval
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestWithRetry(t *testing.T) {
+	defer func() { retrySleep = time.Sleep }()
+	waits := []time.Duration{}
+	retrySleep = func(d time.Duration) { waits = append(waits, d) }
+
+	// Succeeds on the third attempt.
+	calls := 0
+	err := withRetry("test", 5, 20*time.Second, func() error {
+		if calls++; calls < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("want success on the third attempt, got %v after %d calls", err, calls)
+	}
+	if len(waits) != 2 || waits[0] != 20*time.Second || waits[1] != 40*time.Second {
+		t.Errorf("want exponential backoff, got %v", waits)
+	}
+
+	// Fails once the retries are exhausted, with the backoff capped.
+	waits, calls = nil, 0
+	err = withRetry("test", 3, 40*time.Second, func() error {
+		calls++
+		return errors.New("connection refused")
+	})
+	if err == nil || calls != 4 {
+		t.Fatalf("want failure after 4 attempts, got %v after %d calls", err, calls)
+	}
+	if len(waits) != 3 || waits[1] != maxRetryInterval || waits[2] != maxRetryInterval {
+		t.Errorf("want the backoff capped at %s, got %v", maxRetryInterval, waits)
+	}
+}
+
+// hungChainReader never responds, like a hung node: its calls return when their contexts are done.
+type hungChainReader struct{}
+
+func (hungChainReader) BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (hungChainReader) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestRPCTimeout(t *testing.T) {
+	defer func(d time.Duration) { rpcTimeout = d }(rpcTimeout)
+	rpcTimeout = 10 * time.Millisecond
+	db := newTestDB(t)
+
+	_, err := backfill(hungChainReader{}, db, 100, 100)
+	if !isRPCTimeout(err) {
+		t.Errorf("want a timeout, got %v", err)
+	}
+
+	// The header is stored without its block, with the timeout.
+	bl := generateMockBlock(100, common.HexToAddress(randomHex(20)))
+	h, err := handleHeader(hungChainReader{}, db, bl.Header(), true, "", SourceSide)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := storedHeader(db, h.Hash)
+	if err != nil || stored == nil || stored.Error == "" {
+		t.Errorf("want the header stored with the timeout, got %+v (%v)", stored, err)
+	}
+}
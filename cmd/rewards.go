@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"gorm.io/gorm"
+)
+
+var storeRewards bool
+
+// rewards is the monetary policy of the connected chain, set at startup if --store.rewards is on.
+var rewards rewardSchedule
+
+var ether = big.NewInt(1e18)
+
+// rewardSchedule is a chain's monetary policy: the base reward paid to the miner of a block.
+// Uncle-inclusion bonuses are derived from it (1/32 of the base reward per uncle).
+type rewardSchedule interface {
+	BaseReward(number uint64) *big.Int
+}
+
+// ethRewardSchedule is Ethereum's stepped block reward, which ended with the merge.
+type ethRewardSchedule struct{}
+
+func (ethRewardSchedule) BaseReward(number uint64) *big.Int {
+	switch {
+	case number >= 15_537_394: // Paris
+		return new(big.Int)
+	case number >= 7_280_000: // Constantinople
+		return new(big.Int).Mul(big.NewInt(2), ether)
+	case number >= 4_370_000: // Byzantium
+		return new(big.Int).Mul(big.NewInt(3), ether)
+	}
+	return new(big.Int).Mul(big.NewInt(5), ether)
+}
+
+// ecip1017RewardSchedule is Ethereum Classic's monetary policy (ECIP-1017):
+// the 5 ETC block reward is reduced by 20% at every era of EraLength blocks.
+type ecip1017RewardSchedule struct {
+	EraLength uint64
+}
+
+func (s ecip1017RewardSchedule) BaseReward(number uint64) *big.Int {
+	r := new(big.Int).Mul(big.NewInt(5), ether)
+	if number == 0 {
+		return r
+	}
+	era := (number - 1) / s.EraLength
+	for i := uint64(0); i < era; i++ {
+		r.Mul(r, big.NewInt(4))
+		r.Div(r, big.NewInt(5))
+	}
+	return r
+}
+
+// rewardSchedules are the known monetary policies by chain ID.
+var rewardSchedules = map[uint64]rewardSchedule{
+	1:  ethRewardSchedule{},
+	61: ecip1017RewardSchedule{EraLength: 5_000_000}, // Ethereum Classic
+	63: ecip1017RewardSchedule{EraLength: 2_000_000}, // Mordor
+}
+
+// receiptReader is the subset of the ethclient.Client API used to fetch transaction fees.
+type receiptReader interface {
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
+// blockReward computes the total reward paid to the miner of a block:
+// the base reward, the tips paid by its transactions, and the bonus for its uncles.
+// The receipts must be in the order of the block's transactions.
+func blockReward(schedule rewardSchedule, bl *types.Block, receipts []*types.Receipt) (*big.Int, error) {
+	if len(receipts) != len(bl.Transactions()) {
+		return nil, fmt.Errorf("have %d receipts for %d transactions", len(receipts), len(bl.Transactions()))
+	}
+	base := schedule.BaseReward(bl.NumberU64())
+
+	total := new(big.Int).Set(base)
+	for i, tx := range bl.Transactions() {
+		tip, err := tx.EffectiveGasTip(bl.BaseFee())
+		if err != nil {
+			return nil, err
+		}
+		total.Add(total, new(big.Int).Mul(tip, new(big.Int).SetUint64(receipts[i].GasUsed)))
+	}
+
+	bonus := new(big.Int).Div(base, big.NewInt(32))
+	total.Add(total, bonus.Mul(bonus, big.NewInt(int64(len(bl.Uncles())))))
+	return total, nil
+}
+
+// fetchBlockReward fetches the block's receipts and computes its reward.
+func fetchBlockReward(client chainReader, bl *types.Block) (*big.Int, error) {
+	rr, err := receiptsOf(client)
+	if err != nil {
+		return nil, err
+	}
+	receipts := make([]*types.Receipt, 0, len(bl.Transactions()))
+	for _, tx := range bl.Transactions() {
+		ctx, cancel := rpcContext()
+		receipt, err := rr.TransactionReceipt(ctx, tx.Hash())
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, receipt)
+	}
+	return blockReward(rewards, bl, receipts)
+}
+
+// MinerRewards is the sum of the rewards stored for a miner's canonical blocks.
+type MinerRewards struct {
+	Miner  string `json:"miner"`
+	Blocks int    `json:"blocks"`
+	Total  string `json:"total"`
+}
+
+// rewardsByMiner sums the stored rewards of canonical blocks in the number range by miner.
+// The sums are done here rather than in SQL because rewards overflow integer columns.
+func rewardsByMiner(db *gorm.DB, min, max uint64) ([]MinerRewards, error) {
+	rows := []Header{}
+	err := db.Model(&Header{}).
+		Select("coinbase", "block_reward").
+		Where("orphan = ?", false).
+		Where("block_reward != ?", "").
+		Where("number >= ? AND number <= ?", min, max).
+		Order("coinbase").
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	out := []MinerRewards{}
+	totals := []*big.Int{}
+	for _, h := range rows {
+		if len(out) == 0 || out[len(out)-1].Miner != h.Coinbase {
+			out = append(out, MinerRewards{Miner: h.Coinbase})
+			totals = append(totals, new(big.Int))
+		}
+		out[len(out)-1].Blocks++
+		totals[len(totals)-1].Add(totals[len(totals)-1], parseBig(h.BlockReward))
+	}
+	for i := range out {
+		out[i].Total = totals[i].String()
+	}
+	return out, nil
+}
+
+// rewardsHandler serves the reward sums by miner, optionally in a number_min/number_max range.
+func rewardsHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		min, max, err := numberRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		res, err := rewardsByMiner(db, min, max)
+		if err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, res)
+	}
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestRewardScheduleEras(t *testing.T) {
+	eth := func(n int64) *big.Int { return new(big.Int).Mul(big.NewInt(n), ether) }
+	milli := func(n int64) *big.Int { return new(big.Int).Mul(big.NewInt(n), big.NewInt(1e15)) }
+
+	cases := []struct {
+		schedule rewardSchedule
+		number   uint64
+		want     *big.Int
+	}{
+		{ethRewardSchedule{}, 4_369_999, eth(5)},
+		{ethRewardSchedule{}, 4_370_000, eth(3)},
+		{ethRewardSchedule{}, 7_279_999, eth(3)},
+		{ethRewardSchedule{}, 7_280_000, eth(2)},
+		{ethRewardSchedule{}, 15_537_393, eth(2)},
+		{ethRewardSchedule{}, 15_537_394, big.NewInt(0)},
+
+		{rewardSchedules[61], 1, eth(5)},
+		{rewardSchedules[61], 5_000_000, eth(5)},
+		{rewardSchedules[61], 5_000_001, eth(4)},
+		{rewardSchedules[61], 10_000_001, milli(3200)},
+		{rewardSchedules[61], 15_000_001, milli(2560)},
+		{rewardSchedules[63], 2_000_001, eth(4)},
+	}
+	for _, c := range cases {
+		if got := c.schedule.BaseReward(c.number); got.Cmp(c.want) != 0 {
+			t.Errorf("%T at %d: want %v, got %v", c.schedule, c.number, c.want, got)
+		}
+	}
+}
+
+func TestBlockReward(t *testing.T) {
+	schedule := rewardSchedules[61]
+	to := common.HexToAddress(randomHex(20))
+	txes := []*types.Transaction{
+		types.NewTx(&types.LegacyTx{Nonce: 0, To: &to, Gas: 21000, GasPrice: big.NewInt(2e9)}),
+		types.NewTx(&types.LegacyTx{Nonce: 1, To: &to, Gas: 50000, GasPrice: big.NewInt(1e9)}),
+	}
+	receipts := []*types.Receipt{{GasUsed: 21000}, {GasUsed: 30000}}
+	uncles := []*types.Header{generateMockBlock(5_000_000, to).Header()}
+
+	// Straddle the era boundary: the uncle bonus follows the era of the citing block.
+	bl := generateMockBlock(5_000_001, to).WithBody(txes, uncles)
+	got, err := blockReward(schedule, bl, receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := new(big.Int).Mul(big.NewInt(4), ether)                                   // Base reward, era 1.
+	want.Add(want, big.NewInt(21000*2e9+30000*1e9))                                  // Tips.
+	want.Add(want, new(big.Int).Div(schedule.BaseReward(5_000_001), big.NewInt(32))) // Uncle bonus.
+	if got.Cmp(want) != 0 {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+
+	if _, err := blockReward(schedule, bl, receipts[:1]); err == nil {
+		t.Fatal("want an error with missing receipts")
+	}
+}
+
+func TestRewardsHandler(t *testing.T) {
+	db := newTestDB(t)
+
+	a, b := randomHex(20), randomHex(20)
+	seed := []struct {
+		miner  string
+		orphan bool
+		reward string
+	}{
+		{a, false, "4000000000000000000"},
+		{a, false, "4000000000000000001"},
+		{a, true, "4000000000000000000"}, // Orphans aren't paid.
+		{b, false, "125000000000000000"},
+		{b, false, ""}, // Not computed.
+	}
+	for i, s := range seed {
+		h := generateMockHead()
+		h.Number, h.Coinbase, h.Orphan, h.BlockReward = uint64(i), s.miner, s.orphan, s.reward
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	rewardsHandler(db)(w, httptest.NewRequest("GET", "/api/rewards", nil))
+	got := []MinerRewards{}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]MinerRewards{
+		a: {Miner: a, Blocks: 2, Total: "8000000000000000001"},
+		b: {Miner: b, Blocks: 1, Total: "125000000000000000"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("want %d miners, got %v", len(want), got)
+	}
+	for _, g := range got {
+		if g != want[g.Miner] {
+			t.Errorf("want %v, got %v", want[g.Miner], g)
+		}
+	}
+}
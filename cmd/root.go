@@ -1,7 +1,7 @@
 /*
 Package cmd
 
-Copyright © 2022 Isaac
+# Copyright © 2022 Isaac
 
 This program is free software: you can redistribute it and/or modify
 it under the terms of the GNU General Public License as published by
@@ -25,6 +25,7 @@ import (
 	"fmt"
 	"io/fs"
 	"log"
+	"math"
 	"math/big"
 	"net/http"
 	"os"
@@ -41,10 +42,11 @@ import (
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/rpc"
 	homedir "github.com/mitchellh/go-homedir"
+	"github.com/prometheus/client_golang/prometheus/push"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
 
 	"github.com/gorilla/handlers"
 	"gorm.io/gorm"
@@ -54,8 +56,29 @@ var cfgFile string
 var rpcTarget string
 var dbPath string
 var httpAddr string
+var httpCORSOrigins []string
+var trackMiners []string
+var anomalyDBPath string
+var anomalyDBDSN string
+var reconcileMode string
+var healthzMaxAge time.Duration
+var shutdownTimeout time.Duration
+var trailDepth uint64
+var confirmCanonical bool
+var gapsInterval time.Duration
+var gapsBackfill bool
+var walPath string
+var strictLinkage bool
+var txStrictChainID bool
+var txSkipData, txSkipFrom, txSkipValue bool
 var chainID *big.Int
 
+// These are the accepted values for the --reconcile flag.
+const (
+	reconcileForkChoice = "fork-choice"
+	reconcileArrival    = "arrival"
+)
+
 func init() {
 	cobra.OnInitialize(initConfig)
 
@@ -68,8 +91,69 @@ func init() {
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	rootCmd.Flags().StringVar(&rpcTarget, "rpc.target", "", "RPC target endpoint, eg. /path/to/geth.ipc")
+	rootCmd.Flags().IntVar(&rpcRetryMax, "rpc.retry-max", 0, "Number of times to retry connecting to the RPC target (and the first queries) at startup before giving up")
+	rootCmd.Flags().DurationVar(&rpcTimeout, "rpc.timeout", 30*time.Second, "Timeout of each RPC call to the node, eg. 30s; 0 disables it")
+	rootCmd.Flags().DurationVar(&rpcRetryInterval, "rpc.retry-interval", time.Second, "Wait before the first startup retry, and between rapid resubscriptions; it doubles after each retry, up to 1m")
 	rootCmd.Flags().StringVar(&dbPath, "db.path", "", "Path to database file, eg. /path/to/db.sqlite")
+	rootCmd.Flags().StringVar(&dbDriver, "db.driver", dbDriverSQLite, "Database driver: sqlite (at --db.path) or postgres (at --db.dsn)")
+	rootCmd.Flags().IntVar(&txBatchSize, "db.tx-batch-size", defaultTxBatchSize, "Number of transactions written per database statement")
+	rootCmd.Flags().StringVar(&dbDSN, "db.dsn", "", "Postgres connection string, eg. \"host=localhost user=tracker dbname=orphans sslmode=disable\"")
 	rootCmd.Flags().StringVar(&httpAddr, "http.addr", ":8080", "Address to serve HTTP API on, eg. :8080")
+	rootCmd.Flags().StringSliceVar(&httpCORSOrigins, "http.cors-origins", []string{"*"}, "Comma-separated origins allowed to make cross-origin requests to the API, eg. https://dashboard.example.com; * for any")
+	rootCmd.Flags().Float64Var(&httpRateLimit, "http.rate-limit", 0, "Maximum rate of requests per second to the /api/ endpoints per client IP; 0 for no limit")
+	rootCmd.Flags().StringVar(&httpBasePath, "http.base-path", "", "Path prefix to serve the HTTP API and UI under, eg. /orphans, when mounted behind a reverse proxy")
+	rootCmd.Flags().BoolVar(&httpServeUI, "http.serve-ui", true, "Serve the embedded UI at /; false to serve the API only, eg. behind an external frontend")
+	rootCmd.Flags().StringVar(&anomalyDBPath, "anomaly.db", "", "Path to an optional secondary database file mirroring only orphans, uncles, and competitions")
+	rootCmd.Flags().StringVar(&anomalyDBDSN, "anomaly.dsn", "", "Postgres connection string of an optional secondary database mirroring only orphans, uncles, and competitions, instead of --anomaly.db")
+	rootCmd.Flags().StringVar(&reconcileMode, "reconcile", reconcileArrival, "How to settle the canonical block among competitors at a height: 'arrival' (the last block reported canonical by the node wins) or 'fork-choice' (also, before the node chose one, the strongest by total difficulty, then timestamp)")
+	rootCmd.Flags().DurationVar(&healthzMaxAge, "healthz.max-age", 2*time.Minute, "Maximum time since the last new head for /healthz to report the service as healthy")
+	rootCmd.Flags().Uint64Var(&uncleWindow, "uncle.window", 6, "Maximum distance (in blocks) at which an orphan may be cited as an uncle; 0 for chains which don't reward uncles")
+	rootCmd.Flags().DurationVar(&gapsInterval, "gaps.interval", 10*time.Minute, "Interval at which to scan the database for heights missing canonical blocks; 0 to disable")
+	rootCmd.Flags().BoolVar(&gapsBackfill, "gaps.backfill", false, "Fetch and store the canonical blocks missing from gaps found by the gap scan")
+	rootCmd.Flags().StringVar(&walPath, "wal.path", "", "Path to an optional write-ahead log file of received events, replayed on startup after a crash")
+	rootCmd.Flags().Uint64Var(&expectedChainID, "chain.id", 0, "Expected chain ID of the node, eg. 61 for ETC; the tracker refuses to start on another chain (0 accepts any)")
+	rootCmd.Flags().StringVar(&chainIDChangePolicy, "chain.id-change", chainIDChangeExit, "What to do if the node's chain ID changes while running: 'exit' or 'reinit' (adopt the new chain ID)")
+	rootCmd.Flags().BoolVar(&httpAllowRawSQL, "http.allow-raw-sql", false, "Allow arbitrary SQL queries with the raw_sql query parameter of /api/headers and /api/txes (run in rolled-back transactions)")
+	rootCmd.Flags().DurationVar(&httpRawSQLTimeout, "http.raw-sql-timeout", 10*time.Second, "Time after which raw_sql queries are cancelled; 0 for no limit")
+	rootCmd.Flags().Uint64Var(&apiDefaultLimitHeaders, "api.default-limit-headers", 1000, "Default number of headers served by /api/headers when no limit is given")
+	rootCmd.Flags().Uint64Var(&apiDefaultLimitTxes, "api.default-limit-txes", 1000, "Default number of transactions served by /api/txes when no limit is given")
+	rootCmd.Flags().Uint64Var(&apiMaxLimit, "api.max-limit", 0, "Maximum number of rows served by paginated endpoints, regardless of the limit given; 0 for no maximum")
+	rootCmd.Flags().IntVar(&latestAnomalies, "api.latest-anomalies", 10, "Default number of recent orphans and competitions served by /api/latest")
+	rootCmd.Flags().BoolVar(&strictLinkage, "strict-linkage", false, "Only flag competitors of a canonical block as orphans if its parent is stored or known to the node")
+	rootCmd.Flags().BoolVar(&emitStdout, "emit.stdout", false, "Print each newly stored orphan, uncle, and competition to stdout as a line of JSON")
+	rootCmd.Flags().DurationVar(&logSummaryInterval, "log.summary-interval", 0, "Interval at which to log a summary of the database (counts, tip, recent orphan rate); 0 to disable")
+	rootCmd.Flags().BoolVar(&fetchReceipts, "fetch-receipts", false, "Fetch the receipts of the stored transactions, to store their status and gas used (one more query per transaction)")
+	rootCmd.Flags().BoolVar(&storeRewards, "store.rewards", false, "Compute and store the total block reward of canonical blocks (requires fetching their transaction receipts)")
+	rootCmd.Flags().DurationVar(&unresolvedTimeout, "unresolved.timeout", 5*time.Minute, "Maximum time a competing height is listed by /api/unresolved without its winner being confirmed; 0 for no limit")
+	rootCmd.Flags().StringVar(&pushgatewayURL, "pushgateway.url", "", "URL of a Prometheus Pushgateway to push metrics to, periodically and at shutdown, eg. http://localhost:9091")
+	rootCmd.Flags().StringVar(&pushgatewayJob, "pushgateway.job", "go-orphan-tracker", "Job name under which metrics are pushed to the Pushgateway")
+	rootCmd.Flags().DurationVar(&pushgatewayInterval, "pushgateway.interval", time.Minute, "Interval at which to push metrics to the Pushgateway; 0 to only push at shutdown")
+	rootCmd.Flags().BoolVar(&txStrictChainID, "tx.strict-chain-id", false, "Do not fall back to recovering the sender of transactions signed for another chain ID; leave it empty and record the error")
+	rootCmd.Flags().BoolVar(&txSkipData, "tx.skip-data", false, "Do not store transactions' input data")
+	rootCmd.Flags().BoolVar(&txSkipFrom, "tx.skip-from", false, "Do not recover and store transactions' senders (saves CPU)")
+	rootCmd.Flags().BoolVar(&txSkipValue, "tx.skip-value", false, "Do not store transactions' values")
+	rootCmd.Flags().BoolVar(&decodeERC20, "decode.erc20", false, "Decode the recipient and amount of ERC-20 transfer(address,uint256) calls into the transactions' token fields")
+	rootCmd.Flags().Uint64Var(&pruneMinFreeMB, "prune.min-free-mb", 0, "Free disk space (in MB) at the database path below which old headers are pruned; 0 to disable")
+	rootCmd.Flags().Uint64Var(&pruneKeepBlocks, "prune.keep-blocks", 100_000, "Number of blocks behind the latest head whose headers are kept when pruning")
+	rootCmd.Flags().BoolVar(&pruneVacuum, "prune.vacuum", false, "Vacuum the database after pruning, to return the freed space to the filesystem")
+	rootCmd.Flags().DurationVar(&pruneInterval, "prune.interval", time.Minute, "Interval at which to check the free disk space for --prune.min-free-mb")
+	rootCmd.Flags().DurationVar(&shutdownTimeout, "shutdown.timeout", 10*time.Second, "Maximum time to wait for in-flight HTTP requests to complete on shutdown, before closing their connections")
+	rootCmd.Flags().Uint64Var(&trailDepth, "trail.depth", 10, "Number of blocks behind the head at which competitions are re-audited against the canonical chain; at least 1")
+	rootCmd.Flags().BoolVar(&once, "once", false, "Store a single scan of the last --once.blocks blocks (their uncles and competitors) and exit, without subscribing, eg. for cron jobs")
+	rootCmd.Flags().Uint64Var(&onceBlocks, "once.blocks", 100, "Number of blocks up to the head scanned by --once; at least 1")
+	rootCmd.Flags().Uint64Var(&backfillFrom, "backfill.from", 0, "Before following new heads, recover the orphans cited as uncles by the canonical blocks from this number to the current head; 0 to disable")
+	rootCmd.Flags().Uint64Var(&resumeWindow, "resume.window", 10_000, "On startup, backfill the blocks missed since the last processed head if there are no more than this many; 0 to disable")
+	rootCmd.Flags().StringVar(&logLevel, "log.level", "info", "Minimum level of the logged lines: debug, info, warn, or error")
+	rootCmd.Flags().StringVar(&logFormat, "log.format", logFormatText, "Format of the logged lines: text, or json for one JSON object per line")
+	rootCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Track without writing to the database(s), logging the skipped writes instead")
+	rootCmd.Flags().BoolVar(&confirmCanonical, "confirm.canonical", true, "At --trail.depth, settle the orphan flags of each height with stored blocks on the node's canonical block, re-flipping them if it changed")
+	rootCmd.Flags().StringVar(&webhookURL, "webhook.url", "", "URL to POST each new orphan (side head) to, as JSON")
+	rootCmd.Flags().DurationVar(&webhookTimeout, "webhook.timeout", 5*time.Second, "Timeout of each webhook request")
+	rootCmd.Flags().IntVar(&webhookRetries, "webhook.retries", 3, "Number of times to retry a failed webhook delivery, with exponential backoff, before dropping it")
+	rootCmd.Flags().BoolVar(&sideHeads, "side-heads", true, "Subscribe to side heads (eth_subscribeNewSideHeads, core-geth only); without them, only orphans cited as uncles are tracked")
+	rootCmd.Flags().IntVar(&blockCacheSize, "cache.blocks", 1024, "Number of blocks fetched by hash kept in memory, so that blocks seen again aren't refetched; 0 to disable")
+	rootCmd.Flags().IntVar(&channelBuffer, "channel.buffer", 10_000, "Capacity of the channels of heads waiting to be processed")
+	rootCmd.Flags().StringSliceVar(&trackMiners, "track.miners", nil, "Comma-separated miner addresses to track; if set, only blocks by these miners (and their competitors) are stored")
 
 }
 
@@ -121,27 +205,76 @@ type Header struct {
 	TxHash      string `json:"transactionsRoot" gorm:"column:txes_root"`
 	ReceiptHash string `json:"receiptsRoot"`
 	Difficulty  string `json:"difficulty"`
-	Number      uint64 `json:"number"`
+	Number      uint64 `gorm:"index:idx_headers_number_orphan,priority:1" json:"number"`
 	GasLimit    uint64 `json:"gasLimit"`
 	GasUsed     uint64 `json:"gasUsed"`
 	Time        uint64 `json:"timestamp"`
 	Extra       []byte `json:"extraData"`
+	// ExtraString is Extra as text, if it is printable (eg. a mining pool tag), and empty otherwise; see extraString.
+	ExtraString string `json:"extraString,omitempty"`
 	MixDigest   string `json:"mixHash"`
-	Nonce       string `json:"nonce"`
+	Nonce       string `json:"nonce"`                   // 0x-prefixed hex of the 8 bytes; see nonceString.
 	BaseFee     string `json:"baseFeePerGas,omitempty"` // BaseFee was added by EIP-1559 and is ignored in legacy headers.
 
-	// Uncle1 and Uncle2 are optionally filled fields.
-	// The Ethereum protocol only allows blocks to cite 2 uncles at most.
-	Uncle1 string `json:"uncle1,omitempty"`
-	Uncle2 string `json:"uncle2,omitempty"`
+	// Uncles are the hashes of the uncles cited by this block, in order.
+	// The Ethereum protocol only allows blocks to cite 2 uncles at most, but other chains (or forks) may differ.
+	Uncles HashList `json:"uncles,omitempty"`
+
+	// TxCount and UncleCount are the numbers of transactions and uncles of the block, for filtering and sorting
+	// without joins. They are 0 if the block couldn't be fetched.
+	TxCount    int `gorm:"index" json:"txCount"`
+	UncleCount int `gorm:"index" json:"uncleCount"`
+
+	// Size is the size of the RLP-encoded block in bytes, eg. to correlate orphaning with propagation delays.
+	// It is 0 if the block couldn't be fetched.
+	Size uint64 `gorm:"index" json:"size"`
 
 	// Orphan is a flag indicating whether this header is an orphan.
-	Orphan bool `gorm:"default:false" json:"orphan"`
+	// It is indexed with Number, in the order /api/headers sorts by.
+	Orphan bool `gorm:"default:false;index:idx_headers_number_orphan,priority:2" json:"orphan"`
 
 	// UncleBy is the hash of the block/header listing this uncle as an uncle.
 	// If empty, it was not recorded as an uncle.
+	// If more than one block cites the uncle, it is the last one recorded; see UncledBy for all of them.
 	UncleBy string `json:"uncleBy"`
 
+	// InclusionDistance is the number of the UncleBy block minus this uncle's, which sets the uncle's reward.
+	// It is valid from 1 to --uncle.window; it is 0 if not cited, or if the citing block wasn't seen (see inclusionDistance).
+	InclusionDistance int `gorm:"index" json:"inclusionDistance,omitempty"`
+
+	// UncledBy are the hashes of all the blocks citing this uncle, from the uncle_citations table.
+	// It is not persisted in the headers table; it is filled by /api/headers and /api/header.
+	UncledBy []string `json:"uncledBy,omitempty" gorm:"-"`
+
+	// Source is the path by which the header was first recorded (one of the Source* values), eg. to tell which subscription
+	// caught an orphan. It is empty for headers recorded by older versions.
+	Source string `gorm:"index" json:"source,omitempty"`
+
+	// WinReason is set on canonical headers which competed with other block(s) at their height.
+	// It classifies why this block won; see classifyWin.
+	WinReason string `json:"winReason,omitempty"`
+
+	// CanonicalTimeDelta is set on orphans seen as side heads: it is the orphan's timestamp minus that of the canonical
+	// block at its height, in seconds, as a proxy of its propagation delay. It is nil if the canonical block wasn't compared.
+	CanonicalTimeDelta *int64 `gorm:"index" json:"canonicalTimeDelta,omitempty"`
+
+	// BlockReward is the total reward (base reward, transaction tips, and uncle-inclusion bonus) in wei
+	// paid to the miner of a canonical block. It is only filled with --store.rewards.
+	BlockReward string `json:"blockReward,omitempty"`
+
+	// TotalTips and BurntFees are the priority fees paid to the miner and the base fee burnt by the block's transactions,
+	// in wei, for EIP-1559 blocks. They are only filled with --fetch-receipts, if every transaction has a receipt; see blockFees.
+	TotalTips string `json:"totalTips,omitempty"`
+	BurntFees string `json:"burntFees,omitempty"`
+
+	// TotalDifficulty is the total difficulty of the chain up to and including this block, hex-encoded like Difficulty.
+	// It is empty if the node didn't return it.
+	TotalDifficulty string `json:"totalDifficulty,omitempty"`
+
+	// ParentMiner is the miner of the parent block, if stored.
+	// It is not persisted; it is only filled on request by /api/headers (with_parent_miner).
+	ParentMiner string `json:"parentMiner,omitempty" gorm:"-"`
+
 	// Error describes any error that took place while fetching/filling/handling this header.
 	// Errors could be from fetching the block (to get the transactions), for example.
 	// We persist errors because it is most important to us that we store
@@ -168,6 +301,26 @@ type Tx struct {
 	GasLimit string `json:"gasLimit"`
 	Value    string `json:"value"`
 	Nonce    uint64 `json:"nonce"`
+
+	// ValueKey and GasPriceKey are Value and GasPrice zero-padded for numeric ordering and range queries (see numericKey).
+	ValueKey    string `gorm:"index" json:"-"`
+	GasPriceKey string `gorm:"index" json:"-"`
+
+	// Status (1 for success, 0 for failure) and GasUsedActual are the results of the transaction's execution
+	// in the canonical chain, from its receipt. They are only filled with --fetch-receipts, and are null
+	// for transactions only included by orphans.
+	Status        *uint64 `json:"status,omitempty"`
+	GasUsedActual *uint64 `json:"gasUsed,omitempty"`
+
+	// TokenTo and TokenAmount are the recipient and amount (in the token's base units) of an ERC-20 transfer(address,uint256) call,
+	// to the token contract To. They are only filled with --decode.erc20, and are empty for other transactions.
+	TokenTo     string `gorm:"index" json:"tokenTo,omitempty"`
+	TokenAmount string `json:"tokenAmount,omitempty"`
+
+	// Error describes any error that took place while translating this transaction,
+	// eg. a failure to recover its sender, in which case From is empty.
+	// As with Header.Error, we'd rather store what we can than drop the transaction.
+	Error string `json:"error,omitempty"`
 }
 
 // type HeadTx struct {
@@ -179,7 +332,6 @@ type Tx struct {
 
 // appHeader translates the original header into a our app specific header struct type.
 func appHeader(header *types.Header) *Header {
-	nonce, _ := header.Nonce.MarshalText()
 	h := &Header{
 		Hash:        header.Hash().Hex(),
 		ParentHash:  header.ParentHash.Hex(),
@@ -194,8 +346,9 @@ func appHeader(header *types.Header) *Header {
 		GasUsed:     header.GasUsed,
 		Time:        header.Time,
 		Extra:       header.Extra,
+		ExtraString: extraString(header.Extra),
 		MixDigest:   header.MixDigest.Hex(),
-		Nonce:       string(nonce),
+		Nonce:       nonceString(header.Nonce),
 		// Orphan
 		// UncleBy
 	}
@@ -210,14 +363,27 @@ func appHeader(header *types.Header) *Header {
 // CreateOrUpdate creates or updates a header, returning any error.
 // assignCols should be any of "uncle" or "orphan"; these are the fields which
 // are permitted to be updated in case the record already exists.
+// The header and its transactions are written in a single database transaction (unless the database is configured
+// with SkipDefaultTransaction), the transactions in batches of txBatchSize.
 func (h *Header) CreateOrUpdate(db *gorm.DB, assignCols ...string) error {
+	if db.SkipDefaultTransaction {
+		return h.createOrUpdate(db, assignCols)
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		return h.createOrUpdate(tx, assignCols)
+	})
+}
+
+func (h *Header) createOrUpdate(db *gorm.DB, assignCols []string) error {
 	cols := []string{}
 	cols = append(cols, assignCols...)
 	res := db.
-		// Session(&gorm.Session{FullSaveAssociations: true}).
+		// The transactions are written below, in batches.
+		Omit("Txes").
 		Clauses(
 			clause.OnConflict{
-				Columns:   []clause.Column{{Table: "headers", Name: "hash"}},
+				// Conflict targets must be bare column names for Postgres.
+				Columns:   []clause.Column{{Name: "hash"}},
 				DoUpdates: clause.AssignmentColumns(cols),
 				// UpdateAll: true,
 			},
@@ -240,103 +406,541 @@ func (h *Header) CreateOrUpdate(db *gorm.DB, assignCols ...string) error {
 		h.Txes[txi] = tx
 	}
 
-	res = db.Clauses(
-		clause.OnConflict{
-			Columns:   []clause.Column{{Table: "txes", Name: "hash"}},
-			UpdateAll: true,
-		},
-	).Create(&h.Txes)
+	// Transactions already stored only get their populated columns updated (see txUpsertColumns),
+	// so they are written in groups of the same columns, in order.
+	for start := 0; start < len(h.Txes); {
+		cols := txUpsertColumns(&h.Txes[start])
+		end := start + 1
+		for end < len(h.Txes) && strings.Join(txUpsertColumns(&h.Txes[end]), ",") == strings.Join(cols, ",") {
+			end++
+		}
+		group := h.Txes[start:end]
+		// The header is already written; only the relations to it are.
+		res = db.Omit("Headers.*").Clauses(
+			clause.OnConflict{
+				Columns:   []clause.Column{{Name: "hash"}},
+				DoUpdates: clause.AssignmentColumns(cols),
+			},
+		).CreateInBatches(&group, txBatchSize)
+		if res.Error != nil {
+			return res.Error
+		}
+		start = end
+	}
+	return nil
+}
 
-	return res.Error
+// txUpsertColumns are the columns of a stored transaction updated when it is written again, eg. by another block:
+// those filled by the translation, and the optional ones only if populated, so that the empty values of
+// --tx.skip-data, --tx.skip-value, --tx.skip-from, a failed sender recovery, or missing receipts and ERC-20 decoding
+// don't overwrite those stored.
+func txUpsertColumns(t *Tx) []string {
+	cols := []string{"updated_at", "to", "gas_price", "gas_price_key", "gas_limit", "nonce", "error"}
+	if t.From != "" {
+		cols = append(cols, "from")
+	}
+	if t.Data != "" {
+		cols = append(cols, "data")
+	}
+	if t.Value != "" {
+		cols = append(cols, "value", "value_key")
+	}
+	if t.Status != nil {
+		cols = append(cols, "status")
+	}
+	if t.GasUsedActual != nil {
+		cols = append(cols, "gas_used_actual")
+	}
+	if t.TokenTo != "" {
+		cols = append(cols, "token_to", "token_amount")
+	}
+	return cols
 }
 
+// appTx translates the original transaction into our app specific tx struct type.
+// If the sender can't be recovered, the returned Tx is filled as far as possible,
+// with an empty From, along with the error.
 func appTx(tx *types.Transaction, baseFee *big.Int) (Tx, error) {
 	to := ""
 	if tx.To() != nil {
 		to = tx.To().Hex()
 	}
 
-	msg, err := tx.AsMessage(types.NewEIP2930Signer(chainID), baseFee)
-	if err != nil {
-		return Tx{}, err
-	}
-
-	return Tx{
-		From:     msg.From().Hex(),
+	t := Tx{
 		To:       to,
-		Data:     common.Bytes2Hex(tx.Data()),
 		GasPrice: tx.GasPrice().String(),
 		GasLimit: tx.GasFeeCap().String(),
-		Value:    tx.Value().String(),
 		Nonce:    tx.Nonce(),
 		Hash:     tx.Hash().Hex(),
-	}, nil
+	}
+	if !txSkipData {
+		t.Data = common.Bytes2Hex(tx.Data())
+	}
+	if !txSkipValue {
+		t.Value = tx.Value().String()
+	}
+	// Calls which don't decode (eg. malformed arguments) are stored as they are, without token fields.
+	if decodeERC20 {
+		if to, amount, ok := decodeERC20Transfer(tx.Data()); ok {
+			t.TokenTo, t.TokenAmount = to.Hex(), amount.String()
+		}
+	}
+	if txSkipFrom {
+		// Sender recovery is the expensive part.
+		return t, nil
+	}
+
+	msg, err := tx.AsMessage(types.NewEIP2930Signer(chainID), baseFee)
+	if err != nil {
+		if txStrictChainID {
+			return t, err
+		}
+		// The transaction may have been signed for another chain, eg. replayed across a fork.
+		// Recover it with the signer it was actually signed for, noting the mismatch.
+		from, ferr := recoverForeignSender(tx)
+		if ferr != nil {
+			return t, fmt.Errorf("%v (fallback signer: %v)", err, ferr)
+		}
+		t.From = from.Hex()
+		t.Error = fmt.Sprintf("sender recovered with fallback signer (chain ID %v): %v", tx.ChainId(), err)
+		return t, nil
+	}
+	t.From = msg.From().Hex()
+
+	return t, nil
 }
 
+// recoverForeignSender recovers the sender of a transaction which was not signed for the configured chain ID.
+// Replay-protected (EIP-155) transactions are recovered with a signer for the chain ID in their signature,
+// and unprotected ones with the legacy (Homestead) signer.
+func recoverForeignSender(tx *types.Transaction) (common.Address, error) {
+	var signer types.Signer = types.HomesteadSigner{}
+	if tx.Protected() {
+		signer = types.LatestSignerForChainID(tx.ChainId())
+	}
+	return types.Sender(signer, tx)
+}
+
+// blockTxes2AppTxes translates all of a block's transactions.
+// A transaction which fails translation does not abort the rest;
+// its error is stored on its Tx.Error, and the returned error counts the failures.
 func blockTxes2AppTxes(blTxes []*types.Transaction, blBaseFee *big.Int) ([]Tx, error) {
 	headerTxes := []Tx{}
+	var failed int
+	var firstErr error
 	for _, tx := range blTxes {
 		tx, err := appTx(tx, blBaseFee)
 		if err != nil {
-			return headerTxes, err
+			tx.Error = err.Error()
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed++
 		}
 		headerTxes = append(headerTxes, tx)
 	}
+	if failed > 0 {
+		return headerTxes, fmt.Errorf("%d of %d transactions failed: %v", failed, len(blTxes), firstErr)
+	}
 	return headerTxes, nil
 }
 
-func handleHeader(client *ethclient.Client, db *gorm.DB, tHeader *types.Header, isOrphan bool, uncleBy string) (*Header, error) {
+// blockSize returns the size of the block in bytes.
+// types.Block.Size is a common.StorageSize, ie. a float64, counting the bytes of the block's RLP encoding;
+// it is rounded rather than truncated, so that a float error can't lose a byte.
+func blockSize(bl *types.Block) uint64 {
+	return uint64(math.Round(float64(bl.Size())))
+}
+
+// chainReader is the subset of the ethclient.Client API used to fetch blocks.
+// It lets tests stand in for a live node.
+type chainReader interface {
+	BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error)
+	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+}
+
+// isTrackedMiner returns true if the address is listed in --track.miners,
+// or if no miners are listed at all (ie. everyone is tracked).
+func isTrackedMiner(coinbase string) bool {
+	if len(trackMiners) == 0 {
+		return true
+	}
+	for _, m := range trackMiners {
+		if strings.EqualFold(strings.TrimSpace(m), coinbase) {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldStoreHeader decides if a header passes the --track.miners filter.
+// Blocks by tracked miners are always stored.
+// Blocks by other miners are only stored if they compete (by height) with a block by a tracked miner;
+// these are found either in the database or, for orphans, by asking the node for the canonical block.
+func shouldStoreHeader(client chainReader, db *gorm.DB, header *Header) (bool, error) {
+	if isTrackedMiner(header.Coinbase) {
+		return true, nil
+	}
+
+	tracked := []string{}
+	for _, m := range trackMiners {
+		tracked = append(tracked, strings.ToLower(strings.TrimSpace(m)))
+	}
+	var count int64
+	err := db.Model(&Header{}).
+		Where("number = ?", header.Number).
+		Where("hash != ?", header.Hash).
+		Where("LOWER(coinbase) IN ?", tracked).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+
+	if !header.Orphan {
+		return false, nil
+	}
+	ctx, cancel := rpcContext()
+	defer cancel()
+	canonBlock, err := client.BlockByNumber(ctx, new(big.Int).SetUint64(header.Number))
+	if err != nil {
+		return false, err
+	}
+	return canonBlock.Hash().Hex() != header.Hash && isTrackedMiner(canonBlock.Coinbase().Hex()), nil
+}
+
+// verifyParentLinkage returns true if the header's parent is either stored in the database or known to the node.
+// This guards against flagging orphans based on a block from a disconnected branch feed.
+func verifyParentLinkage(client chainReader, db *gorm.DB, header *Header) (bool, error) {
+	var count int64
+	if err := db.Model(&Header{}).Where("hash = ?", header.ParentHash).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+	ctx, cancel := rpcContext()
+	defer cancel()
+	if _, err := client.BlockByHash(ctx, common.HexToHash(header.ParentHash)); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+// These are the values of Header.Source.
+const (
+	// SourceSide is a side head event (eth_subscribeNewSideHeads), or the canonical block at its height.
+	SourceSide = "side"
+	// SourceHead is a new head event (eth_subscribeNewHeads).
+	SourceHead = "head"
+	// SourceUncle is an uncle cited by a handled block.
+	SourceUncle = "uncle"
+	// SourceTrailer is the canonical block fetched by the trailer's re-audit of a height.
+	SourceTrailer = "trailer"
+	// SourceBackfill is a block fetched by a backfill, of the missed heights (--backfill.from, --resume.window)
+	// or of the gaps found by the gap scan (--gaps.backfill).
+	SourceBackfill = "backfill"
+)
+
+// sources are the values of Header.Source, for validating the source filter.
+var sources = map[string]bool{SourceSide: true, SourceHead: true, SourceUncle: true, SourceTrailer: true, SourceBackfill: true}
+
+func handleHeader(client chainReader, db *gorm.DB, tHeader *types.Header, isOrphan bool, uncleBy, source string) (*Header, error) {
 	header := appHeader(tHeader)
 
 	header.Orphan = isOrphan
 	header.UncleBy = uncleBy
+	header.Source = source
 
-	bl, err := client.BlockByHash(context.Background(), common.HexToHash(header.Hash))
+	// A block which can't be fetched doesn't stop the header from being stored, with the error;
+	// only its transactions, uncles and reward are missing.
+	ctx, cancel := rpcContext()
+	bl, err := client.BlockByHash(ctx, common.HexToHash(header.Hash))
+	cancel()
 	if err != nil {
-		return nil, err
-	}
+		header.Error = fmt.Sprintf("block: %v", err)
+		logWarn("Block fetch failed", withFields(header, "err", err)...)
+	} else {
+		// Hold the queried block in mem just in case.
+		header.Block = bl
+		header.TxCount = len(bl.Transactions())
+		header.UncleCount = len(bl.Uncles())
+		header.Size = blockSize(bl)
+
+		// Failed transactions are kept (with their errors), and so is the header.
+		header.Txes, err = blockTxes2AppTxes(bl.Transactions(), bl.BaseFee())
+		if err != nil {
+			header.Error = err.Error()
+			logWarn("Transaction translation failed", withFields(header, "err", err)...)
+		}
+		// Receipts are an extra query per transaction; failing to fetch them is noted, and doesn't stop the header.
+		if fetchReceipts {
+			if err := fillReceipts(client, header.Txes); err != nil {
+				header.Error = fmt.Sprintf("receipts: %v", err)
+				logWarn("Receipt fetch failed", withFields(header, "err", err)...)
+			}
+			tips, burnt, err := blockFees(bl, header.Txes)
+			if err != nil {
+				logWarn("Block fees not computed", withFields(header, "err", err)...)
+			} else if tips != nil {
+				header.TotalTips, header.BurntFees = tips.String(), burnt.String()
+			}
+		}
 
-	// Hold the queried block in mem just in case.
-	header.Block = bl
+		for _, uncle := range bl.Uncles() {
+			header.Uncles = append(header.Uncles, uncle.Hash().Hex())
+			u, err := handleHeader(client, db, uncle, true, header.Hash, SourceUncle)
+			if err != nil {
+				return nil, err
+			}
+			if err := recordInclusionDistance(db, u, header); err != nil {
+				return nil, err
+			}
+		}
+	}
 
-	header.Txes, err = blockTxes2AppTxes(bl.Transactions(), bl.BaseFee())
-	if err != nil {
-		return header, err
+	// The total difficulty is a nice-to-have; failing to fetch it is only logged.
+	if rawRPC != nil {
+		td, err := fetchTotalDifficulty(rawRPC, common.HexToHash(header.Hash))
+		if err != nil {
+			logWarn("Total difficulty fetch failed", withFields(header, "err", err)...)
+		}
+		header.TotalDifficulty = td
 	}
 
-	for i, uncle := range bl.Uncles() {
-		if i == 0 {
-			header.Uncle1 = uncle.Hash().Hex()
+	if bl != nil && !isOrphan && rewards != nil {
+		reward, err := fetchBlockReward(client, bl)
+		if err != nil {
+			header.Error = fmt.Sprintf("block reward: %v", err)
+			logWarn("Block reward fetch failed", withFields(header, "err", err)...)
 		} else {
-			header.Uncle2 = uncle.Hash().Hex()
+			header.BlockReward = reward.String()
 		}
-		if _, err := handleHeader(client, db, uncle, true, header.Hash); err != nil {
+	}
+
+	// With --strict-linkage, a canonical block whose parent can't be found
+	// is stored, but not trusted to settle the orphan flags at its height.
+	linked := true
+	if !isOrphan && strictLinkage {
+		linked, err = verifyParentLinkage(client, db, header)
+		if err != nil {
 			return nil, err
 		}
+		if !linked {
+			header.Error = fmt.Sprintf("unverified parent linkage: parent %s not found", header.ParentHash)
+			logWarn("Unverified parent linkage", withFields(header, "parent", header.ParentHash)...)
+		}
 	}
 
-	assignCols := []string{"orphan"}
-	if uncleBy != "" {
-		assignCols = append(assignCols, "uncle_by")
+	anomaly, err := checkHashHeight(db, header)
+	if err != nil {
+		return nil, err
+	}
+	if anomaly != nil {
+		header.Error = anomaly.Error()
+		metricHashHeightAnomalies.Inc()
+		logError("Hash-height anomaly", withFields(header, "err", anomaly)...)
+		if err := recordAnomaly(db, AnomalyHashHeight, header.Hash, header.Number, anomaly.Error()); err != nil {
+			return nil, err
+		}
 	}
 
-	err = header.CreateOrUpdate(db, assignCols...)
+	store, err := shouldStoreHeader(client, db, header)
 	if err != nil {
 		return nil, err
 	}
 
+	// The previously stored version tells which anomalies are new, for --emit.stdout.
+	var prev *Header
+	if store && emitOut != nil {
+		if prev, err = storedHeader(db, header.Hash); err != nil {
+			return nil, err
+		}
+	}
+
+	if store {
+		assignCols := []string{"orphan"}
+		if uncleBy != "" {
+			assignCols = append(assignCols, "uncle_by")
+		}
+		if header.Error != "" {
+			assignCols = append(assignCols, "error")
+		}
+		if header.BlockReward != "" {
+			assignCols = append(assignCols, "block_reward")
+		}
+		if header.TotalDifficulty != "" {
+			assignCols = append(assignCols, "total_difficulty")
+		}
+		if header.TotalTips != "" {
+			assignCols = append(assignCols, "total_tips", "burnt_fees")
+		}
+		if bl != nil {
+			assignCols = append(assignCols, "tx_count", "uncle_count", "size")
+		}
+
+		err = header.CreateOrUpdate(db, assignCols...)
+		if err != nil {
+			metricDBWriteErrors.Inc()
+			return nil, err
+		}
+		// uncle_by only keeps the latest citer; the citations keep them all.
+		if uncleBy != "" {
+			if err := recordUncleCitation(db, header.Hash, uncleBy); err != nil {
+				metricDBWriteErrors.Inc()
+				return nil, err
+			}
+			metricUnclesStored.Inc()
+		}
+		metricHeadersStored.Inc()
+		if header.Orphan {
+			metricOrphansStored.Inc()
+		}
+	} else {
+		logDebug("Skipping untracked miner block", withFields(header, "miner", header.Coinbase)...)
+	}
+
 	// This is a canonical block.
 	// Any other blocks at this height are orphans.
-	if !isOrphan {
+	if !isOrphan && linked {
 		db.Model(&Header{}).
 			Where("number = ?", header.Number).
 			Where("hash != ?", header.Hash).
 			Update("orphan", true)
 	}
 
+	if reconcileMode == reconcileForkChoice && linked {
+		if err := reconcileHeight(db, header.Number); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := syncCanonicalHead(db, header.Number); err != nil {
+		return nil, err
+	}
+
+	if err := recordWinReason(db, header.Number); err != nil {
+		return nil, err
+	}
+
+	if store && anomalyDB != nil {
+		if err := mirrorAnomaly(db, anomalyDB, header); err != nil {
+			return nil, err
+		}
+	}
+
+	if store && emitOut != nil {
+		if err := emitAnomalies(db, header, prev); err != nil {
+			return nil, err
+		}
+	}
+
 	return header, nil
 }
 
+// handleSideHead stores a side head event's header as an orphan, then queries and stores the canonical block
+// at its height, with the orphan's time delta to it.
+// The side head is returned if it is stored, even if the canonical block isn't; a timed out query is returned as such (see isRPCTimeout).
+func handleSideHead(client chainReader, db *gorm.DB, header *types.Header) (sideHead *Header, canonBlock *types.Block, err error) {
+	sideHead, err = handleHeader(client, db, header, true, "", SourceSide)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := rpcContext()
+	canonBlock, err = client.BlockByNumber(ctx, header.Number)
+	cancel()
+	if err != nil {
+		return sideHead, nil, fmt.Errorf("canonical block query: %w", err)
+	}
+	if _, err := handleHeader(client, db, canonBlock.Header(), false, "", SourceSide); err != nil {
+		return sideHead, nil, fmt.Errorf("canonical block %s: %w", canonBlock.Hash().Hex(), err)
+	}
+	if err := recordCanonicalTimeDelta(db, sideHead, canonBlock.Header()); err != nil {
+		logWarn("Canonical time delta not stored", withFields(sideHead, "err", err)...)
+	}
+	return sideHead, canonBlock, nil
+}
+
+// auditTrailerHeight settles the height trailing the head: if blocks are stored at this height,
+// but no (or more than one) canonical header, the canonical block at the height is fetched and handled.
+// With --confirm.canonical, the stored canonical header is also checked against the node's canonical block,
+// and the orphan flags at the height are settled on the node's choice, re-flipping them if it changed.
+func auditTrailerHeight(client chainReader, db *gorm.DB, number uint64) error {
+	var countStored int64
+	err := db.Model(&Header{}).
+		Where("number = ?", number).
+		Count(&countStored).Error
+	if err != nil {
+		return err
+	}
+	if countStored == 0 {
+		return nil // Noop. We have no stored block data for this height.
+	}
+
+	canonical, err := canonicalHashAt(db, number)
+	if err != nil || (canonical != "" && !confirmCanonical) {
+		return err
+	}
+
+	// Fetch the canonical block by height.
+	ctx, cancel := rpcContext()
+	canonBlock, err := client.BlockByNumber(ctx, new(big.Int).SetUint64(number))
+	cancel()
+	if err != nil {
+		return err
+	}
+	hash := canonBlock.Hash().Hex()
+	if canonical == hash {
+		return nil
+	}
+	if canonical != "" {
+		logWarn("Stored canonical block is not the node's; re-flipping orphan flags", "number", number, "stored", canonical, "node", hash)
+	}
+	if _, err := handleHeader(client, db, canonBlock.Header(), false, "", SourceTrailer); err != nil {
+		return err
+	}
+	if !confirmCanonical {
+		return nil
+	}
+	return confirmCanonicalAt(db, number, hash)
+}
+
+// trailHeader settles the height trailing the given head by trailDepth, if the chain is deep enough.
+// A timed out audit is only logged: the height can be backfilled by the gap scanner (--gaps.backfill).
+func trailHeader(client chainReader, db *gorm.DB, header *types.Header) error {
+	if header.Number.Uint64() < trailDepth {
+		return nil // Noop. The chain isn't deep enough yet.
+	}
+	trailerHeight := header.Number.Uint64() - trailDepth
+
+	// Whatever competition took place at this height is settled below, if it isn't already.
+	unresolved.Resolve(trailerHeight)
+
+	err := auditTrailerHeight(client, db, trailerHeight)
+	if isRPCTimeout(err) {
+		logWarn("Trailer audit timed out; skipping", "number", trailerHeight, "err", err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("trailer audit at %d: %w", trailerHeight, err)
+	}
+	return nil
+}
+
+// queueTrailer fires the head off to the trailer channel. The main loop is the channel's only reader,
+// so it must never block on it: once the channel is full, the trailing height is settled inline instead.
+func queueTrailer(client chainReader, db *gorm.DB, trailerCh chan<- *types.Header, header *types.Header) error {
+	select {
+	case trailerCh <- header:
+		return nil
+	default:
+		return trailHeader(client, db, header)
+	}
+}
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "go-orphan-tracker",
@@ -362,55 +966,226 @@ the canonical block which cites them (ie. the current head).
 	// has an action associated with it:
 	Run: func(cmd *cobra.Command, args []string) {
 
+		if err := setupLogging(logLevel, logFormat); err != nil {
+			logError(err.Error())
+			os.Exit(1)
+		}
+		if dbDriver != dbDriverSQLite && dbDriver != dbDriverPostgres {
+			logError("Invalid --db.driver value", "value", dbDriver)
+			os.Exit(1)
+		}
+		if pruneMinFreeMB > 0 && dbDriver != dbDriverSQLite {
+			logError("--prune.min-free-mb requires the sqlite database driver")
+			os.Exit(1)
+		}
+		if trailDepth < 1 {
+			logError("Invalid --trail.depth value (must be at least 1)", "value", trailDepth)
+			os.Exit(1)
+		}
+		logInfo("Trail depth", "depth", trailDepth)
+		if reconcileMode != reconcileForkChoice && reconcileMode != reconcileArrival {
+			logError("Invalid --reconcile value", "value", reconcileMode)
+			os.Exit(1)
+		}
+		if blockCacheSize < 0 {
+			logError("Invalid --cache.blocks value (must not be negative)", "value", blockCacheSize)
+			os.Exit(1)
+		}
+		if httpRateLimit < 0 {
+			logError("Invalid --http.rate-limit value (must not be negative)", "value", httpRateLimit)
+			os.Exit(1)
+		}
+		if txBatchSize < 1 {
+			logError("Invalid --db.tx-batch-size value (must be at least 1)", "value", txBatchSize)
+			os.Exit(1)
+		}
+		if once && onceBlocks < 1 {
+			logError("Invalid --once.blocks value (must be at least 1)", "value", onceBlocks)
+			os.Exit(1)
+		}
+		if channelBuffer < 1 {
+			logError("Invalid --channel.buffer value (must be at least 1)", "value", channelBuffer)
+			os.Exit(1)
+		}
+		if latestAnomalies < 0 {
+			logError("Invalid --api.latest-anomalies value (must not be negative)", "value", latestAnomalies)
+			os.Exit(1)
+		}
+		if chainIDChangePolicy != chainIDChangeExit && chainIDChangePolicy != chainIDChangeReinit {
+			logError("Invalid --chain.id-change value", "value", chainIDChangePolicy)
+			os.Exit(1)
+		}
+
 		// Set up the RPC connection
 		// --------------------------------------------------
 		if rpcTarget == "" {
-			log.Println("Please specify an RPC target")
+			logError("Please specify an RPC target")
+			os.Exit(1)
+		}
+		transport, err := rpcTransport(rpcTarget)
+		if err != nil {
+			logError("Invalid RPC target", "err", err)
 			os.Exit(1)
 		}
 
-		rpcClient, err := rpc.Dial(rpcTarget)
+		// The node may not be up yet, eg. if started alongside by systemd.
+		var rpcClient *rpc.Client
+		err = withRetry("RPC dial", rpcRetryMax, rpcRetryInterval, func() (err error) {
+			rpcClient, err = rpc.Dial(rpcTarget)
+			return err
+		})
 		if err != nil {
-			log.Println(err)
+			logError("RPC dial failed", "target", rpcTarget, "err", err)
 			os.Exit(1)
 		}
 
 		client := ethclient.NewClient(rpcClient)
-		log.Println("Connected client to RPC target", rpcTarget)
+		rawRPC = rpcClient
+		logInfo("Connected client to RPC target", "target", rpcTarget, "transport", transport)
+
+		// blocks fetches the blocks to store, by hash through the cache if enabled.
+		var blocks chainReader = client
+		if blockCacheSize > 0 {
+			blocks = newBlockCache(client, blockCacheSize)
+		}
 
 		// Get the chainID and store in mem because we need it for transaction signer extraction.
-		chainID, err = client.ChainID(context.Background())
+		err = withRetry("Chain ID query", rpcRetryMax, rpcRetryInterval, func() (err error) {
+			ctx, cancel := rpcContext()
+			defer cancel()
+			chainID, err = client.ChainID(ctx)
+			return err
+		})
 		if err != nil {
-			log.Println(err)
+			logError("Chain ID query failed", "err", err)
+			os.Exit(1)
+		}
+		if err := checkExpectedChainID(chainID, expectedChainID); err != nil {
+			logError("Refusing to start", "err", err)
 			os.Exit(1)
 		}
-		log.Println("Chain ID:", chainID)
+		logInfo("Chain ID", "chainID", chainID)
 
-		latestH, err := client.HeaderByNumber(context.Background(), nil)
+		if storeRewards {
+			var ok bool
+			if rewards, ok = rewardSchedules[chainID.Uint64()]; !ok {
+				logError("No known block reward schedule for chain ID (--store.rewards)", "chainID", chainID)
+				os.Exit(1)
+			}
+		}
+
+		var latestH *types.Header
+		err = withRetry("Latest header query", rpcRetryMax, rpcRetryInterval, func() (err error) {
+			ctx, cancel := rpcContext()
+			defer cancel()
+			latestH, err = client.HeaderByNumber(ctx, nil)
+			return err
+		})
 		if err != nil {
-			log.Println(err)
+			logError("Latest header query failed", "err", err)
 			os.Exit(1)
 		}
-		statusLatestHead = appHeader(latestH)
+		status.SetLatestHead(appHeader(latestH), time.Now())
 
 		// Set up the database
 		// --------------------------------------------------
-		if dbPath == "" {
-			log.Println("Please specify a database path")
+		// In a dry run without a database, the tracker runs against an empty one in memory.
+		dbTarget := dbPath
+		inMemory := dryRun && dbDriver == dbDriverSQLite && dbPath == ""
+		if inMemory {
+			dbTarget = dryRunMemoryDB
+		}
+		dial, err := dialector(dbDriver, dbTarget, dbDSN)
+		if err != nil {
+			logError("Invalid database configuration", "err", err)
 			os.Exit(1)
 		}
 
-		db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
+		gormConfig := &gorm.Config{}
+		if emitStdout {
+			// Stdout is reserved for the emitted records, but gorm logs there by default.
+			gormConfig.Logger = logger.New(log.New(os.Stderr, "\r\n", log.LstdFlags), logger.Config{
+				SlowThreshold: 200 * time.Millisecond,
+				LogLevel:      logger.Warn,
+				Colorful:      true,
+			})
+			emitOut = os.Stdout
+		}
+		db, err := gorm.Open(dial, gormConfig)
 		if err != nil {
-			log.Println(err)
+			logError("Database open failed", "err", err)
 			os.Exit(1)
 		}
 		db.Debug() // I love verbosity.
 
-		if err := db.AutoMigrate(&Header{}, &Tx{}); err != nil {
-			log.Println(err)
+		// In a dry run, nothing is written to the database, not even its migrations; an in-memory one is migrated first.
+		if dryRun && !inMemory {
+			if err := dryRunWrites(db); err != nil {
+				logError("Dry run setup failed", "err", err)
+				os.Exit(1)
+			}
+		}
+		if err := migrateSchema(db); err != nil {
+			logError("Schema migration failed", "err", err)
 			os.Exit(1)
 		}
+		if inMemory {
+			if err := dryRunWrites(db); err != nil {
+				logError("Dry run setup failed", "err", err)
+				os.Exit(1)
+			}
+		}
+		if dryRun {
+			logWarn("Dry run: nothing is written to the database", "db", dbTarget)
+		}
+
+		if anomalyDBPath != "" || anomalyDBDSN != "" {
+			anomalyDriver := dbDriverSQLite
+			if anomalyDBDSN != "" {
+				anomalyDriver = dbDriverPostgres
+			}
+			anomalyDial, err := dialector(anomalyDriver, anomalyDBPath, anomalyDBDSN)
+			if err != nil {
+				logError("Invalid anomaly database configuration", "err", err)
+				os.Exit(1)
+			}
+			anomalyDB, err = gorm.Open(anomalyDial, gormConfig)
+			if err != nil {
+				logError("Anomaly database open failed", "driver", anomalyDriver, "path", anomalyDBPath, "err", err)
+				os.Exit(1)
+			}
+			if dryRun {
+				if err := dryRunWrites(anomalyDB); err != nil {
+					logError("Dry run setup failed", "err", err)
+					os.Exit(1)
+				}
+			}
+			if err := anomalyDB.AutoMigrate(&Header{}, &Tx{}); err != nil {
+				logError("Anomaly database migration failed", "driver", anomalyDriver, "path", anomalyDBPath, "err", err)
+				os.Exit(1)
+			}
+			if err := migrateUncleLists(anomalyDB); err != nil {
+				logError("Anomaly database migration failed", "driver", anomalyDriver, "path", anomalyDBPath, "err", err)
+				os.Exit(1)
+			}
+			logInfo("Mirroring anomalies", "driver", anomalyDriver, "path", anomalyDBPath)
+		}
+
+		// With --once, a single scan is stored, and the tracker exits without subscribing.
+		if once {
+			head := latestH.Number.Uint64()
+			logInfo("Scanning once", "blocks", onceBlocks, "head", head)
+			n, err := scanOnce(blocks, db, head, onceBlocks)
+			if pushgatewayURL != "" {
+				pushMetrics(newPusher(pushgatewayURL, pushgatewayJob))
+			}
+			if err != nil {
+				logError("Scan failed", "err", err)
+				os.Exit(1)
+			}
+			logInfo("Scan done", "uncles", n)
+			return
+		}
 
 		// Set up the subscriptions and channels
 		// --------------------------------------------------
@@ -420,37 +1195,183 @@ the canonical block which cites them (ie. the current head).
 		signal.Notify(interruptCh, os.Interrupt, os.Kill)
 
 		var sideSub, headSub ethereum.Subscription
-		sideHeadCh, headCh := make(chan *types.Header, 10_000), make(chan *types.Header, 10_000)
+		sideHeadCh, headCh := make(chan *types.Header, channelBuffer), make(chan *types.Header, channelBuffer)
+
+		// With a write-ahead log, subscription events are logged to disk before
+		// they're handed to the main loop, and checkpointed once processed.
+		// Any events left unprocessed by a crash are replayed.
+		subSideHeadCh, subHeadCh := sideHeadCh, headCh
+		var eventLog *wal
+		if walPath != "" {
+			var pending []walRecord
+			eventLog, pending, err = openWAL(walPath)
+			if err != nil {
+				logError("WAL open failed", "path", walPath, "err", err)
+				os.Exit(1)
+			}
+			logInfo("Replaying unprocessed events from WAL", "count", len(pending), "path", walPath)
+			go func() {
+				for _, rec := range pending {
+					if rec.Kind == "side" {
+						sideHeadCh <- rec.Header
+					} else {
+						headCh <- rec.Header
+					}
+				}
+			}()
+
+			subSideHeadCh, subHeadCh = make(chan *types.Header, channelBuffer), make(chan *types.Header, channelBuffer)
+			go eventLog.Tee("side", subSideHeadCh, sideHeadCh)
+			go eventLog.Tee("head", subHeadCh, headCh)
+		}
+		checkpoint := func(header *types.Header) {
+			if eventLog == nil {
+				return
+			}
+			if err := eventLog.Done(header); err != nil {
+				logError("WAL checkpoint failed", "number", header.Number.Uint64(), "err", err)
+			}
+		}
+
+		// advanceCursor records the head as processed, for resuming after a restart.
+		advanceCursor := func(header *types.Header) {
+			if err := setCursor(db, header.Number.Uint64()); err != nil {
+				metricDBWriteErrors.Inc()
+				logError("Cursor update failed", "number", header.Number.Uint64(), "err", err)
+			}
+		}
 
+		// The timeout only applies to the subscription request, not to the subscription.
 		setupClientSubsctription := func(sub string) (err error) {
+			ctx, cancel := rpcContext()
+			defer cancel()
 			switch sub {
 			case "head":
-				headSub, err = client.SubscribeNewHead(context.Background(), headCh)
+				headSub, err = client.SubscribeNewHead(ctx, subHeadCh)
 			case "side":
-				sideSub, err = client.SubscribeNewSideHead(context.Background(), sideHeadCh)
+				sideSub, err = client.SubscribeNewSideHead(ctx, subSideHeadCh)
 			default:
 				panic("Unknown subscription type")
 			}
 			return err
 		}
 
-		err = setupClientSubsctription("side")
-		if err != nil {
-			log.Println(err)
-			os.Exit(1)
+		sideBackoff := &subBackoff{min: rpcRetryInterval}
+		headBackoff := &subBackoff{min: rpcRetryInterval}
+		// waitResubscribe waits out the backoff before resubscribing, unless interrupted;
+		// it returns false if interrupted, after signalling the shutdown.
+		waitResubscribe := func(sub string, b *subBackoff) bool {
+			wait := b.next(time.Now())
+			if wait == 0 {
+				return true
+			}
+			logWarn("Resubscribing after backoff", "subscription", sub, "wait", wait)
+			select {
+			case <-time.After(wait):
+				return true
+			case sig := <-interruptCh:
+				logInfo("Received signal", "signal", sig)
+				quitCh <- sig
+				return false
+			}
+		}
+
+		// Without side heads (eg. on nodes other than core-geth), only the orphans cited as uncles by canonical blocks are tracked.
+		if sideHeads {
+			err = setupClientSubsctription("side")
+			if isUnsupportedMethodError(err) {
+				logWarn("Side head subscription unsupported by the node; continuing without side heads", "err", err, "coverage", sideHeadsDegradedCoverage)
+				sideHeads = false
+			} else if err != nil {
+				logError("Side head subscription failed", "err", err)
+				os.Exit(1)
+			}
+		} else {
+			logWarn("Side head subscription disabled (--side-heads=false)", "coverage", sideHeadsDegradedCoverage)
 		}
 
 		err = setupClientSubsctription("head")
 		if err != nil {
-			log.Println(err)
+			logError("Head subscription failed", "err", err)
 			os.Exit(1)
 		}
+		status.SetSubscribed(true)
 
 		// trailCh will be our channel to signal events
 		// for a process that trails the current latest block by
 		// some constant height.
-		trailerCh := make(chan *types.Header, 10_000)
-		const trailHeight = uint64(10)
+		trailerCh := make(chan *types.Header, channelBuffer)
+		unresolved = newUnresolvedSet(unresolvedTimeout)
+		lagQueues = map[string]chan *types.Header{"side": sideHeadCh, "head": headCh, "trailer": trailerCh}
+		lagTip = &tipCache{client: client, ttl: lagTipTTL}
+		go runChannelMonitor(lagQueues, channelCheckInterval)
+
+		// gapCh receives heights found missing canonical data by the gap scanner.
+		gapCh := make(chan uint64, channelBuffer)
+		if gapsInterval > 0 {
+			var backfillCh chan<- uint64
+			if gapsBackfill {
+				backfillCh = gapCh
+			}
+			go runGapScanner(db, gapsInterval, backfillCh)
+		}
+
+		if webhookURL != "" {
+			go runWebhook(orphanFeed, webhookURL, webhookTimeout, webhookRetries)
+		}
+
+		if logSummaryInterval > 0 {
+			go runSummaryLogger(db, logSummaryInterval)
+		}
+
+		if pruneMinFreeMB > 0 && pruneInterval > 0 {
+			if _, err := diskFree(dbPath); err != nil {
+				logError("Cannot check free disk space (--prune.min-free-mb)", "err", err)
+				os.Exit(1)
+			}
+			go runDiskPruner(db, dbPath, pruneInterval)
+		}
+
+		var pusher *push.Pusher
+		if pushgatewayURL != "" {
+			pusher = newPusher(pushgatewayURL, pushgatewayJob)
+			if pushgatewayInterval > 0 {
+				go runMetricsPusher(pusher, pushgatewayInterval)
+			}
+		}
+
+		chainIDTicker := time.NewTicker(chainIDCheckInterval)
+		defer chainIDTicker.Stop()
+
+		// Backfill the orphans missed while the tracker was down,
+		// from --backfill.from or else from where it left off.
+		// New head events are buffered by the subscriptions meanwhile.
+		if backfillFrom > 0 || resumeWindow > 0 {
+			ctx, cancel := rpcContext()
+			head, err := client.BlockByNumber(ctx, nil)
+			cancel()
+			if err != nil {
+				logError("Latest block query failed", "err", err)
+				os.Exit(1)
+			}
+			from, ok := backfillFrom, backfillFrom > 0
+			if !ok {
+				from, ok, err = resumeFrom(db, head.NumberU64(), resumeWindow)
+				if err != nil {
+					logError("Resume cursor read failed", "err", err)
+					os.Exit(1)
+				}
+			}
+			if ok {
+				logInfo("Backfilling uncle-cited orphans", "from", from, "to", head.NumberU64())
+				n, err := backfill(blocks, db, from, head.NumberU64())
+				if err != nil {
+					logError("Backfill failed", "err", err)
+					os.Exit(1)
+				}
+				logInfo("Backfill done", "uncles", n)
+			}
+		}
 
 		// Run the main loop.
 		// --------------------------------------------------
@@ -460,35 +1381,47 @@ the canonical block which cites them (ie. the current head).
 				// Shutdown
 				// --------------------------------------------------
 				case sig := <-interruptCh:
-					log.Println("Received signal:", sig)
+					logInfo("Received signal", "signal", sig)
 					quitCh <- sig
 					return
 
 					// Errors
 					// --------------------------------------------------
-				case err := <-sideSub.Err():
-					log.Println(err)
-					if strings.Contains(strings.ToLower(err.Error()), "connection") {
+				case err := <-subscriptionErr(sideSub):
+					logWarn("Side head subscription error", "err", err)
+					status.SetSubscribed(false)
+					if isRecoverableSubError(err) {
+						if !waitResubscribe("side", sideBackoff) {
+							return
+						}
 						subErr := setupClientSubsctription("side")
 						if subErr != nil {
-							log.Println(subErr)
+							logError("Side head resubscription failed", "err", subErr)
 							quitCh <- os.Interrupt
 							return
 						}
+						metricSubscriptionReconnects.Inc()
+						status.SetSubscribed(true)
 						continue
 					}
 					quitCh <- os.Interrupt
 					return
 
 				case err := <-headSub.Err():
-					log.Println(err)
-					if strings.Contains(strings.ToLower(err.Error()), "connection") {
+					logWarn("Head subscription error", "err", err)
+					status.SetSubscribed(false)
+					if isRecoverableSubError(err) {
+						if !waitResubscribe("head", headBackoff) {
+							return
+						}
 						subErr := setupClientSubsctription("head")
 						if subErr != nil {
-							log.Println(subErr)
+							logError("Head resubscription failed", "err", subErr)
 							quitCh <- os.Interrupt
 							return
 						}
+						metricSubscriptionReconnects.Inc()
+						status.SetSubscribed(true)
 						continue
 					}
 					quitCh <- os.Interrupt
@@ -498,30 +1431,36 @@ the canonical block which cites them (ie. the current head).
 					// --------------------------------------------------
 					// Any blocks that come through this channel should be stored.
 				case header := <-sideHeadCh:
+					metricSideHeadsReceived.Inc()
 
-					sideHead, err := handleHeader(client, db, header, true, "")
-					if err != nil {
-						log.Println(err)
+					// The canonical block at the side head's height is stored too, for the competition by height.
+					sideHead, canonBlock, err := handleSideHead(blocks, db, header)
+					if sideHead == nil {
+						logError("Side head handling failed", "number", header.Number.Uint64(), "hash", header.Hash(), "err", err)
 						quitCh <- os.Interrupt
 						return
 					}
-					log.Println("New side head:", headerStr(sideHead))
-
-					// Now query and store the block by number to get the canonical headers corresponding to
-					// this uncle by height.
-					canonBlock, err := client.BlockByNumber(context.Background(), header.Number)
-					if err != nil {
-						log.Println(err)
-						quitCh <- os.Interrupt
-						return
+					logInfo("New side head", withFields(sideHead, "parent", sideHead.ParentHash, "miner", sideHead.Coinbase)...)
+					// The feed's subscribers (WebSocket clients, the webhook) get the orphan's citing blocks, if already cited.
+					if err := fillUncledBy(db, []*Header{sideHead}); err != nil {
+						logError("Uncle citations query failed", withFields(sideHead, "err", err)...)
 					}
+					orphanFeed.BroadcastHeader(sideHead)
+					unresolved.Observe(sideHead.Number, sideHead.Hash, time.Now())
 
-					_, err = handleHeader(client, db, canonBlock.Header(), false, "")
+					// The side head is stored; the trailer settles its height later.
+					if isRPCTimeout(err) {
+						logWarn("Canonical block query timed out; skipping", "number", header.Number.Uint64(), "err", err)
+						checkpoint(header)
+						continue
+					}
 					if err != nil {
-						log.Println(err)
+						logError("Canonical block handling failed", "number", header.Number.Uint64(), "err", err)
 						quitCh <- os.Interrupt
 						return
 					}
+					unresolved.Observe(canonBlock.NumberU64(), canonBlock.Hash().Hex(), time.Now())
+					checkpoint(header)
 
 					// Canons
 					// --------------------------------------------------
@@ -531,83 +1470,105 @@ the canonical block which cites them (ie. the current head).
 					// - competitor blocks by height
 					// - uncling blocks, which include orphan references
 				case header := <-headCh:
+					metricCanonicalHeadsReceived.Inc()
 
 					latestHead := appHeader(header)
 
 					// Overwrite any existing row by number with orphan=true.
-					// We ignore any error because we don't care if there are no matching entries in the db
-					// and this tx will be a noop.
-					db.Model(&Header{}).
+					// No matching entries is not an error; this tx will then be a noop.
+					err := db.Model(&Header{}).
 						Where("number = ?", header.Number.Uint64()).
 						Where("hash != ?", header.Hash().Hex()).
-						Update("orphan", true)
+						Update("orphan", true).Error
+					if err != nil {
+						metricDBWriteErrors.Inc()
+						logError("Orphan flag update failed", "number", header.Number.Uint64(), "err", err)
+					}
+					if err := syncCanonicalHead(db, header.Number.Uint64()); err != nil {
+						metricDBWriteErrors.Inc()
+						logError("Canonical head sync failed", "number", header.Number.Uint64(), "err", err)
+					}
 
 					// Flag a conflict at the current head block.
 					// Any events resulting in a conflict will cause the block
 					// to be stored, just in case.
-					conflict := latestHead.Number == statusLatestHead.Number &&
-						latestHead.Hash != statusLatestHead.Hash
-					conflict = conflict || latestHead.Number < statusLatestHead.Number
-					conflict = conflict || latestHead.ParentHash != statusLatestHead.Hash
+					prevHead, _ := status.LatestHead()
+					conflict := latestHead.Number == prevHead.Number &&
+						latestHead.Hash != prevHead.Hash
+					conflict = conflict || latestHead.Number < prevHead.Number
+					conflict = conflict || latestHead.ParentHash != prevHead.Hash
+
+					// A conflict is a reorg unless the new head descends from the previous one, eg. after missed events.
+					if conflict {
+						if ev, err := recordReorg(blocks, db, prevHead, latestHead); err != nil {
+							logError("Reorg detection failed", withFields(latestHead, "err", err)...)
+						} else if ev != nil {
+							logWarn("Reorg", "depth", ev.Depth, "oldHead", ev.OldHead, "newHead", ev.NewHead, "commonAncestor", ev.CommonAncestor)
+						}
+					}
 
 					// Fire this new header off to the trailer channel.
-					trailerCh <- header
+					if err := queueTrailer(blocks, db, trailerCh, header); err != nil {
+						logError("Trailer audit failed", "err", err)
+						quitCh <- os.Interrupt
+						return
+					}
 
 					// Update the in-mem latest head value that's used for the server status.
-					statusLatestHead = latestHead
-					log.Println("New head:", headerStr(latestHead))
+					status.SetLatestHead(latestHead, time.Now())
+					metricLatestHead.Set(float64(latestHead.Number))
+					logInfo("New head", withFields(latestHead, "parent", latestHead.ParentHash, "miner", latestHead.Coinbase)...)
+					unresolved.Observe(latestHead.Number, latestHead.Hash, time.Now())
 
 					if header.UncleHash == types.EmptyUncleHash && !conflict {
+						advanceCursor(header)
+						checkpoint(header)
 						continue
 					}
 
-					_, err = handleHeader(client, db, header, false, "")
+					_, err = handleHeader(blocks, db, header, false, "", SourceHead)
 					if err != nil {
-						log.Println(err)
+						logError("Head handling failed", withFields(latestHead, "err", err)...)
 						quitCh <- os.Interrupt
 						return
 					}
+					advanceCursor(header)
+					checkpoint(header)
 
 					// Trailer
 					// --------------------------------------------------
 				case header := <-trailerCh:
-					trailerHeight := header.Number.Uint64() - trailHeight
-
-					storedHeaders := []*Header{}
-					err := db.Model(&Header{}).
-						Where("number = ?", trailerHeight).
-						Find(&storedHeaders).Error
-
-					if err != nil && err != gorm.ErrRecordNotFound {
-						log.Println(err)
+					if err := trailHeader(blocks, db, header); err != nil {
+						logError("Trailer audit failed", "err", err)
 						quitCh <- os.Interrupt
 						return
 					}
-					if err == gorm.ErrRecordNotFound || len(storedHeaders) == 0 {
-						continue // Noop. We have no stored block data for this height.
+
+					// Chain ID
+					// --------------------------------------------------
+				case <-chainIDTicker.C:
+					if err := checkChainID(client); err != nil {
+						logError("Chain ID check failed", "err", err)
+						quitCh <- os.Interrupt
+						return
 					}
 
-					countCanonical := 0
-					for _, header := range storedHeaders {
-						if !header.Orphan {
-							countCanonical++
-						}
+					// Gaps
+					// --------------------------------------------------
+				case number := <-gapCh:
+					ctx, cancel := rpcContext()
+					canonBlock, err := client.BlockByNumber(ctx, new(big.Int).SetUint64(number))
+					cancel()
+					if err != nil {
+						logWarn("Gap block query failed", "number", number, "err", err)
+						continue
 					}
-					if countCanonical < 1 || countCanonical > 1 {
-						// Fetch the canonical block by height.
-						canonBlock, err := client.BlockByNumber(context.Background(), big.NewInt(int64(trailHeight)))
-						if err != nil {
-							log.Println(err)
-							quitCh <- os.Interrupt
-							return
-						}
 
-						_, err = handleHeader(client, db, canonBlock.Header(), false, "")
-						if err != nil {
-							log.Println(err)
-							quitCh <- os.Interrupt
-							return
-						}
+					_, err = handleHeader(blocks, db, canonBlock.Header(), false, "", SourceBackfill)
+					if err != nil {
+						logError("Gap block handling failed", "number", number, "err", err)
+						quitCh <- os.Interrupt
+						return
 					}
 				}
 			}
@@ -625,25 +1586,34 @@ the canonical block which cites them (ie. the current head).
 
 		// Initiate shutdown.
 		// --------------------------------------------------
-		log.Println("Shutting down...")
+		logInfo("Shutting down...")
 
 		// Now close the server gracefully ("shutdown").
-		shutdownCtx, _ := context.WithTimeout(context.Background(), time.Second*10)
-		if err := srv.Shutdown(shutdownCtx); err != nil {
-
-			// Failure/timeout shutting down the server gracefully.
-			panic(err)
-		}
+		// Failure/timeout shutting down the server gracefully is logged, and the server is closed forcibly.
+		shutdownHttpServer(srv, shutdownTimeout)
 
 		// Wait for goroutine started in startHttpServer() to stop.
 		httpServerExitDone.Wait()
 
-		log.Println("Server shutdown complete")
+		logInfo("Server shutdown complete")
 
-		sideSub.Unsubscribe()
+		if sideSub != nil {
+			sideSub.Unsubscribe()
+		}
 		headSub.Unsubscribe()
 
-		log.Println("Subscriptions closed")
+		logInfo("Subscriptions closed")
+
+		if eventLog != nil {
+			if err := eventLog.Close(); err != nil {
+				logError("WAL close failed", "err", err)
+			}
+		}
+
+		// Push the final values, since a scheduled run won't be scraped.
+		if pusher != nil {
+			pushMetrics(pusher)
+		}
 	},
 }
 
@@ -664,29 +1634,117 @@ func pingHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("pong"))
 }
 
-var statusServerStartedAt time.Time
-var statusLatestHead *Header
+// These are the values of ServerStatus.Ingestion.
+const (
+	ingestionOnline  = "online"
+	ingestionOffline = "offline"
+)
 
 type ServerStatus struct {
-	Uptime       uint64  `json:"uptime"`
-	ChainID      uint64  `json:"chain_id"`
+	Uptime uint64 `json:"uptime"`
+	// Ingestion is offline when the API is served without a node (see the serve subcommand), and online otherwise.
+	Ingestion    string  `json:"ingestion"`
+	ChainID      uint64  `json:"chain_id,omitempty"`
 	LatestHeader *Header `json:"latest_header"`
+	Gaps         []Gap   `json:"gaps,omitempty"`
+	// Channels are the lengths and capacities of the channels of heads waiting to be processed.
+	Channels map[string]ChannelStatus `json:"channels,omitempty"`
 }
 
 func statusHandler(w http.ResponseWriter, r *http.Request) {
-	status := ServerStatus{
-		Uptime:       uint64(time.Since(statusServerStartedAt).Round(time.Second).Seconds()),
-		ChainID:      chainID.Uint64(),
-		LatestHeader: statusLatestHead,
+	latestHead, _ := status.LatestHead()
+	s := ServerStatus{
+		Uptime:       uint64(time.Since(status.StartedAt()).Round(time.Second).Seconds()),
+		Ingestion:    ingestionOnline,
+		LatestHeader: latestHead,
+		Gaps:         status.Gaps(),
+		Channels:     channelStatuses(lagQueues),
+	}
+	if status.Offline() {
+		s.Ingestion = ingestionOffline
 	}
-	j, _ := json.MarshalIndent(status, "", "  ")
+	// The chain ID is unknown without a node.
+	if chainID != nil {
+		s.ChainID = chainID.Uint64()
+	}
+	j, _ := json.MarshalIndent(s, "", "  ")
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(j)
 }
 
+// healthzDBTimeout is how long /healthz waits for the database to respond.
+const healthzDBTimeout = 2 * time.Second
+
+// healthzHandler is a cheap liveness/readiness probe.
+// It responds OK if the node's subscriptions are active, a new head has been received within the --healthz.max-age window,
+// and the database responds to a trivial query; and 503 Service Unavailable, with the failed check, otherwise.
+// Without ingestion (see the serve subcommand), only the database is checked.
+func healthzHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !status.Offline() {
+			if !status.Subscribed() {
+				http.Error(w, "unsubscribed", http.StatusServiceUnavailable)
+				return
+			}
+			_, at := status.LatestHead()
+			if at.IsZero() || time.Since(at) > healthzMaxAge {
+				http.Error(w, "stale", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), healthzDBTimeout)
+		defer cancel()
+		var one int
+		if err := db.WithContext(ctx).Raw("SELECT 1").Scan(&one).Error; err != nil {
+			logWarn("Health check database query failed", "err", err)
+			http.Error(w, "database unreachable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}
+}
+
+// shutdownHttpServer shuts the server down gracefully, waiting up to the timeout for in-flight requests to complete.
+// If they don't, the server is closed forcibly, dropping their connections.
+func shutdownHttpServer(srv *http.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		logWarn("Graceful HTTP server shutdown failed, closing", "err", err)
+		if err := srv.Close(); err != nil {
+			logError("HTTP server close failed", "err", err)
+		}
+	}
+	return err
+}
+
+// corsAllowedOrigin returns the Access-Control-Allow-Origin value for the request's origin under the --http.cors-origins
+// allowlist: * if any origin is allowed, the origin itself if it is listed, and an empty string (ie. no header) otherwise.
+// Origins are compared case-insensitively, ignoring trailing slashes.
+func corsAllowedOrigin(allowed []string, origin string) string {
+	for _, a := range allowed {
+		a = strings.TrimSpace(a)
+		if a == "*" {
+			return "*"
+		}
+		if origin != "" && strings.EqualFold(strings.TrimRight(a, "/"), strings.TrimRight(origin, "/")) {
+			return origin
+		}
+	}
+	return ""
+}
+
 func corsHeaderHandler(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		allowed := corsAllowedOrigin(httpCORSOrigins, r.Header.Get("Origin"))
+		if allowed != "*" {
+			// The response depends on the origin, so caches mustn't serve it to other origins.
+			w.Header().Add("Vary", "Origin")
+		}
+		if allowed != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowed)
+		}
 		w.Header().Set("Access-Control-Allow-Methods", "GET")
 		w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, X-Auth-Token")
 		h.ServeHTTP(w, r)
@@ -703,117 +1761,323 @@ func startHttpServer(wg *sync.WaitGroup, db *gorm.DB) *http.Server {
 
 	r := http.NewServeMux()
 
-	subFs, err := fs.Sub(webContent, "orphan-tracker-ui/public")
-	if err != nil {
-		panic(err)
+	if httpServeUI {
+		subFs, err := fs.Sub(webContent, "orphan-tracker-ui/public")
+		if err != nil {
+			panic(err)
+		}
+		r.Handle("/", handlers.LoggingHandler(os.Stderr, uiHandler(subFs, httpBasePath)))
+	} else {
+		r.Handle("/", handlers.LoggingHandler(os.Stderr, http.HandlerFunc(headlessRootHandler)))
 	}
-	fileServer := http.FileServer(http.FS(subFs))
-	r.Handle("/", handlers.LoggingHandler(os.Stderr, fileServer))
 
 	r.Handle("/ping", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, http.HandlerFunc(pingHandler))))
 	r.Handle("/status", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, http.HandlerFunc(statusHandler))))
-	r.Handle("/api/headers", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		headers := []*Header{}
-		var res *gorm.DB
+	r.Handle("/healthz", healthzHandler(db))
+	r.Handle("/metrics", metricsHandler())
+	r.Handle("/ws/orphans", wsFeedHandler(orphanFeed))
+	r.Handle("/api/headers", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, headersHandler(db))))
+	r.Handle("/api/headers.jsonl", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, headersJSONLHandler(db))))
+	r.Handle("/api/headers.csv", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, headersCSVHandler(db))))
+
+	r.Handle("/api/latest", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, latestHandler(db))))
+	r.Handle("/api/competitions", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, competitionsHandler(db))))
+	r.Handle("/api/competitors/", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, competitorsHandler(db))))
+	r.Handle("/api/uncleable", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, uncleableHandler(db))))
+	r.Handle("/api/uncle-citations", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, uncleCitationsHandler(db))))
+	r.Handle("/api/consecutive-orphans", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, consecutiveOrphansHandler(db))))
+	r.Handle("/api/forks", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, forksHandler(db))))
+	r.Handle("/api/reorgs", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, reorgsHandler(db))))
+	r.Handle("/api/anomalies", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, anomaliesHandler(db))))
+	r.Handle("/api/orphan-rate", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, orphanRateHandler(db))))
+	r.Handle("/api/stats", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, statsHandler(db))))
+	r.Handle("/api/miners", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, minersHandler(db))))
+	r.Handle("/api/lag", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, lagHandler(db))))
+	r.Handle("/api/unresolved", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, http.HandlerFunc(unresolvedHandler))))
+	r.Handle("/api/burn", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, burnHandler(db))))
+	r.Handle("/api/rewards", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, rewardsHandler(db))))
+	r.Handle("/api/header/", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, headerHandler(db))))
+	r.Handle("/api/chain/", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, chainHandler(db))))
+	r.Handle("/api/tx/", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, txHandler(db))))
+	r.Handle("/api/header-txes", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, headerTxesHandler(db))))
+
+	r.Handle("/api/txes", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, txesHandler(db))))
+
+	var h http.Handler = gzipHandler(r)
+	if httpRateLimit > 0 {
+		h = rateLimitHandler(newRateLimiter(httpRateLimit), h)
+	}
+	srv.Handler = withBasePath(h, httpBasePath)
 
-		if q := r.URL.Query().Get("raw_sql"); q != "" {
-			// Wrap the raw SQL in a transaction so we can rollback afterwards in case anyone feels frisky with
-			// mischievous queries.
-			tx := db.Begin()
-			res = tx.Raw(q).Scan(&headers)
-			tx.Rollback()
+	status.SetStartedAt(time.Now())
+	go func() {
+		defer wg.Done() // let main know we are done cleaning up
+
+		logInfo("Starting HTTP server", "addr", srv.Addr)
+
+		// always returns error. ErrServerClosed on graceful close
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			// unexpected error. port in use?
+			logError("HTTP server failed", "addr", srv.Addr, "err", err)
+			os.Exit(1)
+		}
+	}()
+
+	// returning reference so caller can call Shutdown()
+	return srv
+}
+
+// headersQuery builds the query of /api/headers (and its exports) from the request's filtering and sorting
+// query parameters; pagination is left to the callers. It returns an error for invalid parameters.
+func headersQuery(db *gorm.DB, r *http.Request) (*gorm.DB, error) {
+	res := db.Model(&Header{})
+	switch q := r.URL.Query().Get("sort"); q {
+	case "":
+	case "canonical_time_delta":
+		// The most delayed orphans first; headers without a delta last, which Postgres doesn't do by itself.
+		res = res.Order("canonical_time_delta IS NULL").Order("canonical_time_delta DESC")
+	default:
+		return nil, fmt.Errorf("invalid sort: %q", q)
+	}
+	res = res.Order("number DESC")
+	res = res.Order("orphan DESC")
+	// Competing blocks share the number and orphan flag; the hash breaks the tie, so that pages don't overlap.
+	res = res.Order("hash ASC")
+
+	if q := r.URL.Query().Get("orphan"); q != "" {
+		res = res.Where("orphan = ?", q)
+	}
+
+	if q := r.URL.Query().Get("number_min"); q != "" {
+		min, err := parseBlockNumber(q)
+		if err != nil {
+			return nil, err
+		}
+		res = res.Where("number >= ?", min)
+	}
+
+	if q := r.URL.Query().Get("number_max"); q != "" {
+		max, err := parseBlockNumber(q)
+		if err != nil {
+			return nil, err
+		}
+		res = res.Where("number <= ?", max)
+	}
+
+	if q := r.URL.Query().Get("timestamp_min"); q != "" {
+		min, _ := strconv.ParseUint(q, 10, 64)
+		res = res.Where("time >= ?", min)
+	}
+
+	if q := r.URL.Query().Get("timestamp_max"); q != "" {
+		max, _ := strconv.ParseUint(q, 10, 64)
+		res = res.Where("time <= ?", max)
+	}
+
+	// Hashes are stored hex-encoded in lower case.
+	if q := r.URL.Query().Get("state_root"); q != "" {
+		res = res.Where("root = ?", strings.ToLower(strings.TrimSpace(q)))
+	}
+
+	if q := r.URL.Query().Get("receipts_root"); q != "" {
+		res = res.Where("receipt_hash = ?", strings.ToLower(strings.TrimSpace(q)))
+	}
+
+	if q := r.URL.Query().Get("tx_count_min"); q != "" {
+		min, err := strconv.ParseUint(q, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tx_count_min: %q", q)
+		}
+		res = res.Where("tx_count >= ?", min)
+	}
+
+	if q := r.URL.Query().Get("size_min"); q != "" {
+		min, err := strconv.ParseUint(q, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size_min: %q", q)
+		}
+		res = res.Where("size >= ?", min)
+	}
 
+	if q := r.URL.Query().Get("size_max"); q != "" {
+		max, err := strconv.ParseUint(q, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size_max: %q", q)
+		}
+		res = res.Where("size <= ?", max)
+	}
+
+	if q := r.URL.Query().Get("inclusion_distance_min"); q != "" {
+		min, err := strconv.ParseInt(q, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid inclusion_distance_min: %q", q)
+		}
+		res = res.Where("uncle_by != '' AND inclusion_distance >= ?", min)
+	}
+
+	if q := r.URL.Query().Get("inclusion_distance_max"); q != "" {
+		max, err := strconv.ParseInt(q, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid inclusion_distance_max: %q", q)
+		}
+		res = res.Where("uncle_by != '' AND inclusion_distance <= ?", max)
+	}
+
+	if q := r.URL.Query().Get("has_uncles"); q != "" {
+		has, err := strconv.ParseBool(q)
+		if err != nil {
+			return nil, fmt.Errorf("invalid has_uncles: %q", q)
+		}
+		if has {
+			res = res.Where("uncle_count > 0")
 		} else {
+			res = res.Where("uncle_count = 0")
+		}
+	}
 
-			res = db.Model(&Header{})
-			res = res.Order("number DESC")
-			res = res.Order("orphan DESC")
+	if q := r.URL.Query().Get("uncled"); q != "" {
+		uncled, err := strconv.ParseBool(q)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uncled: %q", q)
+		}
+		if uncled {
+			res = res.Where(citedAsUncle)
+		} else {
+			res = res.Where("NOT " + citedAsUncle)
+		}
+	}
 
-			limit := uint64(1000)
-			if q := r.URL.Query().Get("limit"); q != "" {
-				limit, _ = strconv.ParseUint(q, 10, 64)
-			}
-			res = res.Limit(int(limit))
+	if q := r.URL.Query().Get("extra_contains"); q != "" {
+		res = res.Where(`LOWER(extra_string) LIKE ? ESCAPE '\'`, likeContains(strings.ToLower(q)))
+	}
 
-			offset := uint64(0)
-			if q := r.URL.Query().Get("offset"); q != "" {
-				offset, _ = strconv.ParseUint(q, 10, 64)
-			}
-			res = res.Offset(int(offset))
+	if q := r.URL.Query().Get("source"); q != "" {
+		if !sources[q] {
+			return nil, fmt.Errorf("invalid source: %q", q)
+		}
+		res = res.Where("source = ?", q)
+	}
 
-			if q := r.URL.Query().Get("orphan"); q != "" {
-				res = res.Where("orphan = ?", q)
-			}
+	// Coinbases are stored checksummed, ie. in mixed case.
+	if q := r.URL.Query().Get("coinbase"); q != "" {
+		res = res.Where("LOWER(coinbase) = ?", strings.ToLower(strings.TrimSpace(q)))
+	}
+	return res, nil
+}
 
-			if q := r.URL.Query().Get("number_min"); q != "" {
-				min, _ := strconv.ParseUint(q, 10, 64)
-				res = res.Where("number >= ?", min)
-			}
+// headersHandler serves the stored headers, newest first.
+func headersHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		headers := []*Header{}
+		var res *gorm.DB
 
-			if q := r.URL.Query().Get("number_max"); q != "" {
-				max, _ := strconv.ParseUint(q, 10, 64)
-				res = res.Where("number <= ?", max)
+		if q := r.URL.Query().Get("raw_sql"); q != "" {
+			tx, cancel, err := beginRawSQL(r, db)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
 			}
+			res = tx.Raw(q).Scan(&headers)
+			// Scan doesn't report a query cut short by the timeout.
+			res.AddError(tx.Statement.Context.Err())
+			tx.Rollback()
+			cancel()
 
-			if q := r.URL.Query().Get("timestamp_min"); q != "" {
-				min, _ := strconv.ParseUint(q, 10, 64)
-				res = res.Where("time >= ?", min)
-			}
+		} else {
 
-			if q := r.URL.Query().Get("timestamp_max"); q != "" {
-				max, _ := strconv.ParseUint(q, 10, 64)
-				res = res.Where("time <= ?", max)
+			var err error
+			res, err = headersQuery(db, r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
 			}
+			res = paginate(r, res, apiDefaultLimitHeaders)
 
 			if q := r.URL.Query().Get("include_txes"); q != "false" {
 				res = res.Preload("Txes")
 			}
 
 			res.Find(&headers)
+
+			if res.Error == nil {
+				if err := fillUncledBy(db, headers); err != nil {
+					logError("API error", "path", r.URL.Path, "err", err)
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+
+			if q := r.URL.Query().Get("with_parent_miner"); q == "true" && res.Error == nil {
+				if err := fillParentMiners(db, headers); err != nil {
+					logError("API error", "path", r.URL.Path, "err", err)
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
 		}
 
 		if res.Error != nil {
-			log.Println(res.Error)
+			logError("API error", "path", r.URL.Path, "err", res.Error)
 			http.Error(w, res.Error.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		j, err := json.MarshalIndent(headers, "", "  ")
 		if err != nil {
-			log.Println(err)
+			logError("API error", "path", r.URL.Path, "err", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
 		w.Write(j)
-	}))))
+	}
+}
 
-	r.Handle("/api/txes", corsHeaderHandler(handlers.LoggingHandler(os.Stderr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+// txesHandler serves the stored transactions, newest first.
+func txesHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		txes := []Tx{}
 		var res *gorm.DB
 
 		if q := r.URL.Query().Get("raw_sql"); q != "" {
-			// Wrap the raw SQL in a transaction so we can rollback afterwards in case anyone feels frisky with
-			// mischievous queries.
-			tx := db.Begin()
+			tx, cancel, err := beginRawSQL(r, db)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
 			res = tx.Raw(q).Scan(&txes)
+			// Scan doesn't report a query cut short by the timeout.
+			res.AddError(tx.Statement.Context.Err())
 			tx.Rollback()
+			cancel()
 
 		} else {
 			res = db.Model(Tx{})
 			res = res.Order("created_at DESC")
 
-			limit := uint64(1000)
-			if q := r.URL.Query().Get("limit"); q != "" {
-				limit, _ = strconv.ParseUint(q, 10, 64)
+			var err error
+			if res, err = numericKeyRange(r, res, "value", "value_key"); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
 			}
-			res = res.Limit(int(limit))
-
-			offset := uint64(0)
-			if q := r.URL.Query().Get("offset"); q != "" {
-				offset, _ = strconv.ParseUint(q, 10, 64)
+			if res, err = numericKeyRange(r, res, "gas_price", "gas_price_key"); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
 			}
-			res = res.Offset(int(offset))
+			if q := r.URL.Query().Get("token_transfer"); q != "" {
+				transfer, err := strconv.ParseBool(q)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("invalid token_transfer: %q", q), http.StatusBadRequest)
+					return
+				}
+				if transfer {
+					res = res.Where("token_to != ''")
+				} else {
+					res = res.Where("token_to = '' OR token_to IS NULL")
+				}
+			}
+
+			res = paginate(r, res, apiDefaultLimitTxes)
 
 			if q := r.URL.Query().Get("include_headers"); q != "false" {
 				res = res.Preload("Headers")
@@ -823,38 +2087,20 @@ func startHttpServer(wg *sync.WaitGroup, db *gorm.DB) *http.Server {
 		}
 
 		if res.Error != nil {
-			log.Println(res.Error)
+			logError("API error", "path", r.URL.Path, "err", res.Error)
 			http.Error(w, res.Error.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		j, err := json.MarshalIndent(txes, "", "  ")
 		if err != nil {
-			log.Println(err)
+			logError("API error", "path", r.URL.Path, "err", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
 		w.Write(j)
-	}))))
-
-	srv.Handler = r
-
-	statusServerStartedAt = time.Now()
-	go func() {
-		defer wg.Done() // let main know we are done cleaning up
-
-		log.Println("Starting HTTP server...", srv.Addr)
-
-		// always returns error. ErrServerClosed on graceful close
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-			// unexpected error. port in use?
-			log.Fatalf("ListenAndServe(): %v", err)
-		}
-	}()
-
-	// returning reference so caller can call Shutdown()
-	return srv
+	}
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
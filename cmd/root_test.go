@@ -1,18 +1,27 @@
 package cmd
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math/big"
 	mrand "math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
@@ -32,7 +41,7 @@ func generateMockHead() *Header {
 	h.TxHash = randomHex(32)
 	h.Root = randomHex(32)
 	h.MixDigest = randomHex(32)
-	h.Nonce = fmt.Sprintf("%d", mrand.Int63())
+	h.Nonce = nonceString(types.EncodeNonce(mrand.Uint64()))
 	h.Extra = []byte("I was here.")
 
 	h.GasUsed = 63000
@@ -64,6 +73,70 @@ func randomHex(n int) string {
 	return "0x" + hex.EncodeToString(bytes)
 }
 
+// newTestDB opens a fresh, migrated database in the test's temp dir.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// generateMockBlock generates a fake, transaction-less block at the given height.
+func generateMockBlock(number uint64, coinbase common.Address) *types.Block {
+	return types.NewBlockWithHeader(&types.Header{
+		ParentHash: common.HexToHash(randomHex(32)),
+		UncleHash:  types.EmptyUncleHash,
+		Coinbase:   coinbase,
+		Root:       common.HexToHash(randomHex(32)),
+		TxHash:     types.EmptyRootHash, // Without transactions, as ethclient checks.
+		Difficulty: big.NewInt(mrand.Int63()),
+		Number:     new(big.Int).SetUint64(number),
+		GasLimit:   8000000,
+		Time:       uint64(time.Now().Unix()),
+		Extra:      []byte("I was here."),
+	})
+}
+
+// mockChainReader is an in-memory chainReader.
+// Blocks in canon are considered canonical and served by number.
+type mockChainReader struct {
+	blocks map[common.Hash]*types.Block
+	canon  map[uint64]*types.Block
+}
+
+func newMockChainReader() *mockChainReader {
+	return &mockChainReader{
+		blocks: map[common.Hash]*types.Block{},
+		canon:  map[uint64]*types.Block{},
+	}
+}
+
+func (m *mockChainReader) addBlock(bl *types.Block, canonical bool) {
+	m.blocks[bl.Hash()] = bl
+	if canonical {
+		m.canon[bl.NumberU64()] = bl
+	}
+}
+
+func (m *mockChainReader) BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	if bl, ok := m.blocks[hash]; ok {
+		return bl, nil
+	}
+	return nil, ethereum.NotFound
+}
+
+func (m *mockChainReader) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	if bl, ok := m.canon[number.Uint64()]; ok {
+		return bl, nil
+	}
+	return nil, ethereum.NotFound
+}
+
 // TestHeadCreateOrUpdateWithTxes tests the creation of a head with txes.
 // In particular, it wants to make sure that the heads_txes join is working
 // properly, so we add the same txes to two different heads and save them.
@@ -134,6 +207,52 @@ func TestHeadCreateOrUpdateWithTxes(t *testing.T) {
 	}
 }
 
+func TestCreateOrUpdateKeepsTxFields(t *testing.T) {
+	db := newTestDB(t)
+
+	status, gasUsed := uint64(1), uint64(21000)
+	full, plain := generateMockTx(), generateMockTx()
+	full.Data, full.Status, full.GasUsedActual = "a9059cbb", &status, &gasUsed
+	full.TokenTo, full.TokenAmount = randomHex(20), "1000"
+	canon := generateMockHead()
+	canon.Txes = []Tx{full, plain}
+	if err := canon.CreateOrUpdate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same transactions, included by an orphan and stored with --tx.skip-data, --tx.skip-value and --tx.skip-from,
+	// without receipts nor ERC-20 decoding.
+	orphan := generateMockHead()
+	for _, tx := range []Tx{full, plain} {
+		orphan.Txes = append(orphan.Txes, Tx{Hash: tx.Hash, To: tx.To, Nonce: tx.Nonce})
+	}
+	orphan.Txes[1].Error = "sender not recovered"
+	if err := orphan.CreateOrUpdate(db, "orphan"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []Tx{full, plain} {
+		got := Tx{}
+		if err := db.Where("hash = ?", want.Hash).First(&got).Error; err != nil {
+			t.Fatal(err)
+		}
+		if got.From != want.From || got.Data != want.Data || got.Value != want.Value || got.TokenTo != want.TokenTo || got.TokenAmount != want.TokenAmount {
+			t.Errorf("want the stored fields kept, got %+v", got)
+		}
+		if (want.Status == nil) != (got.Status == nil) || (got.Status != nil && *got.Status != *want.Status) ||
+			(want.GasUsedActual == nil) != (got.GasUsedActual == nil) {
+			t.Errorf("want the stored receipt fields kept, got status %v, gas used %v", got.Status, got.GasUsedActual)
+		}
+	}
+	var inclusions int64
+	if err := db.Table("header_txes").Count(&inclusions).Error; err != nil {
+		t.Fatal(err)
+	}
+	if inclusions != 4 {
+		t.Errorf("want both inclusions of both transactions, got %d", inclusions)
+	}
+}
+
 func TestOverwriteCanonHeader(t *testing.T) {
 	testDBPath := filepath.Join(os.TempDir(), "go-orphan-tracker-test-crud1.db")
 	os.Remove(testDBPath) // Clean up on re-run, but leave post-run for inspection.
@@ -187,3 +306,579 @@ func TestOverwriteCanonHeader(t *testing.T) {
 	t.Log(string(j))
 
 }
+
+func TestTrackMinersSkipsUntracked(t *testing.T) {
+	db := newTestDB(t)
+	client := newMockChainReader()
+
+	tracked := common.HexToAddress(randomHex(20))
+	untracked := common.HexToAddress(randomHex(20))
+
+	trackMiners = []string{tracked.Hex()}
+	defer func() { trackMiners = nil }()
+
+	// A standalone canonical block by an untracked miner is skipped.
+	standalone := generateMockBlock(100, untracked)
+	client.addBlock(standalone, true)
+	if _, err := handleHeader(client, db, standalone.Header(), false, "", SourceHead); err != nil {
+		t.Fatal(err)
+	}
+
+	// A standalone orphan by an untracked miner, beaten by another untracked miner, is skipped.
+	canon101 := generateMockBlock(101, untracked)
+	orphan101 := generateMockBlock(101, untracked)
+	client.addBlock(canon101, true)
+	client.addBlock(orphan101, false)
+	if _, err := handleHeader(client, db, orphan101.Header(), true, "", SourceSide); err != nil {
+		t.Fatal(err)
+	}
+
+	// An orphan by an untracked miner competing with a tracked canonical block is kept for context,
+	// as is the tracked canonical block.
+	canon102 := generateMockBlock(102, tracked)
+	orphan102 := generateMockBlock(102, untracked)
+	client.addBlock(canon102, true)
+	client.addBlock(orphan102, false)
+	if _, err := handleHeader(client, db, orphan102.Header(), true, "", SourceSide); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleHeader(client, db, canon102.Header(), false, "", SourceHead); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := []*Header{}
+	if err := db.Model(&Header{}).Order("number ASC").Find(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 2 {
+		t.Fatalf("want 2 stored headers, got %d", len(stored))
+	}
+	for _, h := range stored {
+		if h.Number != 102 {
+			t.Errorf("unexpected stored header: %s", headerStr(h))
+		}
+	}
+}
+
+func TestHealthzHandler(t *testing.T) {
+	defer status.SetLatestHead(nil, time.Time{})
+	defer status.SetSubscribed(false)
+	db := newTestDB(t)
+
+	probe := func() int {
+		rec := httptest.NewRecorder()
+		healthzHandler(db)(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		return rec.Code
+	}
+
+	status.SetSubscribed(true)
+	if code := probe(); code != http.StatusServiceUnavailable {
+		t.Fatalf("want 503 before any head is received, got %d", code)
+	}
+
+	status.SetLatestHead(generateMockHead(), time.Now())
+	if code := probe(); code != http.StatusOK {
+		t.Fatalf("want 200 for a fresh head, got %d", code)
+	}
+
+	status.SetLatestHead(generateMockHead(), time.Now().Add(-healthzMaxAge-time.Second))
+	if code := probe(); code != http.StatusServiceUnavailable {
+		t.Fatalf("want 503 for a stale head, got %d", code)
+	}
+
+	status.SetLatestHead(generateMockHead(), time.Now())
+	status.SetSubscribed(false)
+	if code := probe(); code != http.StatusServiceUnavailable {
+		t.Fatalf("want 503 while unsubscribed, got %d", code)
+	}
+
+	status.SetSubscribed(true)
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.Close()
+	if code := probe(); code != http.StatusServiceUnavailable {
+		t.Fatalf("want 503 with the database unreachable, got %d", code)
+	}
+}
+
+func TestStrictLinkage(t *testing.T) {
+	defer func() { strictLinkage = false }()
+
+	miner := common.HexToAddress(randomHex(20))
+
+	// handle stores a known orphan at a height, then a canonical block with an unknown parent,
+	// and returns both as stored.
+	handle := func() (orphan, canon *Header) {
+		db := newTestDB(t)
+		client := newMockChainReader()
+
+		orphanBlock := generateMockBlock(100, miner)
+		canonBlock := generateMockBlock(100, miner) // Its random parent is neither stored nor known to the node.
+		client.addBlock(orphanBlock, false)
+		client.addBlock(canonBlock, true)
+
+		// Store the soon-to-be competitor as canonical, ie. as if it had been reported by the head feed.
+		if _, err := handleHeader(client, db, orphanBlock.Header(), false, "", SourceHead); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := handleHeader(client, db, canonBlock.Header(), false, "", SourceHead); err != nil {
+			t.Fatal(err)
+		}
+
+		orphan, canon = &Header{}, &Header{}
+		db.Model(&Header{}).Where("hash = ?", orphanBlock.Hash().Hex()).First(orphan)
+		db.Model(&Header{}).Where("hash = ?", canonBlock.Hash().Hex()).First(canon)
+		return orphan, canon
+	}
+
+	strictLinkage = false
+	orphan, canon := handle()
+	if !orphan.Orphan || canon.Error != "" {
+		t.Fatalf("want competitor flagged orphan without strict linkage, got orphan=%v error=%q", orphan.Orphan, canon.Error)
+	}
+
+	strictLinkage = true
+	orphan, canon = handle()
+	if orphan.Orphan {
+		t.Fatal("want competitor left alone with strict linkage and an unknown parent")
+	}
+	if canon.Error == "" {
+		t.Fatal("want the linkage discrepancy recorded in the error field")
+	}
+}
+
+// failingChainReader is a chainReader whose every request fails.
+type failingChainReader struct{}
+
+func (failingChainReader) BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (failingChainReader) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestHandleHeaderStoresHeaderAfterFailedBlockFetch(t *testing.T) {
+	db := newTestDB(t)
+	header := generateMockBlock(100, common.HexToAddress(randomHex(20))).Header()
+
+	if _, err := handleHeader(failingChainReader{}, db, header, false, "", SourceHead); err != nil {
+		t.Fatalf("want the header handled despite the failed block fetch, got %v", err)
+	}
+
+	stored := &Header{}
+	if err := db.Where("hash = ?", header.Hash().Hex()).First(stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stored.Error, "connection refused") {
+		t.Errorf("want the fetch error recorded, got %q", stored.Error)
+	}
+}
+
+func TestHandleHeaderKeepsTxesAfterFailedRecovery(t *testing.T) {
+	defer func(id *big.Int) { chainID, txStrictChainID = id, false }(chainID)
+	chainID = big.NewInt(61)
+	txStrictChainID = true
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signTx := func(nonce uint64, id int64) *types.Transaction {
+		to := common.HexToAddress(randomHex(20))
+		tx := types.NewTx(&types.LegacyTx{Nonce: nonce, To: &to, Value: big.NewInt(1), Gas: 21000, GasPrice: big.NewInt(1)})
+		signed, err := types.SignTx(tx, types.NewEIP155Signer(big.NewInt(id)), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	// The middle transaction is signed for another chain, so its sender can't be recovered (strictly).
+	txes := []*types.Transaction{signTx(0, 61), signTx(1, 1), signTx(2, 61)}
+
+	db := newTestDB(t)
+	client := newMockChainReader()
+	bl := generateMockBlock(100, common.HexToAddress(randomHex(20))).WithBody(txes, nil)
+	client.addBlock(bl, true)
+
+	if _, err := handleHeader(client, db, bl.Header(), false, "", SourceHead); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := &Header{}
+	if err := db.Preload("Txes").Where("hash = ?", bl.Hash().Hex()).First(stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Error == "" {
+		t.Fatal("want the failed transaction noted on the header")
+	}
+	if len(stored.Txes) != len(txes) {
+		t.Fatalf("want %d txes stored, got %d", len(txes), len(stored.Txes))
+	}
+	for _, tx := range stored.Txes {
+		failed := tx.Hash == txes[1].Hash().Hex()
+		if failed && (tx.Error == "" || tx.From != "") {
+			t.Fatalf("want the failed tx stored with an error and no sender, got from=%q error=%q", tx.From, tx.Error)
+		}
+		if !failed && (tx.Error != "" || tx.From == "") {
+			t.Fatalf("want the good tx stored with its sender, got from=%q error=%q", tx.From, tx.Error)
+		}
+	}
+}
+
+func TestHandleHeaderKeepsUnrecoverableTxes(t *testing.T) {
+	defer func(id *big.Int) { chainID = id }(chainID)
+	chainID = big.NewInt(61)
+
+	// The transaction is unsigned, so its sender can't be recovered, even with the fallback signer.
+	to := common.HexToAddress(randomHex(20))
+	unsigned := types.NewTx(&types.LegacyTx{To: &to, Value: big.NewInt(1), Gas: 21000, GasPrice: big.NewInt(1)})
+
+	db := newTestDB(t)
+	client := newMockChainReader()
+	bl := generateMockBlock(100, common.HexToAddress(randomHex(20))).WithBody([]*types.Transaction{unsigned}, nil)
+	client.addBlock(bl, true)
+
+	if _, err := handleHeader(client, db, bl.Header(), false, "", SourceHead); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := &Header{}
+	if err := db.Preload("Txes").Where("hash = ?", bl.Hash().Hex()).First(stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Error == "" || len(stored.Txes) != 1 {
+		t.Fatalf("want the tx stored and its failure noted on the header, got %d txes and error %q", len(stored.Txes), stored.Error)
+	}
+	if tx := stored.Txes[0]; tx.From != "" || !strings.Contains(tx.Error, "fallback signer") {
+		t.Errorf("want the tx stored with no sender and both recovery errors, got from=%q error=%q", tx.From, tx.Error)
+	}
+}
+
+func TestAppTxForeignChainID(t *testing.T) {
+	defer func(id *big.Int) { chainID, txStrictChainID = id, false }(chainID)
+	chainID = big.NewInt(61)
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := common.HexToAddress(randomHex(20))
+	tx, err := types.SignTx(types.NewTx(&types.LegacyTx{To: &to, Value: big.NewInt(1), Gas: 21000, GasPrice: big.NewInt(1)}), types.NewEIP155Signer(big.NewInt(1)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := types.Sender(types.NewEIP155Signer(big.NewInt(1)), tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := appTx(tx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.From != sender.Hex() || got.Error == "" {
+		t.Fatalf("want the sender recovered with the mismatch recorded, got from=%q error=%q", got.From, got.Error)
+	}
+
+	txStrictChainID = true
+	got, err = appTx(tx, nil)
+	if err == nil || got.From != "" {
+		t.Fatalf("want no sender strictly, got from=%q err=%v", got.From, err)
+	}
+}
+
+func TestAppTxSkipFields(t *testing.T) {
+	defer func() { txSkipData, txSkipFrom, txSkipValue = false, false, false }()
+
+	to := common.HexToAddress(randomHex(20))
+	// The transaction is unsigned, so sender recovery would fail.
+	tx := types.NewTx(&types.LegacyTx{To: &to, Value: big.NewInt(1), Gas: 21000, GasPrice: big.NewInt(1), Data: []byte("data")})
+	if _, err := appTx(tx, nil); err == nil {
+		t.Fatal("want sender recovery to fail for an unsigned tx")
+	}
+
+	txSkipData, txSkipFrom, txSkipValue = true, true, true
+	got, err := appTx(tx, nil)
+	if err != nil {
+		t.Fatalf("want sender recovery skipped, got %v", err)
+	}
+	if got.Data != "" || got.From != "" || got.Value != "" {
+		t.Fatalf("want skipped fields empty, got data=%q from=%q value=%q", got.Data, got.From, got.Value)
+	}
+	if got.Hash != tx.Hash().Hex() || got.To != to.Hex() {
+		t.Fatalf("want other fields kept, got %+v", got)
+	}
+}
+
+func TestShutdownHttpServerTimeout(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	}))
+
+	reqErr := make(chan error, 1)
+	go func() {
+		res, err := http.Get(srv.URL)
+		if err == nil {
+			res.Body.Close()
+		}
+		reqErr <- err
+	}()
+	<-entered
+
+	start := time.Now()
+	if err := shutdownHttpServer(srv.Config, 50*time.Millisecond); err != context.DeadlineExceeded {
+		t.Fatalf("want the shutdown to time out, got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("want the shutdown to give up after the timeout, took %s", d)
+	}
+	select {
+	case err := <-reqErr:
+		if err == nil {
+			t.Fatal("want the in-flight request's connection closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("want the in-flight request's connection closed")
+	}
+}
+
+// numberRecordingChainReader records the block numbers requested from it.
+type numberRecordingChainReader struct {
+	*mockChainReader
+	numbers []uint64
+}
+
+func (m *numberRecordingChainReader) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	m.numbers = append(m.numbers, number.Uint64())
+	return m.mockChainReader.BlockByNumber(ctx, number)
+}
+
+func TestAuditTrailerHeightFetchesTrailedBlock(t *testing.T) {
+	defer func(confirm bool) { confirmCanonical = confirm }(confirmCanonical)
+	confirmCanonical = false
+	db := newTestDB(t)
+	client := &numberRecordingChainReader{mockChainReader: newMockChainReader()}
+
+	// Only an orphan is stored at the trailed height.
+	canon := generateMockBlock(1234, common.HexToAddress(randomHex(20)))
+	orphan := generateMockBlock(1234, common.HexToAddress(randomHex(20)))
+	client.addBlock(canon, true)
+	client.addBlock(orphan, false)
+	stored := appHeader(orphan.Header())
+	stored.Orphan = true
+	if err := stored.CreateOrUpdate(db, "orphan"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := auditTrailerHeight(client, db, 1234); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.numbers) != 1 || client.numbers[0] != 1234 {
+		t.Fatalf("want block 1234 requested, got %v", client.numbers)
+	}
+	if got, err := canonicalHashAt(db, 1234); err != nil || got != canon.Hash().Hex() {
+		t.Fatalf("want canonical %s stored, got %q (%v)", canon.Hash().Hex(), got, err)
+	}
+
+	// Once settled, the height isn't fetched again.
+	if err := auditTrailerHeight(client, db, 1234); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.numbers) != 1 {
+		t.Fatalf("want no more requests for a settled height, got %v", client.numbers)
+	}
+}
+
+func TestAuditTrailerHeightConfirmsCanonical(t *testing.T) {
+	defer func(confirm bool) { confirmCanonical = confirm }(confirmCanonical)
+	confirmCanonical = true
+	db := newTestDB(t)
+	client := newMockChainReader()
+
+	// A propagation race: the first block to arrive was flagged canonical, but the node settled on the other one.
+	early := generateMockBlock(1234, common.HexToAddress(randomHex(20)))
+	late := generateMockBlock(1234, common.HexToAddress(randomHex(20)))
+	client.addBlock(early, true)
+	client.addBlock(late, false)
+	if _, err := handleHeader(client, db, early.Header(), false, "", SourceHead); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleHeader(client, db, late.Header(), true, "", SourceSide); err != nil {
+		t.Fatal(err)
+	}
+	client.addBlock(late, true)
+
+	if err := auditTrailerHeight(client, db, 1234); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := canonicalHashAt(db, 1234); err != nil || got != late.Hash().Hex() {
+		t.Fatalf("want canonical %s, got %q (%v)", late.Hash().Hex(), got, err)
+	}
+	orphan, err := storedHeader(db, early.Hash().Hex())
+	if err != nil || orphan == nil || !orphan.Orphan {
+		t.Fatalf("want the early block re-flipped to orphan, got %+v (%v)", orphan, err)
+	}
+
+	// A confirmed height is left as is.
+	if err := auditTrailerHeight(client, db, 1234); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := canonicalHashAt(db, 1234); got != late.Hash().Hex() {
+		t.Fatalf("want canonical %s kept, got %q", late.Hash().Hex(), got)
+	}
+}
+
+// TestQueueTrailerFullChannel runs the main loop's trailer send with --channel.buffer 1:
+// the loop is the channel's only reader, so a full channel must settle the height inline instead of blocking.
+func TestQueueTrailerFullChannel(t *testing.T) {
+	defer func(confirm bool) { confirmCanonical = confirm }(confirmCanonical)
+	defer func(depth uint64) { trailDepth = depth }(trailDepth)
+	confirmCanonical = false
+	trailDepth = 10
+	db := newTestDB(t)
+	client := newMockChainReader()
+
+	// Only an orphan is stored at the height trailing the second head.
+	canon := generateMockBlock(1234, common.HexToAddress(randomHex(20)))
+	orphan := generateMockBlock(1234, common.HexToAddress(randomHex(20)))
+	client.addBlock(canon, true)
+	client.addBlock(orphan, false)
+	stored := appHeader(orphan.Header())
+	stored.Orphan = true
+	if err := stored.CreateOrUpdate(db, "orphan"); err != nil {
+		t.Fatal(err)
+	}
+
+	trailerCh := make(chan *types.Header, 1)
+	done := make(chan error, 1)
+	go func() {
+		for _, number := range []uint64{1243, 1244} {
+			if err := queueTrailer(client, db, trailerCh, generateMockBlock(number, common.Address{}).Header()); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("trailer send blocked on a full channel")
+	}
+
+	if len(trailerCh) != 1 || (<-trailerCh).Number.Uint64() != 1243 {
+		t.Fatal("want the first head queued")
+	}
+	if got, err := canonicalHashAt(db, 1234); err != nil || got != canon.Hash().Hex() {
+		t.Fatalf("want canonical %s settled inline, got %q (%v)", canon.Hash().Hex(), got, err)
+	}
+}
+
+func TestHandleHeaderRecordsSize(t *testing.T) {
+	db := newTestDB(t)
+	client := newMockChainReader()
+	bl := generateMockBlock(100, common.HexToAddress(randomHex(20)))
+	client.addBlock(bl, true)
+
+	if _, err := handleHeader(client, db, bl.Header(), false, "", SourceHead); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := storedHeader(db, bl.Hash().Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Size == 0 || stored.Size != uint64(bl.Size()) {
+		t.Errorf("want size %v, got %d", bl.Size(), stored.Size)
+	}
+}
+
+func TestCORSHeaderHandler(t *testing.T) {
+	defer func(origins []string) { httpCORSOrigins = origins }(httpCORSOrigins)
+	h := corsHeaderHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, c := range []struct {
+		allowed []string
+		origin  string
+		want    string
+	}{
+		{[]string{"*"}, "https://anywhere.example", "*"},
+		{[]string{"*"}, "", "*"},
+		{[]string{"https://dash.example.com", "http://localhost:3000"}, "http://localhost:3000", "http://localhost:3000"},
+		{[]string{"https://dash.example.com/"}, "https://Dash.example.com", "https://Dash.example.com"},
+		{[]string{"https://dash.example.com"}, "https://evil.example", ""},
+		{[]string{"https://dash.example.com"}, "", ""},
+	} {
+		httpCORSOrigins = c.allowed
+		req := httptest.NewRequest(http.MethodGet, "/api/headers", nil)
+		if c.origin != "" {
+			req.Header.Set("Origin", c.origin)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != c.want {
+			t.Errorf("allowed %v, origin %q: want %q, got %q", c.allowed, c.origin, c.want, got)
+		}
+		if vary := rec.Header().Get("Vary"); (c.want != "*") != (vary == "Origin") {
+			t.Errorf("allowed %v, origin %q: unexpected Vary %q", c.allowed, c.origin, vary)
+		}
+		if rec.Header().Get("Access-Control-Allow-Methods") != "GET" {
+			t.Errorf("want the allowed methods kept")
+		}
+	}
+}
+
+func TestHeaderSource(t *testing.T) {
+	db := newTestDB(t)
+	client := newMockChainReader()
+
+	// One uncle is caught as a side head first, the other only when cited.
+	seen := generateMockBlock(100, common.HexToAddress(randomHex(20)))
+	missed := generateMockBlock(100, common.HexToAddress(randomHex(20)))
+	citer := generateMockBlock(101, common.HexToAddress(randomHex(20))).WithBody(nil, []*types.Header{seen.Header(), missed.Header()})
+	for _, bl := range []*types.Block{seen, missed} {
+		client.addBlock(bl, false)
+	}
+	client.addBlock(citer, true)
+
+	if _, err := handleHeader(client, db, seen.Header(), true, "", SourceSide); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleHeader(client, db, citer.Header(), false, "", SourceHead); err != nil {
+		t.Fatal(err)
+	}
+
+	for hash, want := range map[common.Hash]string{seen.Hash(): SourceSide, missed.Hash(): SourceUncle, citer.Hash(): SourceHead} {
+		stored, err := storedHeader(db, hash.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored == nil || stored.Source != want {
+			t.Errorf("want source %q for %s, got %+v", want, hash.Hex(), stored)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	headersHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/headers?source=uncle&include_txes=false", nil))
+	got := []*Header{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Hash != missed.Hash().Hex() {
+		t.Errorf("want the uncle missed by the side heads, got %+v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	headersHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/headers?source=bogus", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("want 400 for an invalid source, got %d", rec.Code)
+	}
+}
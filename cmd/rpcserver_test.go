@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// rpcRequest and rpcResponse are JSON-RPC 2.0 messages.
+type rpcRequest struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// mockRPCHandler is a node's JSON-RPC API over HTTP, serving the blocks of the chain:
+// eth_chainId, eth_getBlockByHash and eth_getBlockByNumber. Other methods are answered as unavailable.
+func mockRPCHandler(chain *mockChainReader, chainID int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req rpcRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		res := rpcResponse{Version: "2.0", ID: req.ID}
+		result, err := mockRPCCall(chain, chainID, req)
+		if err != nil {
+			res.Error = &rpcError{Code: -32000, Message: err.Error()}
+		} else {
+			res.Result = result
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+func mockRPCCall(chain *mockChainReader, chainID int64, req rpcRequest) (interface{}, error) {
+	switch req.Method {
+	case "eth_chainId":
+		return hexutil.EncodeBig(big.NewInt(chainID)), nil
+	case "eth_getBlockByHash":
+		var hash common.Hash
+		if len(req.Params) == 0 {
+			return nil, fmt.Errorf("missing hash")
+		}
+		if err := json.Unmarshal(req.Params[0], &hash); err != nil {
+			return nil, err
+		}
+		return rpcBlock(chain.blocks[hash])
+	case "eth_getBlockByNumber":
+		var arg string
+		if len(req.Params) == 0 {
+			return nil, fmt.Errorf("missing block number")
+		}
+		if err := json.Unmarshal(req.Params[0], &arg); err != nil {
+			return nil, err
+		}
+		if arg == "latest" {
+			var head *types.Block
+			for _, bl := range chain.canon {
+				if head == nil || bl.NumberU64() > head.NumberU64() {
+					head = bl
+				}
+			}
+			return rpcBlock(head)
+		}
+		number, err := hexutil.DecodeUint64(arg)
+		if err != nil {
+			return nil, err
+		}
+		return rpcBlock(chain.canon[number])
+	}
+	return nil, fmt.Errorf("the method %s does not exist/is not available", req.Method)
+}
+
+// rpcBlock is the block's JSON-RPC representation, with its header's fields, its hash and its uncles' hashes.
+// A missing block is null.
+func rpcBlock(bl *types.Block) (interface{}, error) {
+	if bl == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(bl.Header())
+	if err != nil {
+		return nil, err
+	}
+	// Raw, so that large numbers aren't rounded through float64.
+	fields := map[string]interface{}{}
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	for k, v := range raw {
+		fields[k] = v
+	}
+	txes := types.Transactions{}
+	txes = append(txes, bl.Transactions()...)
+	uncles := []common.Hash{}
+	for _, u := range bl.Uncles() {
+		uncles = append(uncles, u.Hash())
+	}
+	fields["hash"] = bl.Hash()
+	fields["transactions"] = txes
+	fields["uncles"] = uncles
+	return fields, nil
+}
+
+// newMockRPCClient serves the chain from a mock node, and returns an ethclient.Client connected to it.
+func newMockRPCClient(t *testing.T, chain *mockChainReader, chainID int64) *ethclient.Client {
+	t.Helper()
+	srv := httptest.NewServer(mockRPCHandler(chain, chainID))
+	t.Cleanup(srv.Close)
+	rpcClient, err := rpc.DialHTTP(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := ethclient.NewClient(rpcClient)
+	t.Cleanup(client.Close)
+	return client
+}
+
+func TestHandleSideHeadThroughRPC(t *testing.T) {
+	db := newTestDB(t)
+	chain := newMockChainReader()
+	canon := generateMockBlock(100, common.HexToAddress(randomHex(20)))
+	side := generateMockBlock(100, common.HexToAddress(randomHex(20)))
+	chain.addBlock(canon, true)
+	chain.addBlock(side, false)
+	client := newMockRPCClient(t, chain, 61)
+
+	id, err := client.ChainID(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Int64() != 61 {
+		t.Errorf("want chain ID 61, got %d", id)
+	}
+
+	sideHead, canonBlock, err := handleSideHead(client, db, side.Header())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sideHead.Hash != side.Hash().Hex() || canonBlock.Hash() != canon.Hash() {
+		t.Errorf("want side head %s and canonical block %s, got %s and %s", side.Hash().Hex(), canon.Hash().Hex(), sideHead.Hash, canonBlock.Hash().Hex())
+	}
+	for _, want := range []struct {
+		bl     *types.Block
+		orphan bool
+	}{{side, true}, {canon, false}} {
+		stored, err := storedHeader(db, want.bl.Hash().Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored == nil {
+			t.Fatalf("want block %s stored", want.bl.Hash().Hex())
+		}
+		if stored.Orphan != want.orphan || stored.Number != 100 || stored.Source != SourceSide || stored.Coinbase != want.bl.Coinbase().Hex() {
+			t.Errorf("want block %s stored at 100 with orphan %v by %s, got %+v", want.bl.Hash().Hex(), want.orphan, want.bl.Coinbase().Hex(), stored)
+		}
+	}
+	if hash, err := canonicalHashAt(db, 100); err != nil || hash != canon.Hash().Hex() {
+		t.Errorf("want canonical block %s at 100, got %q (%v)", canon.Hash().Hex(), hash, err)
+	}
+
+	// Without a canonical block at its height, the side head is stored alone.
+	lone := generateMockBlock(101, common.HexToAddress(randomHex(20)))
+	chain.addBlock(lone, false)
+	sideHead, _, err = handleSideHead(client, db, lone.Header())
+	if err == nil {
+		t.Error("want the missing canonical block reported")
+	}
+	if sideHead == nil || !sideHead.Orphan {
+		t.Errorf("want the side head stored as an orphan, got %+v", sideHead)
+	}
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// serveCmd serves the API and UI of an existing database, without a node.
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the API and UI of an existing database, without tracking",
+	Long: `Open the database read-only and serve the HTTP API and UI, without connecting to a node, eg. to browse a snapshot.
+
+Nothing is written to the database, not even its migrations: run the migrate subcommand first on databases of older versions.
+/status reports "ingestion": "offline", and /healthz only checks the database.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := setupLogging(logLevel, logFormat); err != nil {
+			logError(err.Error())
+			os.Exit(1)
+		}
+		if latestAnomalies < 0 {
+			logError("Invalid --api.latest-anomalies value (must not be negative)", "value", latestAnomalies)
+			os.Exit(1)
+		}
+		dial, err := readOnlyDialector(dbDriver, dbPath, dbDSN)
+		if err != nil {
+			logError("Invalid database configuration", "err", err)
+			os.Exit(1)
+		}
+		db, err := gorm.Open(dial, &gorm.Config{})
+		if err != nil {
+			logError("Database open failed", "err", err)
+			os.Exit(1)
+		}
+		status.SetOffline(true)
+
+		interruptCh := make(chan os.Signal, 1)
+		signal.Notify(interruptCh, os.Interrupt, os.Kill)
+
+		httpServerExitDone := &sync.WaitGroup{}
+		httpServerExitDone.Add(1)
+		srv := startHttpServer(httpServerExitDone, db)
+
+		sig := <-interruptCh
+		logInfo("Received signal", "signal", sig)
+		logInfo("Shutting down...")
+		shutdownHttpServer(srv, shutdownTimeout)
+		httpServerExitDone.Wait()
+		logInfo("Server shutdown complete")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().StringVar(&dbDriver, "db.driver", dbDriverSQLite, "Database driver: sqlite (at --db.path) or postgres (at --db.dsn)")
+	serveCmd.Flags().StringVar(&dbPath, "db.path", "", "Path to database file, eg. /path/to/db.sqlite")
+	serveCmd.Flags().StringVar(&dbDSN, "db.dsn", "", "Postgres connection string, eg. \"host=localhost user=tracker dbname=orphans sslmode=disable\"")
+	serveCmd.Flags().StringVar(&httpAddr, "http.addr", ":8080", "Address to serve HTTP API on, eg. :8080")
+	serveCmd.Flags().StringSliceVar(&httpCORSOrigins, "http.cors-origins", []string{"*"}, "Comma-separated origins allowed to make cross-origin requests to the API, eg. https://dashboard.example.com; * for any")
+	serveCmd.Flags().Float64Var(&httpRateLimit, "http.rate-limit", 0, "Maximum rate of requests per second to the /api/ endpoints per client IP; 0 for no limit")
+	serveCmd.Flags().StringVar(&httpBasePath, "http.base-path", "", "Path prefix to serve the HTTP API and UI under, eg. /orphans, when mounted behind a reverse proxy")
+	serveCmd.Flags().BoolVar(&httpServeUI, "http.serve-ui", true, "Serve the embedded UI at /; false to serve the API only, eg. behind an external frontend")
+	serveCmd.Flags().BoolVar(&httpAllowRawSQL, "http.allow-raw-sql", false, "Allow arbitrary SQL queries with the raw_sql query parameter of /api/headers and /api/txes (run in rolled-back transactions)")
+	serveCmd.Flags().DurationVar(&httpRawSQLTimeout, "http.raw-sql-timeout", 10*time.Second, "Time after which raw_sql queries are cancelled; 0 for no limit")
+	serveCmd.Flags().Uint64Var(&apiDefaultLimitHeaders, "api.default-limit-headers", 1000, "Default number of headers served by /api/headers when no limit is given")
+	serveCmd.Flags().Uint64Var(&apiDefaultLimitTxes, "api.default-limit-txes", 1000, "Default number of transactions served by /api/txes when no limit is given")
+	serveCmd.Flags().Uint64Var(&apiMaxLimit, "api.max-limit", 0, "Maximum number of rows served by paginated endpoints, regardless of the limit given; 0 for no maximum")
+	serveCmd.Flags().IntVar(&latestAnomalies, "api.latest-anomalies", 10, "Default number of recent orphans and competitions served by /api/latest")
+	serveCmd.Flags().DurationVar(&shutdownTimeout, "shutdown.timeout", 10*time.Second, "Maximum time to wait for in-flight HTTP requests to complete on shutdown, before closing their connections")
+	serveCmd.Flags().StringVar(&logLevel, "log.level", "info", "Minimum level of the logged lines: debug, info, warn, or error")
+	serveCmd.Flags().StringVar(&logFormat, "log.format", logFormatText, "Format of the logged lines: text, or json for one JSON object per line")
+}
+
+// readOnlyDialector returns the gorm dialector of the database driver, like dialector, for a read-only database:
+// SQLite opens the database file in read-only mode, and Postgres sessions default to read-only transactions.
+func readOnlyDialector(driver, path, dsn string) (gorm.Dialector, error) {
+	if _, err := dialector(driver, path, dsn); err != nil {
+		return nil, err
+	}
+	if driver == dbDriverSQLite {
+		// The path is escaped in a URI, which must be absolute: a relative one would be taken for an authority.
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		uri := url.URL{Scheme: "file", Path: filepath.ToSlash(abs), RawQuery: "mode=ro"}
+		return sqlite.Open(uri.String()), nil
+	}
+	return postgres.Open(readOnlyDSN(dsn)), nil
+}
+
+// readOnlyDSN adds the default_transaction_read_only run-time parameter to the Postgres DSN,
+// either a URL (postgres://...) or key=value pairs.
+func readOnlyDSN(dsn string) string {
+	const param = "default_transaction_read_only=on"
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		return dsn + " " + param
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&" + param
+	}
+	return dsn + "?" + param
+}
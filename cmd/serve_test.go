@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestReadOnlyDSN(t *testing.T) {
+	for dsn, want := range map[string]string{
+		"host=localhost dbname=orphans":                "host=localhost dbname=orphans default_transaction_read_only=on",
+		"postgres://tracker@localhost/orphans":         "postgres://tracker@localhost/orphans?default_transaction_read_only=on",
+		"postgresql://localhost/orphans?sslmode=false": "postgresql://localhost/orphans?sslmode=false&default_transaction_read_only=on",
+	} {
+		if got := readOnlyDSN(dsn); got != want {
+			t.Errorf("%s: want %s, got %s", dsn, want, got)
+		}
+	}
+}
+
+func TestServeReadOnly(t *testing.T) {
+	defer status.SetOffline(false)
+	defer func(id *big.Int) { chainID = id }(chainID)
+	chainID = nil
+
+	path := filepath.Join(t.TempDir(), "test.db")
+	rw, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateSchema(rw); err != nil {
+		t.Fatal(err)
+	}
+	h := generateMockHead()
+	if err := h.CreateOrUpdate(rw); err != nil {
+		t.Fatal(err)
+	}
+
+	dial, err := readOnlyDialector(dbDriverSQLite, path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open(dial, &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := storedHeader(db, h.Hash); err != nil || stored == nil {
+		t.Fatalf("want the header read, got %v (%v)", stored, err)
+	}
+	if err := generateMockHead().CreateOrUpdate(db); err == nil {
+		t.Error("want writes to fail")
+	}
+
+	status.SetOffline(true)
+	rec := httptest.NewRecorder()
+	statusHandler(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	s := ServerStatus{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Ingestion != ingestionOffline {
+		t.Errorf("want ingestion %q, got %q", ingestionOffline, s.Ingestion)
+	}
+	// Not subscribed, and without heads, but healthy.
+	rec = httptest.NewRecorder()
+	healthzHandler(db)(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("want 200, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestReadOnlyDialectorPaths(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"test.db", "with space#and%20marks.db"} {
+		path := filepath.Join(t.TempDir(), name)
+		rw, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := migrateSchema(rw); err != nil {
+			t.Fatal(err)
+		}
+		h := generateMockHead()
+		if err := h.CreateOrUpdate(rw); err != nil {
+			t.Fatal(err)
+		}
+
+		rel, err := filepath.Rel(wd, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range []string{path, rel} {
+			dial, err := readOnlyDialector(dbDriverSQLite, p, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			db, err := gorm.Open(dial, &gorm.Config{})
+			if err != nil {
+				t.Fatalf("%s: %v", p, err)
+			}
+			if stored, err := storedHeader(db, h.Hash); err != nil || stored == nil {
+				t.Errorf("%s: want the header read, got %v (%v)", p, stored, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// statsBuckets are the granularities of /api/stats, in seconds.
+var statsBuckets = map[string]uint64{
+	"hour": 60 * 60,
+	"day":  24 * 60 * 60,
+}
+
+// StatsBucket is the number of canonical and orphan headers stored with timestamps in a time bucket,
+// starting at BucketStart (a UNIX timestamp).
+// The orphans are split into those cited as uncles (rewarded) and those which weren't (pure waste).
+type StatsBucket struct {
+	BucketStart    uint64 `json:"bucket_start"`
+	CanonicalCount int64  `json:"canonical_count"`
+	OrphanCount    int64  `json:"orphan_count"`
+	UncledCount    int64  `json:"uncled_count"`
+	NotUncledCount int64  `json:"not_uncled_count"`
+}
+
+// headerStats counts the canonical and orphan (uncled or not) headers with heights between min and max (inclusive)
+// by time buckets of the given number of seconds, in ascending order. Buckets without headers are omitted.
+func headerStats(db *gorm.DB, bucket, min, max uint64) ([]StatsBucket, error) {
+	stats := []StatsBucket{}
+	err := db.Model(&Header{}).
+		Select("(time / ?) * ? AS bucket_start, "+
+			"SUM(CASE WHEN orphan THEN 0 ELSE 1 END) AS canonical_count, "+
+			"SUM(CASE WHEN orphan THEN 1 ELSE 0 END) AS orphan_count, "+
+			"SUM(CASE WHEN orphan AND "+citedAsUncle+" THEN 1 ELSE 0 END) AS uncled_count, "+
+			"SUM(CASE WHEN orphan AND NOT "+citedAsUncle+" THEN 1 ELSE 0 END) AS not_uncled_count", bucket, bucket).
+		Where("number >= ? AND number <= ?", min, max).
+		Group("bucket_start").
+		Order("bucket_start ASC").
+		Scan(&stats).Error
+	return stats, err
+}
+
+// statsHandler serves the counts of canonical and orphan headers by time bucket (hour or day),
+// optionally in a number_min/number_max range.
+func statsHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("bucket")
+		if name == "" {
+			name = "hour"
+		}
+		bucket, ok := statsBuckets[name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("invalid bucket %q: must be hour or day", name), http.StatusBadRequest)
+			return
+		}
+		min, max, err := numberRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		stats, err := headerStats(db, bucket, min, max)
+		if err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, stats)
+	}
+}
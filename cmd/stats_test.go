@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatsHandler(t *testing.T) {
+	db := newTestDB(t)
+
+	const day = 1_700_006_400 // A multiple of 86400.
+	for i, c := range []struct {
+		time   uint64
+		orphan bool
+		uncled bool
+	}{
+		{day + 10, false, false},
+		{day + 20, true, true},
+		{day + 3600 + 5, false, false},
+		{day + 86400 + 1, true, false},
+	} {
+		h := generateMockHead()
+		h.Number, h.Time, h.Orphan = uint64(100+i), c.time, c.orphan
+		if err := h.CreateOrUpdate(db); err != nil {
+			t.Fatal(err)
+		}
+		if c.uncled {
+			if err := recordUncleCitation(db, h.Hash, randomHex(32)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	query := func(q string) (int, []StatsBucket) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		statsHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/stats?"+q, nil))
+		stats := []StatsBucket{}
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, stats
+	}
+
+	// Hourly by default.
+	_, stats := query("")
+	want := []StatsBucket{{day, 1, 1, 1, 0}, {day + 3600, 1, 0, 0, 0}, {day + 86400, 0, 1, 0, 1}}
+	if len(stats) != len(want) {
+		t.Fatalf("want %v, got %v", want, stats)
+	}
+	for i := range want {
+		if stats[i] != want[i] {
+			t.Errorf("want %v, got %v", want[i], stats[i])
+		}
+	}
+
+	_, stats = query("bucket=day")
+	if len(stats) != 2 || stats[0] != (StatsBucket{day, 2, 1, 1, 0}) || stats[1] != (StatsBucket{day + 86400, 0, 1, 0, 1}) {
+		t.Errorf("unexpected daily stats: %v", stats)
+	}
+
+	if code, _ := query("bucket=week"); code != http.StatusBadRequest {
+		t.Errorf("want 400 for an invalid bucket, got %d", code)
+	}
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"sync"
+	"time"
+)
+
+// trackerStatus is the in-memory status of the tracker, for /status, /healthz, and the relative queries.
+// It is written by the subscription and scanner goroutines and read by the HTTP handlers,
+// so it is safe for concurrent use.
+// The latest head is replaced, never modified, once set; readers may keep the pointer.
+type trackerStatus struct {
+	mu           sync.RWMutex
+	startedAt    time.Time
+	latestHead   *Header
+	latestHeadAt time.Time
+	subscribed   bool
+	offline      bool
+	gaps         []Gap
+}
+
+// status is the tracker's status.
+var status = &trackerStatus{}
+
+// SetStartedAt records the time the HTTP server was started.
+func (s *trackerStatus) SetStartedAt(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.startedAt = t
+}
+
+// StartedAt returns the time the HTTP server was started.
+func (s *trackerStatus) StartedAt() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.startedAt
+}
+
+// SetLatestHead records the latest head, and the (local) time it was received.
+func (s *trackerStatus) SetLatestHead(h *Header, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latestHead, s.latestHeadAt = h, at
+}
+
+// LatestHead returns the latest head, and the (local) time it was received.
+// The head is nil, and the time zero, until a head has been received.
+func (s *trackerStatus) LatestHead() (*Header, time.Time) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.latestHead, s.latestHeadAt
+}
+
+// LatestNumber returns the number of the latest head, or 0 if no head has been received.
+func (s *trackerStatus) LatestNumber() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.latestHead == nil {
+		return 0
+	}
+	return s.latestHead.Number
+}
+
+// SetSubscribed records whether the node's subscriptions are currently active.
+func (s *trackerStatus) SetSubscribed(subscribed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribed = subscribed
+}
+
+// Subscribed returns whether the node's subscriptions are currently active;
+// it is false until they are set up, and while one is being re-established after an error.
+func (s *trackerStatus) Subscribed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.subscribed
+}
+
+// SetOffline records that the API is served without ingestion (see the serve subcommand).
+func (s *trackerStatus) SetOffline(offline bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offline = offline
+}
+
+// Offline returns whether the API is served without ingestion, ie. without a node.
+func (s *trackerStatus) Offline() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.offline
+}
+
+// SetGaps records the results of the latest gap scan.
+func (s *trackerStatus) SetGaps(gaps []Gap) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gaps = gaps
+}
+
+// Gaps returns the results of the latest gap scan.
+func (s *trackerStatus) Gaps() []Gap {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.gaps
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestStatusConcurrentAccess exercises the status from the ingestion and HTTP sides at once;
+// it is meant to be run with -race.
+func TestStatusConcurrentAccess(t *testing.T) {
+	defer status.SetLatestHead(nil, time.Time{})
+	defer status.SetGaps(nil)
+	defer status.SetSubscribed(false)
+	defer func(id *big.Int) { chainID = id }(chainID)
+	chainID = big.NewInt(61)
+	status.SetStartedAt(time.Now())
+	db := newTestDB(t)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := uint64(1); i <= 100; i++ {
+			h := generateMockHead()
+			h.Number = i
+			status.SetLatestHead(h, time.Now())
+			status.SetGaps([]Gap{{From: i, To: i}})
+			status.SetSubscribed(i%2 == 0)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			rec := httptest.NewRecorder()
+			statusHandler(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("unexpected status %d", rec.Code)
+			}
+			healthzHandler(db)(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if _, err := parseBlockNumber("latest-1"); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	wg.Wait()
+
+	if n := status.LatestNumber(); n != 100 {
+		t.Errorf("want latest number 100, got %d", n)
+	}
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+)
+
+var sideHeads bool
+
+// sideHeadsDegradedCoverage describes what is tracked without side heads, for the logs.
+const sideHeadsDegradedCoverage = "only orphans cited as uncles by canonical blocks are tracked; uncited orphans are missed"
+
+// recoverableSubErrors are substrings of (lowercased) subscription errors caused by transient failures
+// of the connection to the node, after which resubscribing can succeed.
+var recoverableSubErrors = []string{
+	"connection", // eg. "connection reset by peer", "connection refused", "use of closed network connection"
+	"eof",
+	"timeout",
+	"timed out",
+	"broken pipe",
+	"websocket: close",
+	"subscription queue overflow",
+}
+
+// isRecoverableSubError tells whether the subscription error is transient, ie. whether to resubscribe.
+// The RPC client closed by the program ("client is closed") is not recoverable.
+func isRecoverableSubError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	if strings.Contains(msg, "client is closed") {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	for _, s := range recoverableSubErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// subBackoff spaces the resubscriptions of a subscription, so that a flapping endpoint doesn't spin the main loop.
+// The first resubscription is immediate; the wait then doubles from min with each resubscription
+// following the previous one within maxRetryInterval, and resets otherwise.
+type subBackoff struct {
+	min  time.Duration
+	wait time.Duration
+	last time.Time
+}
+
+// next returns the wait before resubscribing at now.
+func (b *subBackoff) next(now time.Time) time.Duration {
+	switch {
+	case b.last.IsZero() || now.Sub(b.last) > maxRetryInterval:
+		b.wait = 0
+	case b.wait == 0:
+		b.wait = b.min
+	default:
+		if b.wait *= 2; b.wait > maxRetryInterval {
+			b.wait = maxRetryInterval
+		}
+	}
+	b.last = now
+	return b.wait
+}
+
+// isUnsupportedMethodError tells whether the error is the node's refusal of an RPC method (or subscription) it doesn't support,
+// eg. eth_subscribeNewSideHeads on nodes other than core-geth.
+func isUnsupportedMethodError(err error) bool {
+	if err == nil {
+		return false
+	}
+	// -32601 is JSON-RPC's "method not found".
+	var rpcErr interface{ ErrorCode() int }
+	if errors.As(err, &rpcErr) && rpcErr.ErrorCode() == -32601 {
+		return true
+	}
+	// Otherwise, eg. "the method eth_subscribe does not exist/is not available",
+	// or `no "newSideHeads" subscription in eth namespace`.
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "does not exist/is not available") ||
+		strings.Contains(msg, "method not found") ||
+		(strings.Contains(msg, "subscription in") && strings.Contains(msg, "namespace"))
+}
+
+// subscriptionErr returns the error channel of the subscription, or nil (which blocks forever) if there is none.
+func subscriptionErr(sub ethereum.Subscription) <-chan error {
+	if sub == nil {
+		return nil
+	}
+	return sub.Err()
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsRecoverableSubError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{io.EOF, true},
+		{fmt.Errorf("read: %w", io.ErrUnexpectedEOF), true},
+		{errors.New("EOF"), true},
+		{errors.New("websocket: close 1006 (abnormal closure): unexpected EOF"), true},
+		{errors.New("websocket: close 1001 (going away)"), true},
+		{errors.New("read tcp 127.0.0.1:54321->127.0.0.1:8546: read: connection reset by peer"), true},
+		{errors.New("write unix @->/data/geth.ipc: write: broken pipe"), true},
+		{errors.New("read tcp 127.0.0.1:54321->127.0.0.1:8546: i/o timeout"), true},
+		{errors.New("subscription queue overflow"), true},
+		{&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, true},
+		{&net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}, true},
+		{errors.New("client is closed"), false},
+		{errors.New("the method eth_subscribe does not exist/is not available"), false},
+		{errors.New("notifications not supported"), false},
+	}
+	for _, c := range cases {
+		if got := isRecoverableSubError(c.err); got != c.want {
+			t.Errorf("isRecoverableSubError(%v): want %v, got %v", c.err, c.want, got)
+		}
+	}
+}
+
+func TestSubBackoff(t *testing.T) {
+	b := &subBackoff{min: time.Second}
+	now := time.Now()
+	want := []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second}
+	for i, w := range want {
+		if got := b.next(now.Add(time.Duration(i) * time.Second)); got != w {
+			t.Errorf("resubscription %d: want wait %s, got %s", i, w, got)
+		}
+	}
+
+	// A stable subscription resets the backoff.
+	if got := b.next(now.Add(time.Hour)); got != 0 {
+		t.Errorf("want no wait after a stable period, got %s", got)
+	}
+}
+
+// mockRPCError is an RPC error response, with its JSON-RPC error code.
+type mockRPCError struct {
+	code int
+	msg  string
+}
+
+func (e *mockRPCError) Error() string  { return e.msg }
+func (e *mockRPCError) ErrorCode() int { return e.code }
+
+func TestIsUnsupportedMethodError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{&mockRPCError{-32601, "the method eth_subscribe does not exist/is not available"}, true},
+		{&mockRPCError{-32601, "something else"}, true},
+		{errors.New(`no "newSideHeads" subscription in eth namespace`), true},
+		{errors.New("the method eth_subscribe does not exist/is not available"), true},
+		{fmt.Errorf("subscribe: %w", &mockRPCError{-32601, "method not found"}), true},
+		{&mockRPCError{-32000, "too many subscriptions"}, false},
+		{errors.New("read tcp 127.0.0.1:54321->127.0.0.1:8546: read: connection reset by peer"), false},
+		{errors.New("notifications not supported"), false},
+	}
+	for _, c := range cases {
+		if got := isUnsupportedMethodError(c.err); got != c.want {
+			t.Errorf("isUnsupportedMethodError(%v): want %v, got %v", c.err, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// summaryRateWindow is the number of most recent blocks over which the summary's orphan rate is computed.
+const summaryRateWindow = uint64(1000)
+
+var logSummaryInterval time.Duration
+
+// Summary is a periodic, at-a-glance account of the database.
+type Summary struct {
+	Headers int64
+	Orphans int64
+	Uncles  int64
+	Txes    int64
+	Tip     uint64
+
+	// RecentOrphanRate is the number of orphans per block over the last summaryRateWindow blocks.
+	RecentOrphanRate float64
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("headers=%d orphans=%d uncles=%d txes=%d tip=%d orphan_rate=%.4f (last %d blocks)",
+		s.Headers, s.Orphans, s.Uncles, s.Txes, s.Tip, s.RecentOrphanRate, summaryRateWindow)
+}
+
+// computeSummary computes the summary with cheap counts.
+func computeSummary(db *gorm.DB, tip uint64) (Summary, error) {
+	s := Summary{Tip: tip}
+	if err := db.Model(&Header{}).Count(&s.Headers).Error; err != nil {
+		return s, err
+	}
+	if err := db.Model(&Header{}).Where("orphan = ?", true).Count(&s.Orphans).Error; err != nil {
+		return s, err
+	}
+	if err := db.Model(&Header{}).Where("uncle_by != ?", "").Count(&s.Uncles).Error; err != nil {
+		return s, err
+	}
+	if err := db.Model(&Tx{}).Count(&s.Txes).Error; err != nil {
+		return s, err
+	}
+
+	window := summaryRateWindow
+	if tip+1 < window {
+		window = tip + 1
+	}
+	if window == 0 {
+		return s, nil
+	}
+	var recent int64
+	err := db.Model(&Header{}).
+		Where("orphan = ?", true).
+		Where("number >= ? AND number <= ?", tip+1-window, tip).
+		Count(&recent).Error
+	if err != nil {
+		return s, err
+	}
+	s.RecentOrphanRate = float64(recent) / float64(window)
+	return s, nil
+}
+
+// runSummaryLogger logs a summary at every interval. It never returns.
+func runSummaryLogger(db *gorm.DB, interval time.Duration) {
+	for range time.Tick(interval) {
+		tip := status.LatestNumber()
+		s, err := computeSummary(db, tip)
+		if err != nil {
+			logError("Summary failed", "err", err)
+			continue
+		}
+		logInfo("Summary", "summary", s)
+	}
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestComputeSummary(t *testing.T) {
+	db := newTestDB(t)
+
+	tip := uint64(5000)
+	seed := []struct {
+		number  uint64
+		orphan  bool
+		uncleBy string
+		txes    int
+	}{
+		{4999, false, "", 2},
+		{4999, true, "", 1},
+		{4500, true, randomHex(32), 0},
+		{3000, true, "", 0}, // Outside of the recent window.
+		{3000, false, "", 0},
+	}
+	for _, s := range seed {
+		h := generateMockHead()
+		h.Number, h.Orphan, h.UncleBy = s.number, s.orphan, s.uncleBy
+		for i := 0; i < s.txes; i++ {
+			h.Txes = append(h.Txes, generateMockTx())
+		}
+		if err := h.CreateOrUpdate(db, "orphan"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := computeSummary(db, tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Summary{Headers: 5, Orphans: 3, Uncles: 1, Txes: 3, Tip: tip, RecentOrphanRate: 2.0 / float64(summaryRateWindow)}
+	if s != want {
+		t.Fatalf("want %v, got %v", want, s)
+	}
+
+	// Near genesis, the window shrinks to the number of blocks so far.
+	if s, err = computeSummary(db, 0); err != nil {
+		t.Fatal(err)
+	} else if s.RecentOrphanRate != 0 {
+		t.Fatalf("want no recent orphans at genesis, got rate %f", s.RecentOrphanRate)
+	}
+}
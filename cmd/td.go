@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// rpcCaller is the subset of the rpc.Client API used for calls which ethclient.Client doesn't wrap.
+type rpcCaller interface {
+	CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error
+}
+
+// rawRPC is the raw RPC client of the node, set at startup.
+// If nil, total difficulties are not fetched.
+var rawRPC rpcCaller
+
+// fetchTotalDifficulty returns the total difficulty of the block, hex-encoded like Header.Difficulty,
+// from the totalDifficulty field of eth_getBlockByHash.
+// It returns an empty string if the node doesn't return it, eg. for a block it doesn't know.
+func fetchTotalDifficulty(c rpcCaller, hash common.Hash) (string, error) {
+	var block *struct {
+		TotalDifficulty *hexutil.Big `json:"totalDifficulty"`
+	}
+	ctx, cancel := rpcContext()
+	defer cancel()
+	if err := c.CallContext(ctx, &block, "eth_getBlockByHash", hash, false); err != nil {
+		return "", err
+	}
+	if block == nil || block.TotalDifficulty == nil {
+		return "", nil
+	}
+	return block.TotalDifficulty.String(), nil
+}
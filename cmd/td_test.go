@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// mockRPCCaller answers every call with the JSON-encoded response, or the error.
+type mockRPCCaller struct {
+	response string
+	err      error
+}
+
+func (m mockRPCCaller) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	if m.err != nil {
+		return m.err
+	}
+	return json.Unmarshal([]byte(m.response), result)
+}
+
+func TestFetchTotalDifficulty(t *testing.T) {
+	hash := common.HexToHash(randomHex(32))
+	for _, c := range []struct {
+		name, response, want string
+	}{
+		{"returned", `{"number":"0x64","totalDifficulty":"0x2a"}`, "0x2a"},
+		{"not returned", `{"number":"0x64"}`, ""},
+		{"unknown block", `null`, ""},
+	} {
+		got, err := fetchTotalDifficulty(mockRPCCaller{response: c.response}, hash)
+		if err != nil {
+			t.Fatal(c.name, err)
+		}
+		if got != c.want {
+			t.Errorf("%s: want %q, got %q", c.name, c.want, got)
+		}
+	}
+}
+
+func TestHandleHeaderStoresTotalDifficulty(t *testing.T) {
+	defer func() { rawRPC = nil }()
+	db := newTestDB(t)
+	client := newMockChainReader()
+
+	bl := generateMockBlock(100, common.HexToAddress(randomHex(20)))
+	client.addBlock(bl, true)
+	rawRPC = mockRPCCaller{response: `{"totalDifficulty":"0x2a"}`}
+	if _, err := handleHeader(client, db, bl.Header(), false, "", SourceHead); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failing call doesn't keep the header from being stored, nor wipe the stored total difficulty.
+	rawRPC = mockRPCCaller{err: errors.New("method not found")}
+	if _, err := handleHeader(client, db, bl.Header(), false, "", SourceHead); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := &Header{}
+	if err := db.Where("hash = ?", bl.Hash().Hex()).First(stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.TotalDifficulty != "0x2a" {
+		t.Errorf("want total difficulty 0x2a, got %q", stored.TotalDifficulty)
+	}
+}
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// These are the RPC transports supported by the tracker, which need subscriptions (eth_subscribe).
+const (
+	rpcTransportWS  = "websocket"
+	rpcTransportIPC = "ipc"
+)
+
+// rpcTransport returns the transport of the RPC target: websocket for ws:// and wss:// URLs, and IPC for filesystem paths.
+// HTTP doesn't support subscriptions, so http:// and https:// URLs are an error, as are other schemes.
+func rpcTransport(target string) (string, error) {
+	u, err := url.Parse(target)
+	// Paths which don't parse as URLs (eg. Windows named pipes, \\.\pipe\geth.ipc), or without a scheme,
+	// or with a drive letter for one (eg. C:\geth.ipc), are IPC endpoints.
+	if err != nil || len(u.Scheme) <= 1 {
+		return rpcTransportIPC, nil
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "ws", "wss":
+		return rpcTransportWS, nil
+	case "http", "https":
+		return "", fmt.Errorf("unsupported RPC target %s: HTTP doesn't support subscriptions (eth_subscribe); "+
+			"use the node's websocket (ws:// or wss://) or IPC (a file path) endpoint", target)
+	}
+	return "", fmt.Errorf("unsupported RPC target %s: use a websocket (ws:// or wss://) URL or an IPC file path", target)
+}
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestRPCTransport(t *testing.T) {
+	for target, want := range map[string]string{
+		"ws://localhost:8546":          rpcTransportWS,
+		"wss://node.example.com/ws":    rpcTransportWS,
+		"WS://localhost:8546":          rpcTransportWS,
+		"/data/geth.ipc":               rpcTransportIPC,
+		"./geth.ipc":                   rpcTransportIPC,
+		"geth.ipc":                     rpcTransportIPC,
+		`\\.\pipe\geth.ipc`:            rpcTransportIPC,
+		`C:\Users\me\AppData\geth.ipc`: rpcTransportIPC,
+		"http://localhost:8545":        "",
+		"https://node.example.com":     "",
+		"stdio://":                     "",
+	} {
+		got, err := rpcTransport(target)
+		if want == "" {
+			if err == nil {
+				t.Errorf("%s: want an error, got %s", target, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("%s: want %s, got %s, %v", target, want, got, err)
+		}
+	}
+}
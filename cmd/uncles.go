@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// uncleWindow is the maximum distance between an uncle's height and the height of the block citing it.
+// It is 6 on Ethereum mainnet (and networks inheriting its rules), but differs across chains and forks.
+// A value of 0 means uncles are not eligible for inclusion (or reward) at all.
+var uncleWindow uint64
+
+// isUncleable returns true if an orphan at the given height could still be cited as an uncle
+// by the next block built on top of the tip.
+func isUncleable(number, tip uint64) bool {
+	next := tip + 1
+	return uncleWindow > 0 && number < next && next-number <= uncleWindow
+}
+
+// inclusionDistance returns the distance from an uncle to the block citing it, which sets the uncle's reward,
+// and whether it is valid, ie. from 1 to uncleWindow. It is signed, so that a citer below the uncle shows as such.
+func inclusionDistance(uncleNumber, citerNumber uint64) (int, bool) {
+	d := int(int64(citerNumber) - int64(uncleNumber))
+	return d, d >= 1 && uint64(d) <= uncleWindow
+}
+
+// recordInclusionDistance stores the uncle's inclusion distance to the citer, if it is the uncle's UncleBy.
+// Distances out of the valid range are stored as they are, logged, and recorded as anomalies.
+func recordInclusionDistance(db *gorm.DB, uncle, citer *Header) error {
+	d, valid := inclusionDistance(uncle.Number, citer.Number)
+	if !valid {
+		logWarn("Uncle inclusion distance out of range", withFields(uncle, "citer", citer.Hash, "distance", d, "window", uncleWindow)...)
+		detail := fmt.Sprintf("uncle cited by %s at distance %d, out of the window of %d", citer.Hash, d, uncleWindow)
+		if err := recordAnomaly(db, AnomalyInclusionDistance, uncle.Hash, uncle.Number, detail); err != nil {
+			return err
+		}
+	}
+	uncle.InclusionDistance = d
+	return db.Model(&Header{}).
+		Where("hash = ? AND uncle_by = ?", uncle.Hash, citer.Hash).
+		UpdateColumn("inclusion_distance", d).Error
+}
+
+// migrateInclusionDistances fills the inclusion distances of the stored uncles whose citing block (UncleBy) is stored,
+// in a single statement. It is run once, when the column is added to a database from before it; later uncles
+// get their distance when stored (see recordInclusionDistance).
+func migrateInclusionDistances(db *gorm.DB) error {
+	return db.Exec(`UPDATE headers SET inclusion_distance =
+		(SELECT citer.number FROM headers citer WHERE citer.hash = headers.uncle_by) - headers.number
+		WHERE headers.uncle_by != '' AND (headers.inclusion_distance IS NULL OR headers.inclusion_distance = 0)
+		AND EXISTS (SELECT 1 FROM headers citer WHERE citer.hash = headers.uncle_by)`).Error
+}
+
+// findUncleableOrphans returns stored orphans not (yet) cited as uncles,
+// which could still be cited by the next block built on top of the tip.
+func findUncleableOrphans(db *gorm.DB, tip uint64) ([]*Header, error) {
+	headers := []*Header{}
+	if uncleWindow == 0 {
+		return headers, nil
+	}
+
+	min := uint64(0)
+	if tip+1 > uncleWindow {
+		min = tip + 1 - uncleWindow
+	}
+	err := db.Model(&Header{}).
+		Where("orphan = ?", true).
+		Where("uncle_by = ?", "").
+		Where("number >= ? AND number <= ?", min, tip).
+		Order("number DESC").
+		Find(&headers).Error
+	return headers, err
+}
+
+func uncleableHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tip := status.LatestNumber()
+		headers, err := findUncleableOrphans(db, tip)
+		if err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, headers)
+	}
+}
+
+// UncleCitation counts the uncles by one miner which were cited by blocks of another miner.
+type UncleCitation struct {
+	CitingMiner string `json:"citing_miner"`
+	UncleMiner  string `json:"uncle_miner"`
+	Count       int64  `json:"count"`
+}
+
+// uncleCitationMatrix returns who-uncles-whom: for each pair of citing and cited miners,
+// the number of uncles with heights between min and max (inclusive).
+// Citations are only counted if the citing block is stored.
+// An uncle cited by several blocks counts once for each of them.
+// The result is the sparse form of the matrix, ordered by count descending.
+func uncleCitationMatrix(db *gorm.DB, min, max uint64) ([]UncleCitation, error) {
+	citations := []UncleCitation{}
+	err := db.Table("headers AS uncles").
+		Select("citers.coinbase AS citing_miner, uncles.coinbase AS uncle_miner, COUNT(*) AS count").
+		Joins("JOIN uncle_citations ON uncle_citations.uncle_hash = uncles.hash").
+		Joins("JOIN headers AS citers ON citers.hash = uncle_citations.citer_hash AND citers.deleted_at IS NULL").
+		Where("uncles.deleted_at IS NULL").
+		Where("uncles.number >= ? AND uncles.number <= ?", min, max).
+		Group("citers.coinbase, uncles.coinbase").
+		Order("count DESC, citing_miner ASC, uncle_miner ASC").
+		Scan(&citations).Error
+	return citations, err
+}
+
+func uncleCitationsHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		min, max, err := numberRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		citations, err := uncleCitationMatrix(db, min, max)
+		if err != nil {
+			logError("API error", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, citations)
+	}
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestUncleableOrphansWindow(t *testing.T) {
+	db := newTestDB(t)
+
+	defer func(w uint64) { uncleWindow = w }(uncleWindow)
+	uncleWindow = 2
+
+	tip := uint64(1000)
+	for _, n := range []uint64{997, 998, 999, 1000} {
+		h := generateMockHead()
+		h.Number = n
+		h.Orphan = true
+		if err := h.CreateOrUpdate(db, "orphan"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cited := generateMockHead()
+	cited.Number = 1000
+	cited.Orphan = true
+	cited.UncleBy = randomHex(32)
+	if err := cited.CreateOrUpdate(db, "orphan"); err != nil {
+		t.Fatal(err)
+	}
+
+	headers, err := findUncleableOrphans(db, tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 2 || headers[0].Number != 1000 || headers[1].Number != 999 {
+		t.Fatalf("want uncited orphans at 1000 and 999 within a window of 2, got %d headers", len(headers))
+	}
+
+	if isUncleable(998, tip) {
+		t.Error("998 should be out of a window of 2 from tip 1000")
+	}
+	if !isUncleable(999, tip) {
+		t.Error("999 should be within a window of 2 from tip 1000")
+	}
+
+	uncleWindow = 0
+	if headers, _ := findUncleableOrphans(db, tip); len(headers) != 0 {
+		t.Fatalf("want no uncleable orphans with the window disabled, got %d", len(headers))
+	}
+}
+
+func TestUncleCitationMatrix(t *testing.T) {
+	db := newTestDB(t)
+
+	minerA, minerB, minerC := randomHex(20), randomHex(20), randomHex(20)
+
+	// store saves a header by the miner at the height, optionally cited as an uncle by the citing hash.
+	store := func(miner string, number uint64, uncleBy string) *Header {
+		h := generateMockHead()
+		h.Coinbase = miner
+		h.Number = number
+		h.UncleBy = uncleBy
+		h.Orphan = uncleBy != ""
+		if err := h.CreateOrUpdate(db, "orphan", "uncle_by"); err != nil {
+			t.Fatal(err)
+		}
+		if uncleBy != "" {
+			if err := recordUncleCitation(db, h.Hash, uncleBy); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return h
+	}
+
+	// A cites B twice and C once; C cites B twice, once for an uncle also cited by A.
+	citerA1 := store(minerA, 102, "")
+	citerA2 := store(minerA, 105, "")
+	citerC := store(minerC, 108, "")
+	store(minerB, 101, citerA1.Hash)
+	store(minerB, 104, citerA2.Hash)
+	store(minerC, 100, citerA1.Hash)
+	store(minerB, 107, citerC.Hash)
+	shared := store(minerB, 106, citerA2.Hash)
+	if err := recordUncleCitation(db, shared.Hash, citerC.Hash); err != nil {
+		t.Fatal(err)
+	}
+	// An uncle whose citing block is not stored isn't counted.
+	store(minerB, 109, randomHex(32))
+
+	citations, err := uncleCitationMatrix(db, 0, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[[2]string]int64{}
+	for _, c := range citations {
+		counts[[2]string{c.CitingMiner, c.UncleMiner}] = c.Count
+	}
+	if len(citations) != 3 || counts[[2]string{minerA, minerB}] != 3 || counts[[2]string{minerA, minerC}] != 1 || counts[[2]string{minerC, minerB}] != 2 {
+		t.Fatalf("unexpected citations: %+v", citations)
+	}
+	if citations[0].CitingMiner != minerA || citations[0].UncleMiner != minerB {
+		t.Errorf("want the most frequent citation first, got %+v", citations[0])
+	}
+
+	citations, err = uncleCitationMatrix(db, 103, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(citations) != 2 {
+		t.Fatalf("want 2 citation pairs for uncles from 103, got %+v", citations)
+	}
+}
+
+func TestInclusionDistance(t *testing.T) {
+	db := newTestDB(t)
+	defer func(w uint64) { uncleWindow = w }(uncleWindow)
+	uncleWindow = 6
+
+	miner := common.HexToAddress(randomHex(20))
+	near, far := generateMockBlock(100, miner), generateMockBlock(95, miner)
+	citer := generateMockBlock(105, miner).WithBody(nil, []*types.Header{near.Header(), far.Header()})
+	client := newMockChainReader()
+	client.addBlock(citer, true)
+	if _, err := handleHeader(client, db, citer.Header(), false, "", SourceHead); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []struct {
+		bl       *types.Block
+		distance int
+	}{{near, 5}, {far, 10}} {
+		stored, err := storedHeader(db, want.bl.Hash().Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored == nil || stored.UncleBy != citer.Hash().Hex() || stored.InclusionDistance != want.distance {
+			t.Errorf("want uncle %s at distance %d, got %+v", want.bl.Hash().Hex(), want.distance, stored)
+		}
+	}
+
+	// The anomalies, out of the window.
+	w := httptest.NewRecorder()
+	headersHandler(db)(w, httptest.NewRequest("GET", "/api/headers?inclusion_distance_min=7", nil))
+	got := []Header{}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(w.Body.String())
+	}
+	if len(got) != 1 || got[0].Hash != far.Hash().Hex() {
+		t.Errorf("want the far uncle, got %+v", got)
+	}
+	anomalies := []Anomaly{}
+	if err := db.Where("kind = ?", AnomalyInclusionDistance).Find(&anomalies).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(anomalies) != 1 || anomalies[0].Hash != far.Hash().Hex() || anomalies[0].Number != 95 {
+		t.Errorf("want the far uncle's citation recorded as an anomaly, got %+v", anomalies)
+	}
+
+	// Uncles stored before the column get their distance from the stored citer.
+	if err := db.Model(&Header{}).Where("hash = ?", near.Hash().Hex()).UpdateColumn("inclusion_distance", 0).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateInclusionDistances(db); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := storedHeader(db, near.Hash().Hex()); err != nil || stored.InclusionDistance != 5 {
+		t.Errorf("want the distance 5 migrated, got %+v (%v)", stored, err)
+	}
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var unresolvedTimeout time.Duration
+
+// Unresolved is a height at which competing headers have arrived,
+// but whose winner has not been confirmed by the trailer yet.
+type Unresolved struct {
+	Number    uint64    `json:"number"`
+	Hashes    []string  `json:"hashes"`
+	FirstSeen time.Time `json:"firstSeen"`
+}
+
+// unresolvedSet is the short-lived, in-memory set of heights with in-flight competitions.
+// It is safe for concurrent use.
+type unresolvedSet struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	heights map[uint64]*Unresolved
+}
+
+func newUnresolvedSet(timeout time.Duration) *unresolvedSet {
+	return &unresolvedSet{timeout: timeout, heights: map[uint64]*Unresolved{}}
+}
+
+// unresolved holds the in-flight competitions observed by the subscriptions, for /api/unresolved.
+var unresolved = newUnresolvedSet(0)
+
+// Observe records the arrival of a header at a height.
+func (s *unresolvedSet) Observe(number uint64, hash string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.heights[number]
+	if !ok {
+		u = &Unresolved{Number: number, FirstSeen: now}
+		s.heights[number] = u
+	}
+	for _, h := range u.Hashes {
+		if h == hash {
+			return
+		}
+	}
+	u.Hashes = append(u.Hashes, hash)
+}
+
+// Resolve clears the height, eg. once the trailer has confirmed its winner.
+func (s *unresolvedSet) Resolve(number uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.heights, number)
+}
+
+// List drops the heights which were first seen longer than the timeout ago,
+// and returns those with more than one header, in ascending order by height.
+func (s *unresolvedSet) List(now time.Time) []Unresolved {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := []Unresolved{}
+	for n, u := range s.heights {
+		if s.timeout > 0 && now.Sub(u.FirstSeen) > s.timeout {
+			delete(s.heights, n)
+			continue
+		}
+		if len(u.Hashes) > 1 {
+			out = append(out, Unresolved{Number: u.Number, Hashes: append([]string{}, u.Hashes...), FirstSeen: u.FirstSeen})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Number < out[j].Number })
+	return out
+}
+
+// unresolvedHandler serves the heights with competitions whose winner is not yet confirmed.
+func unresolvedHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, unresolved.List(time.Now()))
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUnresolvedSet(t *testing.T) {
+	now := time.Now()
+	s := newUnresolvedSet(time.Minute)
+
+	s.Observe(100, "0xa", now)
+	s.Observe(100, "0xa", now)
+	if got := s.List(now); len(got) != 0 {
+		t.Fatalf("want a single header not to be a competition, got %v", got)
+	}
+
+	s.Observe(100, "0xb", now)
+	s.Observe(101, "0xc", now)
+	got := s.List(now)
+	if len(got) != 1 || got[0].Number != 100 || len(got[0].Hashes) != 2 {
+		t.Fatalf("want height 100 unresolved with 2 hashes, got %v", got)
+	}
+
+	// Confirmation clears the height.
+	s.Resolve(100)
+	if got := s.List(now); len(got) != 0 {
+		t.Fatalf("want height 100 cleared after confirmation, got %v", got)
+	}
+
+	// Unconfirmed heights expire after the timeout.
+	s.Observe(102, "0xd", now)
+	s.Observe(102, "0xe", now)
+	if got := s.List(now.Add(2 * time.Minute)); len(got) != 0 {
+		t.Fatalf("want height 102 expired, got %v", got)
+	}
+}
@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// walCompactAfter is the number of appended records after which the WAL file
+// is truncated, once all of its events have been processed.
+const walCompactAfter = 10_000
+
+// walRecord is a line of the write-ahead log.
+// A record either logs a received event (Kind and Header),
+// or checkpoints that the event with the same Seq has been processed (Done).
+type walRecord struct {
+	Seq    uint64        `json:"seq"`
+	Kind   string        `json:"kind,omitempty"`
+	Header *types.Header `json:"header,omitempty"`
+	Done   bool          `json:"done,omitempty"`
+}
+
+// wal is an append-only, on-disk log of received subscription events.
+// Events are appended (and synced) before they are processed, and checkpointed once processed.
+// Any events without a checkpoint when the WAL is opened were lost to a crash,
+// and are returned to be replayed.
+type wal struct {
+	mu      sync.Mutex
+	f       *os.File
+	seq     uint64
+	written int
+	pending map[*types.Header]uint64
+}
+
+// openWAL opens (or creates) the WAL at path, returning any events which
+// were logged but never processed, in the order they were received.
+// The file is compacted to hold only these pending events.
+func openWAL(path string) (*wal, []walRecord, error) {
+	records := []walRecord{}
+	done := map[uint64]bool{}
+	var seq uint64
+
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+		for scanner.Scan() {
+			rec := walRecord{}
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				// A torn write from a crash; nothing after it can be trusted.
+				break
+			}
+			if rec.Seq > seq {
+				seq = rec.Seq
+			}
+			if rec.Done {
+				done[rec.Seq] = true
+				continue
+			}
+			records = append(records, rec)
+		}
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	pending := []walRecord{}
+	for _, rec := range records {
+		if !done[rec.Seq] && rec.Header != nil {
+			pending = append(pending, rec)
+		}
+	}
+
+	// Compact: rewrite the log with only the pending events.
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, nil, err
+	}
+	enc := json.NewEncoder(f)
+	for _, rec := range pending {
+		if err := enc.Encode(rec); err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	f.Close()
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, nil, err
+	}
+
+	f, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	w := &wal{f: f, seq: seq, written: len(pending), pending: map[*types.Header]uint64{}}
+	for _, rec := range pending {
+		w.pending[rec.Header] = rec.Seq
+	}
+	return w, pending, nil
+}
+
+func (w *wal) write(rec walRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := w.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	w.written++
+	return w.f.Sync()
+}
+
+// Append logs a received event.
+func (w *wal) Append(kind string, header *types.Header) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.seq++
+	w.pending[header] = w.seq
+	return w.write(walRecord{Seq: w.seq, Kind: kind, Header: header})
+}
+
+// Done checkpoints that the event for the header has been processed.
+// Headers which were not logged are ignored.
+func (w *wal) Done(header *types.Header) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	seq, ok := w.pending[header]
+	if !ok {
+		return nil
+	}
+	delete(w.pending, header)
+	if err := w.write(walRecord{Seq: seq, Done: true}); err != nil {
+		return err
+	}
+
+	// Nothing is outstanding, so the log can be emptied.
+	if len(w.pending) == 0 && w.written >= walCompactAfter {
+		if err := w.f.Truncate(0); err != nil {
+			return err
+		}
+		w.written = 0
+	}
+	return nil
+}
+
+// Tee logs each header received on in, then forwards it to out.
+// It returns when in is closed.
+func (w *wal) Tee(kind string, in <-chan *types.Header, out chan<- *types.Header) {
+	for header := range in {
+		if err := w.Append(kind, header); err != nil {
+			logError("WAL append failed", "kind", kind, "number", header.Number.Uint64(), "err", err)
+		}
+		out <- header
+	}
+}
+
+func (w *wal) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Close()
+}
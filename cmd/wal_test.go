@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestWALReplaysUnprocessedEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.wal")
+
+	w, pending, err := openWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("want no pending events in a new WAL, got %d", len(pending))
+	}
+
+	miner := common.HexToAddress(randomHex(20))
+	side := generateMockBlock(100, miner).Header()
+	head1 := generateMockBlock(101, miner).Header()
+	head2 := generateMockBlock(102, miner).Header()
+
+	for _, e := range []struct {
+		kind   string
+		header *types.Header
+	}{{"side", side}, {"head", head1}, {"head", head2}} {
+		if err := w.Append(e.kind, e.header); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Done(head1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Crash: drop the in-memory WAL state without any orderly shutdown.
+	w = nil
+
+	w, pending, err = openWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 {
+		t.Fatalf("want 2 replayed events, got %d", len(pending))
+	}
+	if pending[0].Kind != "side" || pending[0].Header.Hash() != side.Hash() {
+		t.Errorf("want side event for %s replayed first, got %s event for %s", side.Hash().Hex(), pending[0].Kind, pending[0].Header.Hash().Hex())
+	}
+	if pending[1].Kind != "head" || pending[1].Header.Hash() != head2.Hash() {
+		t.Errorf("want head event for %s replayed second, got %s event for %s", head2.Hash().Hex(), pending[1].Kind, pending[1].Header.Hash().Hex())
+	}
+
+	// Replayed events can be checkpointed, after which nothing is pending.
+	for _, rec := range pending {
+		if err := w.Done(rec.Header); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, pending, err = openWAL(path); err != nil {
+		t.Fatal(err)
+	} else if len(pending) != 0 {
+		t.Fatalf("want no pending events after checkpointing, got %d", len(pending))
+	}
+}
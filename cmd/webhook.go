@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var webhookURL string
+var webhookTimeout time.Duration
+var webhookRetries int
+
+// webhookBuffer is the number of orphans queued for delivery to the webhook.
+// As for WebSocket clients, orphans are dropped while the queue is full, so that a slow webhook can't hold up ingestion.
+const webhookBuffer = 256
+
+// webhookRetryInterval is the wait before the first retry of a failed delivery; it doubles after each retry.
+const webhookRetryInterval = time.Second
+
+// runWebhook delivers the messages broadcast by the feed to the webhook URL. It never returns.
+func runWebhook(feed *broadcastHub, url string, timeout time.Duration, retries int) {
+	deliverWebhooks(feed.Subscribe(webhookBuffer), &http.Client{Timeout: timeout}, url, retries)
+}
+
+// deliverWebhooks POSTs each message received on ch to the URL, retrying failed deliveries up to retries times.
+// Deliveries which still fail are logged and dropped. It returns when ch is closed.
+func deliverWebhooks(ch <-chan []byte, client *http.Client, url string, retries int) {
+	for msg := range ch {
+		err := withRetry("Webhook delivery", retries, webhookRetryInterval, func() error {
+			return postWebhook(client, url, msg)
+		})
+		if err != nil {
+			logError("Webhook delivery failed", "url", url, "err", err)
+		}
+	}
+}
+
+// postWebhook POSTs the JSON message to the URL; any non-2xx response is an error.
+func postWebhook(client *http.Client, url string, msg []byte) error {
+	res, err := client.Post(url, "application/json", bytes.NewReader(msg))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %s", res.Status)
+	}
+	return nil
+}
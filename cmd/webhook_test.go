@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDeliverWebhooks(t *testing.T) {
+	defer func() { retrySleep = time.Sleep }()
+	retrySleep = func(time.Duration) {}
+
+	// The webhook fails its first request, then accepts.
+	requests := 0
+	received := [][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("want a JSON POST, got %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		b, _ := io.ReadAll(r.Body)
+		received = append(received, b)
+	}))
+	defer srv.Close()
+
+	orphan := generateMockHead()
+	orphan.Orphan = true
+	orphan.UncleBy = randomHex(32)
+	msg, err := json.Marshal(orphan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan []byte, 2)
+	ch <- msg
+	close(ch)
+	deliverWebhooks(ch, srv.Client(), srv.URL, 2)
+
+	if requests != 2 || len(received) != 1 {
+		t.Fatalf("want the orphan delivered on the retry, got %d requests and %d deliveries", requests, len(received))
+	}
+	got := Header{}
+	if err := json.Unmarshal(received[0], &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Hash != orphan.Hash || !got.Orphan || got.UncleBy != orphan.UncleBy {
+		t.Errorf("want the orphan with its citing block, got %+v", got)
+	}
+}
+
+func TestDeliverWebhooksGivesUp(t *testing.T) {
+	defer func() { retrySleep = time.Sleep }()
+	retrySleep = func(time.Duration) {}
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	// Failed deliveries are dropped, and the next messages delivered.
+	ch := make(chan []byte, 2)
+	ch <- []byte(`{}`)
+	ch <- []byte(`{}`)
+	close(ch)
+	deliverWebhooks(ch, srv.Client(), srv.URL, 1)
+	if requests != 4 {
+		t.Errorf("want 2 attempts per message, got %d requests", requests)
+	}
+}
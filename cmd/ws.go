@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsClientBuffer is the number of messages buffered for a WebSocket client.
+// Messages to a client whose buffer is full are dropped.
+const wsClientBuffer = 64
+
+// wsWriteTimeout is the time allowed to write a message to a WebSocket client.
+const wsWriteTimeout = 10 * time.Second
+
+// broadcastHub fans messages out to its subscribers. It is safe for concurrent use.
+// Broadcasting never blocks: a subscriber which doesn't keep up misses the messages
+// sent while its buffer is full, so that a slow client can't hold up ingestion.
+type broadcastHub struct {
+	mu   sync.Mutex
+	subs map[chan []byte]struct{}
+}
+
+func newBroadcastHub() *broadcastHub {
+	return &broadcastHub{subs: map[chan []byte]struct{}{}}
+}
+
+// orphanFeed broadcasts the orphan headers received by the side head subscription, for /ws/orphans.
+var orphanFeed = newBroadcastHub()
+
+// Subscribe returns a new subscriber's channel, buffered with size messages.
+func (h *broadcastHub) Subscribe(size int) chan []byte {
+	ch := make(chan []byte, size)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+// Unsubscribe removes the subscriber.
+func (h *broadcastHub) Unsubscribe(ch chan []byte) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+// Broadcast sends the message to every subscriber with room for it, and returns the number of subscribers which missed it.
+func (h *broadcastHub) Broadcast(msg []byte) (dropped int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- msg:
+		default:
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// BroadcastHeader broadcasts the JSON-encoded header.
+func (h *broadcastHub) BroadcastHeader(header *Header) {
+	msg, err := json.Marshal(header)
+	if err != nil {
+		logError("JSON encoding failed", withFields(header, "err", err)...)
+		return
+	}
+	if dropped := h.Broadcast(msg); dropped > 0 {
+		logWarn("Dropped header for lagging feed subscribers", withFields(header, "subscribers", dropped)...)
+	}
+}
+
+var wsUpgrader = websocket.Upgrader{
+	// Like the rest of the API (see corsHeaderHandler), any origin is allowed.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// wsFeedHandler streams the hub's messages to WebSocket clients, one text message each.
+// Clients only receive; anything they send is discarded.
+func wsFeedHandler(hub *broadcastHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := wsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			logWarn("WebSocket upgrade failed", "err", err) // The upgrader has already responded.
+			return
+		}
+		defer conn.Close()
+
+		ch := hub.Subscribe(wsClientBuffer)
+		defer hub.Unsubscribe(ch)
+
+		// Reading is needed to process the client's close and ping messages,
+		// and fails once the client is gone.
+		gone := make(chan struct{})
+		go func() {
+			defer close(gone)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		for {
+			select {
+			case <-gone:
+				return
+			case msg := <-ch:
+				conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+				if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
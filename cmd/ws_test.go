@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// subscribers returns the hub's number of subscribers.
+func (h *broadcastHub) subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// waitSubscribers waits for the hub to have n subscribers.
+func waitSubscribers(t *testing.T, hub *broadcastHub, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for hub.subscribers() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("want %d subscriber(s), have %d", n, hub.subscribers())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWSFeedHandler(t *testing.T) {
+	hub := newBroadcastHub()
+	srv := httptest.NewServer(wsFeedHandler(hub))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribers(t, hub, 1)
+
+	orphan := generateMockHead()
+	orphan.Orphan = true
+	hub.BroadcastHeader(orphan)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &Header{}
+	if err := json.Unmarshal(msg, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Hash != orphan.Hash || !got.Orphan {
+		t.Errorf("want orphan %s, got %+v", orphan.Hash, got)
+	}
+
+	// A disconnected client is unsubscribed.
+	conn.Close()
+	waitSubscribers(t, hub, 0)
+}
+
+func TestBroadcastHubDropsForLaggingSubscribers(t *testing.T) {
+	hub := newBroadcastHub()
+	lagging := hub.Subscribe(1)
+	keeping := hub.Subscribe(2)
+
+	if dropped := hub.Broadcast([]byte("1")); dropped != 0 {
+		t.Fatalf("want no drops, got %d", dropped)
+	}
+	if dropped := hub.Broadcast([]byte("2")); dropped != 1 {
+		t.Fatalf("want the lagging subscriber's message dropped, got %d drops", dropped)
+	}
+	if len(lagging) != 1 || len(keeping) != 2 {
+		t.Errorf("want 1 and 2 buffered messages, got %d and %d", len(lagging), len(keeping))
+	}
+}